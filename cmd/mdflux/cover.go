@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/frontmatter"
+)
+
+// writeCover renders the cover template at path into a temporary HTML file
+// and returns its absolute path. The caller removes the file.
+func writeCover(path string, conv *converter.Converter, meta frontmatter.Meta) (string, error) {
+	f, err := os.CreateTemp("", "mdflux-cover-*.html")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	err = renderCover(path, conv, meta, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to render cover %s: %w", path, err)
+	}
+
+	abs, err := filepath.Abs(f.Name())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return abs, nil
+}
+
+// renderCover fills the cover template with the document's front matter.
+// Markdown templates are converted like a document and share its theme,
+// HTML templates are complete pages and are escaped as HTML.
+func renderCover(path string, conv *converter.Converter, meta frontmatter.Meta, w io.Writer) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		tmpl, err := htmltemplate.New(name).Parse(string(source))
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(w, meta)
+	default:
+		tmpl, err := template.New(name).Parse(string(source))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, meta); err != nil {
+			return err
+		}
+		doc, err := conv.Parse(buf.Bytes(), path, nil)
+		if err != nil {
+			return err
+		}
+		return conv.Render(w, doc)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/diagrams"
+	"mdflux/internal/pkg/mdflux/pdf"
+)
+
+// diagramElementID is the element around the diagram on the pages that are
+// captured for PNG output.
+const diagramElementID = "mdflux-diagram"
+
+// runDiagramsExtract writes the diagrams and display equations of the input
+// to the output directory, as SVG or as PNG screenshots. KaTeX renders to
+// HTML, so equations are written as HTML pages in SVG mode.
+func runDiagramsExtract(cfg *config.Config, conv *converter.Converter) error {
+	format := cfg.Diagrams.Format
+	if format != "svg" && format != pdf.ImageFormatPNG {
+		return fmt.Errorf("invalid diagrams.format: unsupported format %q, expected svg or png", format)
+	}
+	names, err := diagrams.ParseNames(cfg.Diagrams.Names)
+	if err != nil {
+		return fmt.Errorf("invalid diagrams.names: %w", err)
+	}
+
+	doc, err := parseInput(cfg, conv)
+	if err != nil {
+		return err
+	}
+	found, err := diagrams.Collect(conv, doc)
+	if err != nil {
+		return fmt.Errorf("failed to render diagrams: %w", err)
+	}
+	if len(found) == 0 {
+		log.Warn().Msg("No diagrams found")
+		return nil
+	}
+
+	dir := cfg.Diagrams.Output
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	files := make([]string, len(found))
+	used := map[string]bool{}
+	for i, d := range found {
+		name := diagrams.FileName(d, names, len(found))
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%d", diagrams.FileName(d, names, len(found)), n)
+		}
+		used[name] = true
+		files[i] = filepath.Join(dir, name)
+	}
+
+	if format == pdf.ImageFormatPNG {
+		err = writeDiagramImages(cfg, conv, found, files)
+	} else {
+		err = writeDiagramSources(conv, found, files)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("output", dir).Int("diagrams", len(found)).Msg("Diagrams extracted successfully")
+	return nil
+}
+
+// writeDiagramSources writes diagrams as .svg files and equations as .html
+// pages.
+func writeDiagramSources(conv *converter.Converter, found []diagrams.Diagram, files []string) error {
+	for i, d := range found {
+		path := files[i] + ".svg"
+		if d.Kind == diagrams.KindMath {
+			path = files[i] + ".html"
+			if err := writeDiagramPage(path, conv, d); err != nil {
+				return err
+			}
+		} else if err := os.WriteFile(path, d.Markup, 0644); err != nil {
+			return fmt.Errorf("os.WriteFile() failed: %w", err)
+		}
+		log.Debug().Str("file", path).Msg("Wrote diagram")
+	}
+	return nil
+}
+
+// writeDiagramImages captures the diagrams as PNG and writes them.
+func writeDiagramImages(cfg *config.Config, conv *converter.Converter, found []diagrams.Diagram, files []string) error {
+	images, err := captureDiagrams(cfg, conv, found)
+	if err != nil {
+		return err
+	}
+	for i, img := range images {
+		path := files[i] + ".png"
+		if err := os.WriteFile(path, img, 0644); err != nil {
+			return fmt.Errorf("os.WriteFile() failed: %w", err)
+		}
+		log.Debug().Str("file", path).Msg("Wrote diagram")
+	}
+	return nil
+}
+
+// captureDiagrams renders every diagram on its own page and captures it
+// as PNG, in one browser session.
+func captureDiagrams(cfg *config.Config, conv *converter.Converter, found []diagrams.Diagram) ([][]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "mdflux-diagrams-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove temporary directory")
+		}
+	}()
+	tmpDir, err = filepath.Abs(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	pages := make([]string, len(found))
+	for i, d := range found {
+		pages[i] = filepath.Join(tmpDir, fmt.Sprintf("%d.html", i+1))
+		if err := writeDiagramPage(pages[i], conv, d); err != nil {
+			return nil, err
+		}
+	}
+
+	images, err := pdf.CaptureElements(pages, "#"+diagramElementID, pdf.ImageOptions{
+		Width:             cfg.Image.Width,
+		DeviceScaleFactor: cfg.Image.DeviceScaleFactor,
+		ChromeMode:        cfg.PDF.Chrome.Mode,
+		ChromePath:        cfg.PDF.Chrome.Path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("image rendering failed: %w", err)
+	}
+	return images, nil
+}
+
+// writeDiagramPage writes d alone on a page styled like the document.
+func writeDiagramPage(path string, conv *converter.Converter, d diagrams.Diagram) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	err = renderDiagramPage(f, conv, d)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func renderDiagramPage(w io.Writer, conv *converter.Converter, d diagrams.Diagram) error {
+	title := d.ID
+	if title == "" {
+		title = fmt.Sprintf("%s %d", d.Kind, d.Index)
+	}
+	if err := conv.WriteHeader(w, converter.HeaderData{Title: title}); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "<div id=\"%s\" class=\"%s\" style=\"display: inline-block; padding: 8px;\">%s</div>\n", diagramElementID, d.Kind, d.Markup); err != nil {
+		return err
+	}
+	return conv.WriteFooter(w)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/yuin/goldmark/ast"
+
+	"mdflux/internal/pkg/mdflux/book"
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/diagrams"
+	"mdflux/internal/pkg/mdflux/docx"
+	"mdflux/internal/pkg/mdflux/frontmatter"
+	"mdflux/internal/pkg/mdflux/pdf"
+)
+
+// runDOCXConversion writes the document, or every chapter of the book, as
+// a Word document. Diagrams are embedded as SVG, or as PNG screenshots
+// with docx.diagrams = "png", which also renders display math.
+func runDOCXConversion(cfg *config.Config, conv *converter.Converter, pdfOpts pdf.Options) error {
+	mode := cfg.DOCX.Diagrams
+	if mode != "svg" && mode != pdf.ImageFormatPNG {
+		return fmt.Errorf("invalid docx.diagrams: unsupported format %q, expected svg or png", mode)
+	}
+
+	log.Info().Str("format", "docx").Msg("Starting conversion")
+
+	var chapters []docx.Chapter
+	var meta *frontmatter.Meta
+	baseDir := "."
+	tocDepth := 0
+	if cfg.Book != "" {
+		manifest, err := book.Load(cfg.Book)
+		if err != nil {
+			return err
+		}
+		parsed, err := book.Parse(conv, manifest)
+		if err != nil {
+			return err
+		}
+		for i, c := range parsed {
+			chapters = append(chapters, docx.Chapter{
+				Doc:       c.Doc,
+				Anchor:    c.Anchor,
+				PageBreak: manifest.PageBreaks && (i > 0 || manifest.TOC),
+			})
+		}
+		meta = &frontmatter.Meta{Title: manifest.Title}
+		if manifest.TOC {
+			tocDepth = manifest.TOCDepth
+		}
+		baseDir = filepath.Dir(cfg.Book)
+	} else {
+		doc, err := parseInput(cfg, conv)
+		if err != nil {
+			return err
+		}
+		chapters = []docx.Chapter{{Doc: doc}}
+		meta = doc.Meta
+	}
+
+	images, err := docxDiagrams(cfg, conv, chapters, mode)
+	if err != nil {
+		return err
+	}
+
+	width, height, err := pdf.ParsePageSize(pdfOpts.PageSize)
+	if err != nil {
+		return fmt.Errorf("invalid pdf.page_size: %w", err)
+	}
+	if pdfOpts.Landscape {
+		width, height = height, width
+	}
+
+	docMeta := documentMeta(cfg, meta)
+	opts := docx.Options{
+		Metadata: docx.Metadata{
+			Title:       docMeta.Title,
+			Authors:     docMeta.Author,
+			Subject:     docMeta.Subject,
+			Description: docMeta.Description,
+			Keywords:    docMeta.Keywords,
+			Lang:        docMeta.Lang,
+			Generator:   "mdflux " + FullVersion(),
+		},
+		Reference:  cfg.DOCX.Reference,
+		BaseDir:    baseDir,
+		Diagrams:   images,
+		PageWidth:  width,
+		PageHeight: height,
+		TOCDepth:   tocDepth,
+	}
+
+	output, commit, discard, err := createArchiveOutput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer discard()
+
+	if err := docx.Write(output, conv.Templates().Template(), chapters, opts); err != nil {
+		return fmt.Errorf("DOCX conversion failed: %w", err)
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+
+	log.Info().Str("output", cfg.Output).Msg("DOCX conversion completed successfully")
+	return nil
+}
+
+// docxDiagrams renders the diagrams of the chapters for embedding. In svg
+// mode display math has no image and is written as TeX.
+func docxDiagrams(cfg *config.Config, conv *converter.Converter, chapters []docx.Chapter, mode string) (map[ast.Node]docx.Image, error) {
+	var found []diagrams.Diagram
+	for _, ch := range chapters {
+		d, err := diagrams.Collect(conv, ch.Doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render diagrams: %w", err)
+		}
+		found = append(found, d...)
+	}
+
+	images := map[ast.Node]docx.Image{}
+	if len(found) == 0 {
+		return images, nil
+	}
+	if mode == pdf.ImageFormatPNG {
+		captures, err := captureDiagrams(cfg, conv, found)
+		if err != nil {
+			return nil, err
+		}
+		for i, d := range found {
+			images[d.Node] = docx.Image{Data: captures[i], Scale: cfg.Image.DeviceScaleFactor}
+		}
+		return images, nil
+	}
+
+	for _, d := range found {
+		if d.Kind != diagrams.KindMath {
+			images[d.Node] = docx.Image{Data: d.Markup}
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+// runFmt formats the files given as arguments, or the input. Without
+// --write the result goes to the output; with --check the names of files
+// that are not formatted are printed and the command fails.
+func runFmt(cfg *config.Config, conv *converter.Converter) error {
+	opts := converter.FormatOptions{
+		Wrap:   cfg.Fmt.Wrap,
+		Width:  cfg.Fmt.Width,
+		Bullet: cfg.Fmt.Bullet,
+		Fence:  cfg.Fmt.Fence,
+	}
+
+	files := cfg.Files
+	if len(files) == 0 {
+		files = []string{cfg.Input}
+	}
+	if len(files) > 1 && !cfg.Fmt.Check && !cfg.Fmt.Write && cfg.Output != "" && cfg.Output != "-" {
+		return fmt.Errorf("cannot write %d formatted files to one output file, use --write", len(files))
+	}
+
+	var unformatted int
+	for _, file := range files {
+		var source []byte
+		var path string
+		var err error
+		if file == "" || file == "-" {
+			if cfg.Fmt.Write {
+				return fmt.Errorf("cannot write stdin back, use --output")
+			}
+			source, err = io.ReadAll(os.Stdin)
+		} else {
+			path = file
+			source, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+
+		formatted, err := conv.Format(source, path, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", displayName(path), err)
+		}
+		changed := !bytes.Equal(source, formatted)
+
+		switch {
+		case cfg.Fmt.Check:
+			if changed {
+				fmt.Println(displayName(path))
+				unformatted++
+			}
+		case cfg.Fmt.Write:
+			if !changed {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, formatted, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			log.Info().Str("file", path).Msg("Formatted")
+		default:
+			output, closeOutput, err := createOutput(cfg.Output)
+			if err != nil {
+				return err
+			}
+			_, err = output.Write(formatted)
+			closeOutput()
+			if err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+		}
+	}
+
+	if unformatted > 0 {
+		return fmt.Errorf("%d of %d files are not formatted", unformatted, len(files))
+	}
+	return nil
+}
+
+func displayName(path string) string {
+	if path == "" {
+		return "<stdin>"
+	}
+	return path
+}
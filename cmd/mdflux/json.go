@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+// runJSONConversion writes the syntax tree and front matter of the
+// document, or of every chapter of the book, as JSON.
+func runJSONConversion(cfg *config.Config, conv *converter.Converter) error {
+	log.Info().Str("format", "json").Msg("Starting conversion")
+
+	docs, err := parseDocuments(cfg, conv)
+	if err != nil {
+		return err
+	}
+
+	output, closeOutput, err := createOutput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	if err := conv.RenderJSON(output, docs); err != nil {
+		return fmt.Errorf("conversion error: %w", err)
+	}
+
+	log.Info().Msg("Conversion completed successfully")
+	return nil
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 
+	"mdflux/internal/pkg/mdflux/book"
 	"mdflux/internal/pkg/mdflux/config"
 	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/epub"
+	"mdflux/internal/pkg/mdflux/frontmatter"
 	"mdflux/internal/pkg/mdflux/mermaid"
 	"mdflux/internal/pkg/mdflux/pdf"
 	"mdflux/web"
@@ -66,27 +74,10 @@ func setupLogging(cfg *config.Config) error {
 }
 
 func run(cfg *config.Config, templates *converter.Templates) error {
-	var input io.Reader
-
-	if cfg.Input == "" || cfg.Input == "-" {
-		input = os.Stdin
-		log.Debug().Msg("Reading from stdin")
-	} else {
-		f, err := os.Open(cfg.Input)
-		if err != nil {
-			return fmt.Errorf("failed to open input file: %w", err)
-		}
-		defer func() {
-			if err := f.Close(); err != nil {
-				log.Warn().Err(err).Msg("Failed to close input file")
-			}
-		}()
-		input = f
-		log.Debug().Str("file", cfg.Input).Msg("Reading from file")
-	}
-
 	var mermaidRenderer *mermaid.Renderer
-	if cfg.Extensions.Mermaid {
+	// Formatting only compares the rendered HTML, so mermaid diagrams need
+	// not be drawn.
+	if cfg.Extensions.Mermaid && cfg.Command != config.CommandFmt {
 		chromePath := ""
 		if cfg.PDF.Chrome.Mode == "manual" {
 			chromePath = cfg.PDF.Chrome.Path
@@ -96,13 +87,73 @@ func run(cfg *config.Config, templates *converter.Templates) error {
 		log.Debug().Str("chrome_path", chromePath).Msg("Mermaid server-side rendering enabled")
 	}
 
+	if err := converter.ValidateNumberFormat(cfg.Extensions.Numbering.Format); err != nil {
+		return fmt.Errorf("invalid heading numbering: %w", err)
+	}
+
+	pdfOpts, err := pdfOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	format := cfg.Format
+	if format == "" {
+		format = "html"
+	}
+
+	watermarkCfg := cfg.HTML.Watermark
+	if format == "pdf" || format == "slides-pdf" {
+		watermarkCfg = cfg.PDF.Watermark
+	}
+	watermark, err := watermarkOptions(watermarkCfg)
+	if err != nil {
+		return err
+	}
+
+	xhtml := cfg.HTML.XHTML
+	page := ""
+	if format == "epub" {
+		// EPUB content documents are XHTML with their own page template,
+		// which has no watermark.
+		xhtml = true
+		page = "epub"
+	}
+	printStyles := pdfOpts.PrintCSS()
+	if format == "slides" || format == "slides-pdf" {
+		// Slides print one per page, so the pagination rules of documents
+		// do not apply.
+		xhtml = false
+		page = "slides"
+		if printStyles, err = slidePrintCSS(cfg.Slides.PageSize); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Command == config.CommandSite {
+		// Site pages share a layout with navigation around the content.
+		page = "site"
+	}
+	if format == "man" {
+		// Option lists of manual pages are definition lists.
+		cfg.Extensions.DefinitionList = true
+	}
+
+	assets, err := htmlAssets(cfg, format)
+	if err != nil {
+		return err
+	}
+
 	conv := converter.New(converter.Options{
 		Unsafe:              cfg.HTML.Unsafe,
 		HardWraps:           cfg.HTML.HardWraps,
-		XHTML:               cfg.HTML.XHTML,
+		XHTML:               xhtml,
+		Page:                page,
 		Theme:               cfg.Theme,
 		EastAsianLineBreaks: cfg.HTML.EastAsianLineBreaks,
+		PrintStyles:         printStyles,
+		Watermark:           watermark,
 		MermaidRenderer:     mermaidRenderer,
+		Assets:              assets,
 		Extensions: converter.ExtensionOptions{
 			Table:          cfg.Extensions.Table,
 			Strikethrough:  cfg.Extensions.Strikethrough,
@@ -117,26 +168,135 @@ func run(cfg *config.Config, templates *converter.Templates) error {
 				Layout:  cfg.Extensions.D2.Layout,
 				ThemeID: cfg.Extensions.D2.ThemeID,
 			},
-			KaTeX:   cfg.Extensions.KaTeX,
-			Mermaid: cfg.Extensions.Mermaid,
+			KaTeX:       cfg.Extensions.KaTeX,
+			Mermaid:     cfg.Extensions.Mermaid,
+			Include:     cfg.Extensions.Include,
+			Crossref:    cfg.Extensions.Crossref,
+			Admonitions: cfg.Extensions.Admonitions,
+			Attributes:  cfg.Extensions.Attributes,
+			PageBreaks:  cfg.Extensions.PageBreaks,
+			Numbering: converter.NumberingOptions{
+				Enabled:    cfg.Extensions.Numbering.Enabled,
+				StartLevel: cfg.Extensions.Numbering.StartLevel,
+				MaxLevel:   cfg.Extensions.Numbering.MaxLevel,
+				Format:     cfg.Extensions.Numbering.Format,
+			},
+
+			IncludeOutsideRoot: cfg.Extensions.IncludeOutsideRoot,
 		},
 	}, templates)
 
-	format := cfg.Format
-	if format == "" {
-		format = "html"
+	if cfg.Command == config.CommandDiagramsExtract {
+		return runDiagramsExtract(cfg, conv)
+	}
+	if cfg.Command == config.CommandFmt {
+		return runFmt(cfg, conv)
+	}
+	if cfg.Command == config.CommandSite {
+		return runSite(cfg, conv)
+	}
+	if format == "docx" {
+		return runDOCXConversion(cfg, conv, pdfOpts)
+	}
+	if format == "text" || format == "ansi" {
+		return runTextConversion(cfg, conv, format)
+	}
+	if format == "json" {
+		return runJSONConversion(cfg, conv)
+	}
+	if format == "slides" || format == "slides-pdf" {
+		return runSlidesConversion(cfg, conv, format, pdfOpts)
+	}
+	if format == "man" {
+		return runManConversion(cfg, conv)
 	}
 
 	log.Info().Str("format", format).Msg("Starting conversion")
 
+	render := func(w io.Writer) (*frontmatter.Meta, error) {
+		return convertInput(cfg, conv, w)
+	}
+	if cfg.Book != "" {
+		manifest, err := book.Load(cfg.Book)
+		if err != nil {
+			return err
+		}
+		log.Debug().Str("manifest", cfg.Book).Int("chapters", len(manifest.Chapters)).Msg("Building book")
+		render = func(w io.Writer) (*frontmatter.Meta, error) {
+			return &frontmatter.Meta{Title: manifest.Title}, book.Build(conv, manifest, w)
+		}
+	}
+
 	if format == "pdf" {
-		return runPDFConversion(cfg, conv, input)
+		return runPDFConversion(cfg, conv, pdfOpts, render)
+	}
+	if format == pdf.ImageFormatPNG || format == pdf.ImageFormatJPEG || format == "jpg" {
+		return runImageConversion(cfg, format, render)
 	}
+	if format == "epub" {
+		return runEPUBConversion(cfg, conv, render)
+	}
+
+	return runHTMLConversion(cfg, render)
+}
 
-	return runHTMLConversion(cfg, conv, input)
+// convertInput renders the configured input into w and returns its front
+// matter. Include directives in files resolve relative to the input.
+func convertInput(cfg *config.Config, conv *converter.Converter, w io.Writer) (*frontmatter.Meta, error) {
+	doc, err := parseInput(cfg, conv)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Meta, conv.Render(w, doc)
 }
 
-func runHTMLConversion(cfg *config.Config, conv *converter.Converter, input io.Reader) error {
+// parseInput reads and parses the configured input file or stdin.
+func parseInput(cfg *config.Config, conv *converter.Converter) (*converter.Document, error) {
+	var source []byte
+	var path string
+	var err error
+	if cfg.Input == "" || cfg.Input == "-" {
+		log.Debug().Msg("Reading from stdin")
+		source, err = io.ReadAll(os.Stdin)
+	} else {
+		log.Debug().Str("file", cfg.Input).Msg("Reading from file")
+		path = cfg.Input
+		source, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	return conv.Parse(source, path, nil)
+}
+
+// parseDocuments parses the configured input, or every chapter of the
+// book.
+func parseDocuments(cfg *config.Config, conv *converter.Converter) ([]*converter.Document, error) {
+	if cfg.Book == "" {
+		doc, err := parseInput(cfg, conv)
+		if err != nil {
+			return nil, err
+		}
+		return []*converter.Document{doc}, nil
+	}
+
+	manifest, err := book.Load(cfg.Book)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := book.Parse(conv, manifest)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]*converter.Document, len(chapters))
+	for i, c := range chapters {
+		docs[i] = c.Doc
+	}
+	return docs, nil
+}
+
+func runHTMLConversion(cfg *config.Config, render func(io.Writer) (*frontmatter.Meta, error)) error {
 	var output io.Writer
 
 	if cfg.Output == "" || cfg.Output == "-" {
@@ -156,7 +316,7 @@ func runHTMLConversion(cfg *config.Config, conv *converter.Converter, input io.R
 		log.Debug().Str("file", cfg.Output).Msg("Writing to file")
 	}
 
-	if err := conv.ConvertReader(input, output); err != nil {
+	if _, err := render(output); err != nil {
 		return fmt.Errorf("conversion error: %w", err)
 	}
 
@@ -164,54 +324,273 @@ func runHTMLConversion(cfg *config.Config, conv *converter.Converter, input io.R
 	return nil
 }
 
-func runPDFConversion(cfg *config.Config, conv *converter.Converter, input io.Reader) error {
+// htmlAssets returns where diagrams go with html.assets = "external".
+// Asset files are written to html.assets_dir, relative to the output file,
+// or next to it. PDF and image output always inline diagrams.
+func htmlAssets(cfg *config.Config, format string) (*converter.Assets, error) {
+	switch cfg.HTML.Assets {
+	case "", "inline":
+		return nil, nil
+	case "external":
+	default:
+		return nil, fmt.Errorf("invalid html.assets: unknown mode %q, expected inline or external", cfg.HTML.Assets)
+	}
+	if format != "html" || cfg.Command != "" {
+		return nil, nil
+	}
 	if cfg.Output == "" || cfg.Output == "-" {
-		return fmt.Errorf("PDF output requires a file path, cannot write to stdout")
+		return nil, fmt.Errorf("invalid html.assets: external assets require an output file, cannot write to stdout")
 	}
 
-	tmpFile, err := os.CreateTemp("", "mdflux-*.html")
+	assets := &converter.Assets{Dir: filepath.Join(filepath.Dir(cfg.Output), cfg.HTML.AssetsDir)}
+	if err := assets.RelativeTo(cfg.Output); err != nil {
+		return nil, fmt.Errorf("invalid html.assets_dir: %w", err)
+	}
+	return assets, nil
+}
+
+// pdfOptions maps the [pdf] page layout onto the PDF renderer options and
+// validates the page size and margins. The layout also sets the print
+// styles of HTML output.
+func pdfOptions(cfg *config.Config) (pdf.Options, error) {
+	opts := pdf.Options{
+		PageSize:          cfg.PDF.PageSize,
+		Landscape:         cfg.PDF.Landscape,
+		Scale:             cfg.PDF.Scale,
+		Outline:           cfg.PDF.Outline,
+		PreferCSSPageSize: cfg.PDF.PreferCSSPageSize,
+		Pagination: pdf.Pagination{
+			KeepHeadingsWithNext: cfg.PDF.KeepHeadingsWithNext,
+			AvoidBreakInside:     cfg.PDF.AvoidBreakInside,
+			H1NewPage:            cfg.PDF.H1NewPage,
+		},
+		ChromeMode: cfg.PDF.Chrome.Mode,
+		ChromePath: cfg.PDF.Chrome.Path,
+	}
+
+	if _, _, err := pdf.ParsePageSize(opts.PageSize); err != nil {
+		return opts, fmt.Errorf("invalid pdf.page_size: %w", err)
+	}
+
+	err := opts.SetMargins(pdf.Margins{
+		Preset: cfg.PDF.Margins,
+		Top:    cfg.PDF.MarginTop,
+		Bottom: cfg.PDF.MarginBottom,
+		Left:   cfg.PDF.MarginLeft,
+		Right:  cfg.PDF.MarginRight,
+		Inner:  cfg.PDF.MarginInner,
+		Outer:  cfg.PDF.MarginOuter,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return opts, fmt.Errorf("invalid pdf margins: %w", err)
 	}
-	tempHTMLPath := tmpFile.Name()
-	defer func() {
-		if err := os.Remove(tempHTMLPath); err != nil {
-			log.Warn().Err(err).Msg("Failed to remove temporary file")
+
+	return opts, nil
+}
+
+// pdfOutputOptions adds the archival level, the merged documents and the
+// encryption of the written PDF to opts. It reads the encryption passwords,
+// so it is only called when a PDF is written.
+func pdfOutputOptions(cfg *config.Config, opts pdf.Options) (pdf.Options, error) {
+	archival, err := pdf.ParseArchival(cfg.PDF.Archival)
+	if err != nil {
+		return opts, fmt.Errorf("invalid pdf.archival: %w", err)
+	}
+	opts.Archival = archival
+
+	for _, path := range append(append([]string{cfg.PDF.Merge.Cover}, cfg.PDF.Merge.Prepend...), cfg.PDF.Merge.Append...) {
+		if path == "" {
+			continue
 		}
-	}()
+		if _, err := os.Stat(path); err != nil {
+			return opts, fmt.Errorf("invalid pdf.merge: %w", err)
+		}
+	}
+	opts.Prepend = cfg.PDF.Merge.Prepend
+	opts.Append = cfg.PDF.Merge.Append
 
-	log.Debug().Str("temp_file", tempHTMLPath).Msg("Created temporary HTML file")
+	if cfg.PDF.Encryption.Enabled {
+		if opts.Archival != "" {
+			return opts, fmt.Errorf("invalid pdf.encryption: %s does not allow encryption", opts.Archival)
+		}
+		enc, err := pdfEncryption(cfg.PDF.Encryption)
+		if err != nil {
+			return opts, fmt.Errorf("invalid pdf.encryption: %w", err)
+		}
+		opts.Encryption = &enc
+	}
 
-	if err := conv.ConvertReader(input, tmpFile); err != nil {
-		if closeErr := tmpFile.Close(); closeErr != nil {
-			log.Warn().Err(closeErr).Msg("Failed to close temporary file")
+	return opts, nil
+}
+
+// pdfEncryption reads the passwords for PDF encryption. A password file
+// takes precedence over the environment variable.
+func pdfEncryption(ec config.EncryptionConfig) (pdf.Encryption, error) {
+	enc := pdf.Encryption{
+		AllowPrint:    ec.AllowPrint,
+		AllowCopy:     ec.AllowCopy,
+		AllowModify:   ec.AllowModify,
+		AllowAnnotate: ec.AllowAnnotate,
+	}
+
+	var err error
+	if enc.UserPassword, err = readSecret(ec.UserPasswordEnv, ec.UserPasswordFile); err != nil {
+		return enc, fmt.Errorf("user password: %w", err)
+	}
+	if enc.OwnerPassword, err = readSecret(ec.OwnerPasswordEnv, ec.OwnerPasswordFile); err != nil {
+		return enc, fmt.Errorf("owner password: %w", err)
+	}
+	if enc.OwnerPassword == "" {
+		return enc, fmt.Errorf("no owner password: set $%s or owner_password_file", ec.OwnerPasswordEnv)
+	}
+	return enc, nil
+}
+
+// readSecret returns the content of file without a trailing line break,
+// or the value of the environment variable env when file is empty.
+func readSecret(env, file string) (string, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
 		}
-		return fmt.Errorf("conversion error: %w", err)
+		return strings.TrimRight(string(b), "\r\n"), nil
 	}
-	if err := tmpFile.Close(); err != nil {
-		log.Warn().Err(err).Msg("Failed to close temporary file")
+	if env == "" {
+		return "", nil
 	}
+	return os.Getenv(env), nil
+}
 
-	absHTMLPath, err := filepath.Abs(tempHTMLPath)
+// watermarkOptions maps a watermark section onto the converter options.
+// Local images are embedded as data URIs so that the output does not
+// depend on the image's location.
+func watermarkOptions(wc config.WatermarkConfig) (converter.Watermark, error) {
+	w := converter.Watermark{
+		Enabled:  wc.Enabled,
+		Statuses: wc.Statuses,
+		Text:     wc.Text,
+		Image:    wc.Image,
+		Opacity:  wc.Opacity,
+		Angle:    wc.Angle,
+		Color:    wc.Color,
+	}
+	if err := w.Validate(); err != nil {
+		return w, fmt.Errorf("invalid watermark: %w", err)
+	}
+
+	if w.Image != "" && !strings.Contains(w.Image, "://") && !strings.HasPrefix(w.Image, "data:") {
+		data, err := os.ReadFile(w.Image)
+		if err != nil {
+			return w, fmt.Errorf("invalid watermark image: %w", err)
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(w.Image))
+		if mimeType == "" {
+			mimeType = http.DetectContentType(data)
+		}
+		w.Image = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return w, nil
+}
+
+// documentMeta combines the document's front matter with the
+// [pdf.metadata] defaults. Front matter wins where both are set.
+func documentMeta(cfg *config.Config, meta *frontmatter.Meta) frontmatter.Meta {
+	m := frontmatter.Meta{}
+	if meta != nil {
+		m = *meta
+	}
+	return m.Merge(frontmatter.Meta{
+		Title:    cfg.PDF.Metadata.Title,
+		Author:   cfg.PDF.Metadata.Author,
+		Subject:  cfg.PDF.Metadata.Subject,
+		Keywords: cfg.PDF.Metadata.Keywords,
+		Date:     cfg.PDF.Metadata.Date,
+	})
+}
+
+// pdfMetadata maps document metadata onto the PDF document properties.
+func pdfMetadata(m frontmatter.Meta) pdf.Metadata {
+	subject := m.Subject
+	if subject == "" {
+		subject = m.Description
+	}
+	md := pdf.Metadata{
+		Title:    m.Title,
+		Author:   m.Author,
+		Subject:  subject,
+		Keywords: m.Keywords,
+		Lang:     m.Lang,
+		Creator:  "mdflux " + FullVersion(),
+	}
+	if m.Date != "" {
+		created, err := parseDate(m.Date)
+		if err != nil {
+			log.Warn().Str("date", m.Date).Msg("Unrecognized date, using the current time as the PDF creation date")
+		}
+		md.CreationDate = created
+	}
+	return md
+}
+
+// dateLayouts are the accepted formats of the front matter date.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDate parses a front matter date. Dates without a time zone are in
+// local time.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(s), time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+func runPDFConversion(cfg *config.Config, conv *converter.Converter, pdfOpts pdf.Options, render func(io.Writer) (*frontmatter.Meta, error)) error {
+	if cfg.Output == "" || cfg.Output == "-" {
+		return fmt.Errorf("PDF output requires a file path, cannot write to stdout")
+	}
+	pdfOpts, err := pdfOutputOptions(cfg, pdfOpts)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
 
+	absHTMLPath, meta, err := writeTempHTML(render)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(absHTMLPath); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove temporary file")
+		}
+	}()
+
 	absPDFPath, err := filepath.Abs(cfg.Output)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path for output: %w", err)
 	}
 
-	pdfOpts := pdf.Options{
-		PageSize:     cfg.PDF.PageSize,
-		Landscape:    cfg.PDF.Landscape,
-		Scale:        cfg.PDF.Scale,
-		MarginTop:    cfg.PDF.MarginTop,
-		MarginBottom: cfg.PDF.MarginBottom,
-		MarginLeft:   cfg.PDF.MarginLeft,
-		MarginRight:  cfg.PDF.MarginRight,
-		ChromeMode:   cfg.PDF.Chrome.Mode,
-		ChromePath:   cfg.PDF.Chrome.Path,
+	docMeta := documentMeta(cfg, meta)
+	pdfOpts.Metadata = pdfMetadata(docMeta)
+
+	if cfg.PDF.Merge.Cover != "" {
+		coverPath, err := writeCover(cfg.PDF.Merge.Cover, conv, docMeta)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.Remove(coverPath); err != nil {
+				log.Warn().Err(err).Msg("Failed to remove temporary file")
+			}
+		}()
+		pdfOpts.CoverHTMLPath = coverPath
 	}
 
 	log.Debug().Str("html_path", absHTMLPath).Str("pdf_path", absPDFPath).Msg("Rendering PDF")
@@ -223,3 +602,216 @@ func runPDFConversion(cfg *config.Config, conv *converter.Converter, input io.Re
 	log.Info().Str("output", absPDFPath).Msg("PDF conversion completed successfully")
 	return nil
 }
+
+// writeTempHTML renders the document into a temporary HTML file for Chrome
+// and returns its absolute path. The caller removes the file.
+func writeTempHTML(render func(io.Writer) (*frontmatter.Meta, error)) (string, *frontmatter.Meta, error) {
+	tmpFile, err := os.CreateTemp("", "mdflux-*.html")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempHTMLPath := tmpFile.Name()
+
+	log.Debug().Str("temp_file", tempHTMLPath).Msg("Created temporary HTML file")
+
+	remove := func() {
+		if err := os.Remove(tempHTMLPath); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove temporary file")
+		}
+	}
+
+	meta, err := render(tmpFile)
+	if closeErr := tmpFile.Close(); closeErr != nil {
+		log.Warn().Err(closeErr).Msg("Failed to close temporary file")
+	}
+	if err != nil {
+		remove()
+		return "", nil, fmt.Errorf("conversion error: %w", err)
+	}
+
+	absHTMLPath, err := filepath.Abs(tempHTMLPath)
+	if err != nil {
+		remove()
+		return "", nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return absHTMLPath, meta, nil
+}
+
+// runImageConversion takes screenshots of the HTML output. A single image
+// is written to the output path, tiles are numbered name-1.png, name-2.png
+// and so on.
+func runImageConversion(cfg *config.Config, format string, render func(io.Writer) (*frontmatter.Meta, error)) error {
+	opts := pdf.ImageOptions{
+		Format:            format,
+		Width:             cfg.Image.Width,
+		DeviceScaleFactor: cfg.Image.DeviceScaleFactor,
+		TileHeight:        cfg.Image.TileHeight,
+		Quality:           cfg.Image.Quality,
+		ChromeMode:        cfg.PDF.Chrome.Mode,
+		ChromePath:        cfg.PDF.Chrome.Path,
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid image options: %w", err)
+	}
+	toStdout := cfg.Output == "" || cfg.Output == "-"
+	if toStdout && opts.TileHeight > 0 {
+		return fmt.Errorf("tiled image output requires a file path, cannot write to stdout")
+	}
+
+	absHTMLPath, _, err := writeTempHTML(render)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(absHTMLPath); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove temporary file")
+		}
+	}()
+
+	log.Debug().Str("html_path", absHTMLPath).Str("format", format).Msg("Rendering image")
+
+	images, err := pdf.RenderHTMLToImages(absHTMLPath, opts)
+	if err != nil {
+		return fmt.Errorf("image rendering failed: %w", err)
+	}
+
+	if toStdout {
+		if _, err := os.Stdout.Write(images[0]); err != nil {
+			return fmt.Errorf("failed to write image: %w", err)
+		}
+		log.Info().Msg("Image conversion completed successfully")
+		return nil
+	}
+
+	for i, img := range images {
+		path := cfg.Output
+		if opts.TileHeight > 0 {
+			ext := filepath.Ext(path)
+			path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+		}
+		if err := os.WriteFile(path, img, 0644); err != nil {
+			return fmt.Errorf("os.WriteFile() failed: %w", err)
+		}
+		log.Debug().Str("file", path).Msg("Wrote image")
+	}
+
+	log.Info().Str("output", cfg.Output).Int("images", len(images)).Msg("Image conversion completed successfully")
+	return nil
+}
+
+// runEPUBConversion packages the XHTML output as an EPUB 3 publication.
+// Relative image paths, and the cover and identifier front matter keys,
+// resolve against the input file or book manifest.
+func runEPUBConversion(cfg *config.Config, conv *converter.Converter, render func(io.Writer) (*frontmatter.Meta, error)) error {
+	var content bytes.Buffer
+	meta, err := render(&content)
+	if err != nil {
+		return fmt.Errorf("conversion error: %w", err)
+	}
+	docMeta := documentMeta(cfg, meta)
+
+	baseDir := "."
+	if cfg.Book != "" {
+		baseDir = filepath.Dir(cfg.Book)
+	} else if cfg.Input != "" && cfg.Input != "-" {
+		baseDir = filepath.Dir(cfg.Input)
+	}
+
+	cover := cfg.EPUB.Cover
+	if v, ok := docMeta.Params["cover"].(string); ok && v != "" {
+		cover = filepath.Join(baseDir, v)
+	}
+	identifier := cfg.EPUB.Identifier
+	if v, ok := docMeta.Params["identifier"].(string); ok && v != "" {
+		identifier = v
+	}
+
+	opts := epub.Options{
+		Metadata: epub.Metadata{
+			Identifier:  identifier,
+			Title:       docMeta.Title,
+			Authors:     docMeta.Author,
+			Subjects:    docMeta.Keywords,
+			Description: docMeta.Description,
+			Date:        docMeta.Date,
+			Lang:        docMeta.Lang,
+		},
+		Styles:  conv.Templates().Styles(),
+		BaseDir: baseDir,
+		Cover:   cover,
+	}
+	if opts.Metadata.Description == "" {
+		opts.Metadata.Description = docMeta.Subject
+	}
+
+	output, commit, discard, err := createArchiveOutput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer discard()
+
+	if err := epub.Build(output, conv.Templates().Template(), content.Bytes(), opts); err != nil {
+		return fmt.Errorf("EPUB packaging failed: %w", err)
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+
+	log.Info().Str("output", cfg.Output).Msg("EPUB conversion completed successfully")
+	return nil
+}
+
+// createOutput creates the output file, or returns stdout when path is
+// empty or "-". The returned function closes the file.
+func createOutput(path string) (io.Writer, func(), error) {
+	if path == "" || path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return f, func() {
+		if err := f.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to close output file")
+		}
+	}, nil
+}
+
+// createArchiveOutput is createOutput for EPUB and DOCX packages, which are
+// useless when truncated. A file is written to a temporary file in the
+// directory of path that replaces path when commit is called. discard
+// removes the temporary file unless it was committed.
+func createArchiveOutput(path string) (w io.Writer, commit func() error, discard func(), err error) {
+	if path == "" || path == "-" {
+		return os.Stdout, func() error { return nil }, func() {}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	committed := false
+	commit = func() error {
+		if err := f.Chmod(0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		committed = true
+		return nil
+	}
+	discard = func() {
+		if committed {
+			return
+		}
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove temporary file")
+		}
+	}
+	return f, commit, discard, nil
+}
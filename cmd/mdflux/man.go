@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+// runManConversion writes the document as a man page. A manual page is a
+// single document, so books are not supported.
+func runManConversion(cfg *config.Config, conv *converter.Converter) error {
+	if cfg.Book != "" {
+		return fmt.Errorf("man output renders a single input, not a book")
+	}
+
+	log.Info().Str("format", "man").Msg("Starting conversion")
+
+	doc, err := parseInput(cfg, conv)
+	if err != nil {
+		return err
+	}
+
+	output, closeOutput, err := createOutput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	opts := converter.ManOptions{
+		Section: cfg.Man.Section,
+		Source:  cfg.Man.Source,
+		Manual:  cfg.Man.Manual,
+	}
+	if err := conv.RenderMan(output, doc, opts); err != nil {
+		return fmt.Errorf("conversion error: %w", err)
+	}
+
+	log.Info().Msg("Conversion completed successfully")
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/site"
+)
+
+// runSite builds a static HTML site from the markdown files of the source
+// directory.
+func runSite(cfg *config.Config, conv *converter.Converter) error {
+	log.Info().Str("source", cfg.Site.Source).Str("output", cfg.Site.Output).Msg("Building site")
+
+	pages, err := site.Build(conv, site.Options{
+		Source: cfg.Site.Source,
+		Output: cfg.Site.Output,
+		Title:  cfg.Site.Title,
+		Search: cfg.Site.Search,
+	})
+	if err != nil {
+		return fmt.Errorf("site build failed: %w", err)
+	}
+
+	log.Info().Str("output", cfg.Site.Output).Int("pages", pages).Msg("Site built successfully")
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/frontmatter"
+	"mdflux/internal/pkg/mdflux/pdf"
+)
+
+// runSlidesConversion writes the document, or every chapter of the book,
+// as an HTML slide deck, or prints the deck with one slide per landscape
+// page with format "slides-pdf".
+func runSlidesConversion(cfg *config.Config, conv *converter.Converter, format string, pdfOpts pdf.Options) error {
+	opts := converter.SlideOptions{Split: cfg.Slides.Split}
+
+	render := func(w io.Writer) (*frontmatter.Meta, error) {
+		docs, err := parseDocuments(cfg, conv)
+		if err != nil {
+			return nil, err
+		}
+		if err := conv.RenderSlides(w, docs, opts); err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			return nil, nil
+		}
+		return docs[0].Meta, nil
+	}
+
+	if format == "slides" {
+		log.Info().Str("format", format).Msg("Starting conversion")
+		return runHTMLConversion(cfg, render)
+	}
+
+	width, height, err := pdf.ParsePageSize(cfg.Slides.PageSize)
+	if err != nil {
+		return fmt.Errorf("invalid slides.page_size: %w", err)
+	}
+	// The PDF renderer swaps the sides of landscape pages.
+	pdfOpts.PageSize = cfg.Slides.PageSize
+	pdfOpts.Landscape = height > width
+	pdfOpts.Scale = 1
+	pdfOpts.MarginTop, pdfOpts.MarginBottom, pdfOpts.MarginLeft, pdfOpts.MarginRight = 0, 0, 0, 0
+	pdfOpts.MirrorMargins = false
+	pdfOpts.PreferCSSPageSize = false
+
+	log.Info().Str("format", format).Msg("Starting conversion")
+	return runPDFConversion(cfg, conv, pdfOpts, render)
+}
+
+// slidePrintCSS sizes every slide to one landscape page of the configured
+// slide page size when the deck is printed.
+func slidePrintCSS(pageSize string) (string, error) {
+	width, height, err := pdf.ParsePageSize(pageSize)
+	if err != nil {
+		return "", fmt.Errorf("invalid slides.page_size: %w", err)
+	}
+	if height > width {
+		width, height = height, width
+	}
+	w := strconv.FormatFloat(width, 'f', 4, 64) + "in"
+	h := strconv.FormatFloat(height, 'f', 4, 64) + "in"
+	return "\n@page { size: " + w + " " + h + "; margin: 0; }\n" +
+		"@media print {\n  html.slides .slide { width: " + w + "; height: " + h + "; }\n}\n", nil
+}
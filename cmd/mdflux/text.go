@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/config"
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+// runTextConversion writes the document, or every chapter of the book, as
+// wrapped plain text, or styled for terminals with format "ansi".
+func runTextConversion(cfg *config.Config, conv *converter.Converter, format string) error {
+	if cfg.Text.Diagrams != "ascii" && cfg.Text.Diagrams != "placeholder" {
+		return fmt.Errorf("invalid text.diagrams: unsupported mode %q, expected ascii or placeholder", cfg.Text.Diagrams)
+	}
+	if cfg.Text.Width < 0 {
+		return fmt.Errorf("invalid text.width: %d, expected 0 or more", cfg.Text.Width)
+	}
+
+	log.Info().Str("format", format).Msg("Starting conversion")
+
+	docs, err := parseDocuments(cfg, conv)
+	if err != nil {
+		return err
+	}
+
+	output, closeOutput, err := createOutput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	opts := converter.TextOptions{
+		Width:    cfg.Text.Width,
+		ANSI:     format == "ansi",
+		Diagrams: cfg.Text.Diagrams,
+	}
+	for i, doc := range docs {
+		if i > 0 {
+			if _, err := fmt.Fprintln(output); err != nil {
+				return fmt.Errorf("conversion error: %w", err)
+			}
+		}
+		if err := conv.RenderText(output, doc, opts); err != nil {
+			return fmt.Errorf("conversion error: %w", err)
+		}
+	}
+
+	log.Info().Msg("Conversion completed successfully")
+	return nil
+}
@@ -0,0 +1,234 @@
+package book
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"mdflux/internal/pkg/mdflux/converter"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+)
+
+// ParsedChapter is a parsed chapter of a book.
+type ParsedChapter struct {
+	Doc *converter.Document
+	// Anchor is the ID of the chapter in the combined document.
+	Anchor string
+	// Title is the title given in the manifest, or empty.
+	Title string
+
+	path string
+	ids  map[string]string
+}
+
+// Build renders every chapter of m into a single HTML document written to w.
+// Chapters share one heading ID namespace and numbering sequence, links
+// between chapter files are rewritten to in-document anchors and an optional
+// table of contents covering the whole book is placed in front.
+func Build(conv *converter.Converter, m *Manifest, w io.Writer) error {
+	chapters, err := Parse(conv, m)
+	if err != nil {
+		return err
+	}
+
+	var headings []converter.Heading
+	for _, c := range chapters {
+		chapterHeadings := converter.Headings(c.Doc)
+		if len(chapterHeadings) == 0 && c.Title != "" {
+			chapterHeadings = []converter.Heading{{Level: 1, ID: c.Anchor, Text: c.Title}}
+		}
+		for _, h := range chapterHeadings {
+			if h.Level <= m.TOCDepth {
+				headings = append(headings, h)
+			}
+		}
+	}
+
+	// Meta tags come from the first chapter, and the status, which can
+	// enable the watermark, from the first chapter that sets one.
+	header := converter.DocumentHeader(chapters[0].Doc)
+	header.Title = m.Title
+	if header.Title == "" {
+		header.Title = "Book"
+	}
+	for _, c := range chapters {
+		if c.Doc.Meta != nil && c.Doc.Meta.Status != "" {
+			header.Status = c.Doc.Meta.Status
+			break
+		}
+	}
+	if err := conv.WriteHeader(w, header); err != nil {
+		return err
+	}
+
+	if m.TOC {
+		if err := conv.Templates().Template().ExecuteTemplate(w, "book-toc", converter.BuildTOC(headings)); err != nil {
+			return fmt.Errorf("failed to execute book-toc template: %w", err)
+		}
+	}
+
+	for i, c := range chapters {
+		class := "chapter"
+		if m.PageBreaks && (i > 0 || m.TOC) {
+			class += " chapter-break"
+		}
+		if _, err := fmt.Fprintf(w, "<div class=\"%s\" id=\"%s\">\n", class, c.Anchor); err != nil {
+			return fmt.Errorf("failed to write chapter: %w", err)
+		}
+		if err := conv.RenderBody(w, c.Doc); err != nil {
+			return fmt.Errorf("chapter %s: %w", m.Chapters[i].Path, err)
+		}
+		if _, err := io.WriteString(w, "</div>\n"); err != nil {
+			return fmt.Errorf("failed to write chapter: %w", err)
+		}
+	}
+
+	return conv.WriteFooter(w)
+}
+
+// Parse parses every chapter of m. Chapters share one heading ID namespace,
+// numbering sequence and set of cross-references, and links between
+// chapter files are rewritten to anchors of the combined document.
+func Parse(conv *converter.Converter, m *Manifest) ([]*ParsedChapter, error) {
+	ids := newBookIDs()
+
+	numbering := conv.NumberingOptions()
+	numbering.Enabled = m.Numbering
+	numberer := converter.NewHeadingNumberer(numbering)
+	crossrefs := converter.NewCrossrefs()
+
+	chapters := make([]*ParsedChapter, len(m.Chapters))
+	byPath := make(map[string]*ParsedChapter, len(m.Chapters))
+
+	for i, ch := range m.Chapters {
+		anchor := fmt.Sprintf("chapter-%d", i+1)
+		ids.Put([]byte(anchor))
+		chapters[i] = &ParsedChapter{Anchor: anchor, Title: ch.Title}
+	}
+
+	for i, ch := range m.Chapters {
+		path := filepath.Join(m.Dir, filepath.FromSlash(ch.Path))
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chapter: %w", err)
+		}
+
+		ids.startChapter()
+		pc := parser.NewContext(parser.WithIDs(ids))
+		converter.WithHeadingNumberer(pc, numberer)
+		converter.WithCrossrefs(pc, crossrefs)
+		converter.WithIncludeRoot(pc, m.Dir)
+		doc, err := conv.Parse(source, path, pc)
+		if err != nil {
+			return nil, fmt.Errorf("chapter %s: %w", ch.Path, err)
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		c := chapters[i]
+		c.Doc = doc
+		c.path = abs
+		c.ids = ids.chapter
+		byPath[abs] = c
+	}
+
+	if err := crossrefs.Check(); err != nil {
+		return nil, fmt.Errorf("cross-reference check failed: %w", err)
+	}
+
+	for _, c := range chapters {
+		rewriteLinks(c, byPath)
+	}
+	return chapters, nil
+}
+
+// rewriteLinks points links to other chapters, and fragments within the
+// chapter, at the IDs the headings received in the combined document.
+func rewriteLinks(c *ParsedChapter, byPath map[string]*ParsedChapter) {
+	_ = ast.Walk(c.Doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		link, ok := n.(*ast.Link)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		if dest, ok := resolveLink(c, string(link.Destination), byPath); ok {
+			link.Destination = []byte(dest)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func resolveLink(c *ParsedChapter, dest string, byPath map[string]*ParsedChapter) (string, bool) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+
+	target := c
+	if u.Path != "" {
+		if filepath.IsAbs(u.Path) {
+			return "", false
+		}
+		abs := filepath.Join(filepath.Dir(c.path), filepath.FromSlash(u.Path))
+		t, ok := byPath[abs]
+		if !ok {
+			return "", false
+		}
+		target = t
+	}
+
+	if u.Fragment == "" {
+		return "#" + target.Anchor, true
+	}
+	if id, ok := target.ids[u.Fragment]; ok {
+		return "#" + id, true
+	}
+	return "#" + u.Fragment, true
+}
+
+// bookIDs generates heading IDs that are unique across all chapters while
+// remembering which ID each chapter would have used on its own, so that
+// links written against a single chapter can be rewritten.
+type bookIDs struct {
+	used    map[string]bool
+	local   parser.IDs
+	chapter map[string]string
+}
+
+func newBookIDs() *bookIDs {
+	return &bookIDs{used: map[string]bool{}}
+}
+
+func (b *bookIDs) startChapter() {
+	b.local = parser.NewContext().IDs()
+	b.chapter = map[string]string{}
+}
+
+func (b *bookIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	local := string(b.local.Generate(value, kind))
+	global := local
+	for i := 1; b.used[global]; i++ {
+		global = fmt.Sprintf("%s-%d", local, i)
+	}
+	b.used[global] = true
+	b.chapter[local] = global
+	return []byte(global)
+}
+
+func (b *bookIDs) Put(value []byte) {
+	id := string(value)
+	b.used[id] = true
+	if b.local != nil {
+		b.local.Put(value)
+		b.chapter[id] = id
+	}
+}
@@ -0,0 +1,130 @@
+package book
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultTOCDepth = 3
+	summaryTitle    = "Summary"
+)
+
+var (
+	summaryHeadingPattern = regexp.MustCompile(`^#\s+(.+?)\s*#*\s*$`)
+	summaryLinkPattern    = regexp.MustCompile(`^\s*(?:[-*+]\s+)?\[(.+?)\]\((.+?)\)\s*$`)
+)
+
+// Manifest lists the chapters of a book in reading order.
+type Manifest struct {
+	Title      string    `mapstructure:"title"`
+	Chapters   []Chapter `mapstructure:"-"`
+	TOC        bool      `mapstructure:"toc"`
+	TOCDepth   int       `mapstructure:"toc_depth"`
+	Numbering  bool      `mapstructure:"number_headings"`
+	PageBreaks bool      `mapstructure:"page_breaks"`
+
+	// Dir is the directory chapter paths are relative to.
+	Dir string `mapstructure:"-"`
+}
+
+// Chapter is a single markdown file of a book.
+type Chapter struct {
+	Title string
+	Path  string
+}
+
+// Load reads a book manifest. Files ending in .toml are parsed as
+// mdflux.book.toml, anything else as an mdBook-style SUMMARY.md.
+func Load(path string) (*Manifest, error) {
+	var m *Manifest
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		m, err = loadTOML(path)
+	} else {
+		m, err = loadSummary(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Chapters) == 0 {
+		return nil, fmt.Errorf("book manifest %s lists no chapters", path)
+	}
+	if m.TOCDepth <= 0 {
+		m.TOCDepth = defaultTOCDepth
+	}
+	m.Dir = filepath.Dir(path)
+
+	return m, nil
+}
+
+func loadTOML(path string) (*Manifest, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("toml")
+	v.SetDefault("toc", true)
+	v.SetDefault("toc_depth", defaultTOCDepth)
+	v.SetDefault("number_headings", true)
+	v.SetDefault("page_breaks", true)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read book manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := v.Unmarshal(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse book manifest: %w", err)
+	}
+	for _, p := range v.GetStringSlice("chapters") {
+		m.Chapters = append(m.Chapters, Chapter{Path: p})
+	}
+
+	return &m, nil
+}
+
+// loadSummary parses a SUMMARY.md: an optional title heading followed by
+// markdown links to the chapters, optionally as a (nested) list.
+func loadSummary(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read book summary: %w", err)
+	}
+
+	m := &Manifest{
+		TOC:        true,
+		TOCDepth:   defaultTOCDepth,
+		Numbering:  true,
+		PageBreaks: true,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m.Title == "" && len(m.Chapters) == 0 {
+			if match := summaryHeadingPattern.FindStringSubmatch(line); match != nil {
+				if match[1] != summaryTitle {
+					m.Title = match[1]
+				}
+				continue
+			}
+		}
+		match := summaryLinkPattern.FindStringSubmatch(line)
+		if match == nil || match[2] == "" {
+			continue
+		}
+		m.Chapters = append(m.Chapters, Chapter{Title: match[1], Path: match[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read book summary: %w", err)
+	}
+
+	return m, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/pflag"
@@ -17,6 +18,7 @@ const (
 	configKey   = "config"
 	inputKey    = "input"
 	outputKey   = "output"
+	bookKey     = "book"
 	formatKey   = "format"
 	logLevelKey = "log_level"
 	logFileKey  = "log_file"
@@ -29,31 +31,216 @@ const (
 	defaultD2ThemeID     = int64(0)
 	defaultPDFPageSize   = "A4"
 	defaultPDFScale      = 0.8
-	defaultPDFMargin     = 0.5
 	defaultPDFChromeMode = "auto"
+
+	defaultHTMLAssets = "inline"
+
+	defaultDiagramsOutput = "diagrams"
+	defaultDiagramsFormat = "svg"
+	defaultDiagramsNames  = "number"
+
+	defaultDOCXDiagrams = "svg"
+	defaultTextWidth    = 80
+	defaultTextDiagrams = "ascii"
+
+	defaultSlidesSplit    = "both"
+	defaultSlidesPageSize = "10in x 5.625in"
+
+	defaultManSection = "1"
+
+	defaultSiteSource = "docs"
+	defaultSiteOutput = "site"
+
+	defaultFmtWrap   = "keep"
+	defaultFmtWidth  = 80
+	defaultFmtBullet = "-"
+	defaultFmtFence  = "```"
+
+	defaultImageWidth   = 1200
+	defaultImageScale   = 1.0
+	defaultImageQuality = 90
+
+	defaultUserPasswordEnv  = "MDFLUX_PDF_USER_PASSWORD"
+	defaultOwnerPasswordEnv = "MDFLUX_PDF_OWNER_PASSWORD"
+
+	defaultWatermarkOpacity = 0.15
+	defaultWatermarkAngle   = -45.0
+
+	defaultNumberingStart  = 1
+	defaultNumberingMax    = 6
+	defaultNumberingFormat = "1.1"
 )
 
+// CommandDiagramsExtract writes the diagrams of the input to a directory
+// instead of converting it.
+const CommandDiagramsExtract = "diagrams extract"
+
+// CommandFmt rewrites markdown files in canonical form.
+const CommandFmt = "fmt"
+
+// CommandSite builds a static site from a directory of markdown files.
+const CommandSite = "site"
+
+// commands lists the subcommands. A subcommand is given as the leading
+// arguments, followed by flags.
+var commands = []string{CommandDiagramsExtract, CommandFmt, CommandSite}
+
 type Config struct {
+	// Command is the subcommand, or empty to convert the input.
+	Command string `mapstructure:"-"`
+	// Files are the positional arguments of mdflux fmt.
+	Files      []string         `mapstructure:"-"`
 	Input      string           `mapstructure:"input"`
 	Output     string           `mapstructure:"output"`
+	Book       string           `mapstructure:"book"`
 	Format     string           `mapstructure:"format"`
 	Theme      string           `mapstructure:"theme"`
 	LogLevel   string           `mapstructure:"log_level"`
 	LogFile    string           `mapstructure:"log_file"`
 	HTML       HTMLConfig       `mapstructure:"html"`
 	PDF        PDFConfig        `mapstructure:"pdf"`
+	Image      ImageConfig      `mapstructure:"image"`
+	Diagrams   DiagramsConfig   `mapstructure:"diagrams"`
+	EPUB       EPUBConfig       `mapstructure:"epub"`
+	DOCX       DOCXConfig       `mapstructure:"docx"`
+	Text       TextConfig       `mapstructure:"text"`
+	Fmt        FmtConfig        `mapstructure:"fmt"`
+	Slides     SlidesConfig     `mapstructure:"slides"`
+	Man        ManConfig        `mapstructure:"man"`
+	Site       SiteConfig       `mapstructure:"site"`
 	Extensions ExtensionsConfig `mapstructure:"extensions"`
 }
 
 type PDFConfig struct {
-	PageSize     string       `mapstructure:"page_size"`
-	Landscape    bool         `mapstructure:"landscape"`
-	Scale        float64      `mapstructure:"scale"`
-	MarginTop    float64      `mapstructure:"margin_top"`
-	MarginBottom float64      `mapstructure:"margin_bottom"`
-	MarginLeft   float64      `mapstructure:"margin_left"`
-	MarginRight  float64      `mapstructure:"margin_right"`
-	Chrome       ChromeConfig `mapstructure:"chrome"`
+	PageSize             string            `mapstructure:"page_size"`
+	Landscape            bool              `mapstructure:"landscape"`
+	Scale                float64           `mapstructure:"scale"`
+	Margins              string            `mapstructure:"margins"`
+	MarginTop            string            `mapstructure:"margin_top"`
+	MarginBottom         string            `mapstructure:"margin_bottom"`
+	MarginLeft           string            `mapstructure:"margin_left"`
+	MarginRight          string            `mapstructure:"margin_right"`
+	MarginInner          string            `mapstructure:"margin_inner"`
+	MarginOuter          string            `mapstructure:"margin_outer"`
+	Outline              bool              `mapstructure:"outline"`
+	KeepHeadingsWithNext bool              `mapstructure:"keep_headings_with_next"`
+	AvoidBreakInside     bool              `mapstructure:"avoid_break_inside"`
+	H1NewPage            bool              `mapstructure:"h1_new_page"`
+	PreferCSSPageSize    bool              `mapstructure:"prefer_css_page_size"`
+	Archival             string            `mapstructure:"archival"`
+	Metadata             PDFMetadataConfig `mapstructure:"metadata"`
+	Merge                PDFMergeConfig    `mapstructure:"merge"`
+	Watermark            WatermarkConfig   `mapstructure:"watermark"`
+	Encryption           EncryptionConfig  `mapstructure:"encryption"`
+	Chrome               ChromeConfig      `mapstructure:"chrome"`
+}
+
+// PDFMergeConfig lists the documents merged with the rendered PDF.
+type PDFMergeConfig struct {
+	Cover   string   `mapstructure:"cover"`
+	Prepend []string `mapstructure:"prepend"`
+	Append  []string `mapstructure:"append"`
+}
+
+// PDFMetadataConfig holds document properties used when the front matter
+// does not set them.
+type PDFMetadataConfig struct {
+	Title    string   `mapstructure:"title"`
+	Author   []string `mapstructure:"author"`
+	Subject  string   `mapstructure:"subject"`
+	Keywords []string `mapstructure:"keywords"`
+	Date     string   `mapstructure:"date"`
+}
+
+// WatermarkConfig describes a text or image watermark drawn on every page.
+type WatermarkConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	Statuses []string `mapstructure:"statuses"`
+	Text     string   `mapstructure:"text"`
+	Image    string   `mapstructure:"image"`
+	Opacity  float64  `mapstructure:"opacity"`
+	Angle    float64  `mapstructure:"angle"`
+	Color    string   `mapstructure:"color"`
+}
+
+// EncryptionConfig enables PDF encryption. Passwords are never read from
+// the config file: they come from the named environment variables or from
+// files, so that secrets stay out of mdflux.cfg.toml.
+type EncryptionConfig struct {
+	Enabled           bool   `mapstructure:"enabled"`
+	UserPasswordEnv   string `mapstructure:"user_password_env"`
+	UserPasswordFile  string `mapstructure:"user_password_file"`
+	OwnerPasswordEnv  string `mapstructure:"owner_password_env"`
+	OwnerPasswordFile string `mapstructure:"owner_password_file"`
+	AllowPrint        bool   `mapstructure:"allow_print"`
+	AllowCopy         bool   `mapstructure:"allow_copy"`
+	AllowModify       bool   `mapstructure:"allow_modify"`
+	AllowAnnotate     bool   `mapstructure:"allow_annotate"`
+}
+
+// ImageConfig controls PNG and JPEG screenshots of the HTML output.
+type ImageConfig struct {
+	Width             int     `mapstructure:"width"`
+	DeviceScaleFactor float64 `mapstructure:"device_scale_factor"`
+	TileHeight        int     `mapstructure:"tile_height"`
+	Quality           int     `mapstructure:"quality"`
+}
+
+// EPUBConfig holds EPUB options that front matter can override.
+type EPUBConfig struct {
+	Cover      string `mapstructure:"cover"`
+	Identifier string `mapstructure:"identifier"`
+}
+
+// DOCXConfig controls Word output.
+type DOCXConfig struct {
+	Reference string `mapstructure:"reference"`
+	Diagrams  string `mapstructure:"diagrams"`
+}
+
+// TextConfig controls plain text and ANSI terminal output.
+type TextConfig struct {
+	Width    int    `mapstructure:"width"`
+	Diagrams string `mapstructure:"diagrams"`
+}
+
+// SlidesConfig controls slide deck output.
+type SlidesConfig struct {
+	Split    string `mapstructure:"split"`
+	PageSize string `mapstructure:"page_size"`
+}
+
+// ManConfig holds the man page fields used when the front matter does
+// not set them.
+type ManConfig struct {
+	Section string `mapstructure:"section"`
+	Source  string `mapstructure:"source"`
+	Manual  string `mapstructure:"manual"`
+}
+
+// SiteConfig controls mdflux site.
+type SiteConfig struct {
+	Source string `mapstructure:"source"`
+	Output string `mapstructure:"output"`
+	Title  string `mapstructure:"title"`
+	Search bool   `mapstructure:"search"`
+}
+
+// FmtConfig controls mdflux fmt.
+type FmtConfig struct {
+	Check  bool   `mapstructure:"check"`
+	Write  bool   `mapstructure:"write"`
+	Wrap   string `mapstructure:"wrap"`
+	Width  int    `mapstructure:"width"`
+	Bullet string `mapstructure:"bullet"`
+	Fence  string `mapstructure:"fence"`
+}
+
+// DiagramsConfig controls mdflux diagrams extract.
+type DiagramsConfig struct {
+	Output string `mapstructure:"output"`
+	Format string `mapstructure:"format"`
+	Names  string `mapstructure:"names"`
 }
 
 type ChromeConfig struct {
@@ -62,24 +249,44 @@ type ChromeConfig struct {
 }
 
 type HTMLConfig struct {
-	Unsafe              bool   `mapstructure:"unsafe"`
-	HardWraps           bool   `mapstructure:"hard_wraps"`
-	XHTML               bool   `mapstructure:"xhtml"`
-	EastAsianLineBreaks string `mapstructure:"east_asian_line_breaks"`
+	Unsafe              bool            `mapstructure:"unsafe"`
+	HardWraps           bool            `mapstructure:"hard_wraps"`
+	XHTML               bool            `mapstructure:"xhtml"`
+	EastAsianLineBreaks string          `mapstructure:"east_asian_line_breaks"`
+	Assets              string          `mapstructure:"assets"`
+	AssetsDir           string          `mapstructure:"assets_dir"`
+	Watermark           WatermarkConfig `mapstructure:"watermark"`
 }
 
 type ExtensionsConfig struct {
-	Table          bool     `mapstructure:"table"`
-	Strikethrough  bool     `mapstructure:"strikethrough"`
-	Linkify        bool     `mapstructure:"linkify"`
-	TaskList       bool     `mapstructure:"task_list"`
-	DefinitionList bool     `mapstructure:"definition_list"`
-	Footnote       bool     `mapstructure:"footnote"`
-	Typographer    bool     `mapstructure:"typographer"`
-	CJK            bool     `mapstructure:"cjk"`
-	D2             D2Config `mapstructure:"d2"`
-	KaTeX          bool     `mapstructure:"katex"`
-	Mermaid        bool     `mapstructure:"mermaid"`
+	Table          bool            `mapstructure:"table"`
+	Strikethrough  bool            `mapstructure:"strikethrough"`
+	Linkify        bool            `mapstructure:"linkify"`
+	TaskList       bool            `mapstructure:"task_list"`
+	DefinitionList bool            `mapstructure:"definition_list"`
+	Footnote       bool            `mapstructure:"footnote"`
+	Typographer    bool            `mapstructure:"typographer"`
+	CJK            bool            `mapstructure:"cjk"`
+	D2             D2Config        `mapstructure:"d2"`
+	KaTeX          bool            `mapstructure:"katex"`
+	Mermaid        bool            `mapstructure:"mermaid"`
+	Include        bool            `mapstructure:"include"`
+	Crossref       bool            `mapstructure:"crossref"`
+	Admonitions    bool            `mapstructure:"admonitions"`
+	Attributes     bool            `mapstructure:"attributes"`
+	PageBreaks     bool            `mapstructure:"page_breaks"`
+	Numbering      NumberingConfig `mapstructure:"numbering"`
+
+	// IncludeOutsideRoot permits absolute include paths and paths that
+	// leave the directory of the input.
+	IncludeOutsideRoot bool `mapstructure:"include_outside_root"`
+}
+
+type NumberingConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	StartLevel int    `mapstructure:"start_level"`
+	MaxLevel   int    `mapstructure:"max_level"`
+	Format     string `mapstructure:"format"`
 }
 
 type D2Config struct {
@@ -94,13 +301,41 @@ func LoadAndParse() (*Config, error) {
 	viper.SetDefault(formatKey, defaultFormat)
 	viper.SetDefault("extensions.d2.layout", defaultD2Layout)
 	viper.SetDefault("extensions.d2.theme_id", defaultD2ThemeID)
+	viper.SetDefault("html.assets", defaultHTMLAssets)
 	viper.SetDefault("pdf.page_size", defaultPDFPageSize)
 	viper.SetDefault("pdf.scale", defaultPDFScale)
-	viper.SetDefault("pdf.margin_top", defaultPDFMargin)
-	viper.SetDefault("pdf.margin_bottom", defaultPDFMargin)
-	viper.SetDefault("pdf.margin_left", defaultPDFMargin)
-	viper.SetDefault("pdf.margin_right", defaultPDFMargin)
+	viper.SetDefault("pdf.outline", true)
+	viper.SetDefault("pdf.keep_headings_with_next", true)
+	viper.SetDefault("pdf.avoid_break_inside", true)
 	viper.SetDefault("pdf.chrome.mode", defaultPDFChromeMode)
+	viper.SetDefault("pdf.encryption.user_password_env", defaultUserPasswordEnv)
+	viper.SetDefault("pdf.encryption.owner_password_env", defaultOwnerPasswordEnv)
+	viper.SetDefault("pdf.encryption.allow_print", true)
+	viper.SetDefault("pdf.encryption.allow_copy", true)
+	viper.SetDefault("diagrams.output", defaultDiagramsOutput)
+	viper.SetDefault("diagrams.format", defaultDiagramsFormat)
+	viper.SetDefault("diagrams.names", defaultDiagramsNames)
+	viper.SetDefault("docx.diagrams", defaultDOCXDiagrams)
+	viper.SetDefault("text.width", defaultTextWidth)
+	viper.SetDefault("text.diagrams", defaultTextDiagrams)
+	viper.SetDefault("slides.split", defaultSlidesSplit)
+	viper.SetDefault("slides.page_size", defaultSlidesPageSize)
+	viper.SetDefault("man.section", defaultManSection)
+	viper.SetDefault("site.source", defaultSiteSource)
+	viper.SetDefault("site.output", defaultSiteOutput)
+	viper.SetDefault("site.search", true)
+	viper.SetDefault("fmt.wrap", defaultFmtWrap)
+	viper.SetDefault("fmt.width", defaultFmtWidth)
+	viper.SetDefault("fmt.bullet", defaultFmtBullet)
+	viper.SetDefault("fmt.fence", defaultFmtFence)
+	viper.SetDefault("image.width", defaultImageWidth)
+	viper.SetDefault("image.device_scale_factor", defaultImageScale)
+	viper.SetDefault("image.quality", defaultImageQuality)
+	for _, section := range []string{"html", "pdf"} {
+		viper.SetDefault(section+".watermark.statuses", []string{"draft"})
+		viper.SetDefault(section+".watermark.opacity", defaultWatermarkOpacity)
+		viper.SetDefault(section+".watermark.angle", defaultWatermarkAngle)
+	}
 
 	viper.SetDefault("extensions.table", true)
 	viper.SetDefault("extensions.strikethrough", true)
@@ -110,6 +345,19 @@ func LoadAndParse() (*Config, error) {
 	viper.SetDefault("extensions.katex", true)
 	viper.SetDefault("extensions.mermaid", true)
 	viper.SetDefault("extensions.d2.enabled", true)
+	viper.SetDefault("extensions.include", true)
+	viper.SetDefault("extensions.crossref", true)
+	viper.SetDefault("extensions.admonitions", true)
+	viper.SetDefault("extensions.attributes", true)
+	viper.SetDefault("extensions.page_breaks", true)
+	viper.SetDefault("extensions.numbering.start_level", defaultNumberingStart)
+	viper.SetDefault("extensions.numbering.max_level", defaultNumberingMax)
+	viper.SetDefault("extensions.numbering.format", defaultNumberingFormat)
+
+	command, args, err := splitCommand(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
 
 	flagSet := pflag.NewFlagSet("mdflux", pflag.ContinueOnError)
 	flagSet.Usage = func() {}
@@ -117,13 +365,40 @@ func LoadAndParse() (*Config, error) {
 	help := flagSet.BoolP(helpKey, "?", false, "Display help information")
 	flagSet.StringP(configKey, "c", "", "Path to config file")
 	flagSet.StringP(inputKey, "i", "", "Input markdown file (use - for stdin)")
-	flagSet.StringP(outputKey, "o", "", "Output file (use - for stdout)")
-	flagSet.StringP(formatKey, "f", defaultFormat, "Output format (html, pdf)")
+	// flagKeys maps flags whose config key differs from the flag name.
+	flagKeys := map[string]string{}
+	switch command {
+	case CommandDiagramsExtract:
+		flagSet.StringP(outputKey, "o", defaultDiagramsOutput, "Output directory")
+		flagSet.StringP(formatKey, "f", defaultDiagramsFormat, "Image format (svg, png)")
+		flagSet.String("names", defaultDiagramsNames, "File names (number, id)")
+		flagKeys[outputKey] = "diagrams.output"
+		flagKeys[formatKey] = "diagrams.format"
+		flagKeys["names"] = "diagrams.names"
+	case CommandFmt:
+		flagSet.StringP(outputKey, "o", "", "Output file when formatting a single input (use - for stdout)")
+		flagSet.Bool("check", false, "Report files that are not formatted instead of writing them")
+		flagSet.BoolP("write", "w", false, "Write the result back to the files")
+		flagSet.String("wrap", defaultFmtWrap, "Paragraph wrapping (keep, none, width)")
+		flagSet.Int("width", defaultFmtWidth, "Column to wrap paragraphs at with --wrap width")
+		for _, name := range []string{"check", "write", "wrap", "width"} {
+			flagKeys[name] = "fmt." + name
+		}
+	case CommandSite:
+		flagSet.StringP(outputKey, "o", defaultSiteOutput, "Output directory")
+		flagSet.String("title", "", "Site title (default: title of the index page)")
+		flagKeys[outputKey] = "site.output"
+		flagKeys["title"] = "site.title"
+	default:
+		flagSet.StringP(outputKey, "o", "", "Output file (use - for stdout)")
+		flagSet.StringP(bookKey, "b", "", "Book manifest (mdflux.book.toml or SUMMARY.md) to build instead of a single input")
+		flagSet.StringP(formatKey, "f", defaultFormat, "Output format (html, pdf, png, jpeg, epub, docx, text, ansi, json, slides, slides-pdf, man)")
+	}
 	flagSet.StringP(logLevelKey, "l", defaultLogLevel, "Log level (debug, info, warn, error)")
 	flagSet.String(logFileKey, "", "Log file path")
 	flagSet.StringP(themeKey, "t", defaultTheme, "Color theme (auto, light, dark)")
 
-	if err := flagSet.Parse(os.Args[1:]); err != nil {
+	if err := flagSet.Parse(args); err != nil {
 		if errors.Is(err, pflag.ErrHelp) {
 			fmt.Println(flagSet.FlagUsages())
 			os.Exit(0)
@@ -136,14 +411,35 @@ func LoadAndParse() (*Config, error) {
 		fmt.Println()
 		fmt.Println("Usage:")
 		fmt.Println("  mdflux [flags]")
+		for _, c := range commands {
+			if c == CommandFmt {
+				fmt.Printf("  mdflux %s [flags] [files]\n", c)
+				continue
+			}
+			if c == CommandSite {
+				fmt.Printf("  mdflux %s [flags] [dir]\n", c)
+				continue
+			}
+			fmt.Printf("  mdflux %s [flags]\n", c)
+		}
 		fmt.Println()
 		fmt.Println("Flags:")
 		fmt.Println(flagSet.FlagUsages())
 		os.Exit(0)
 	}
 
-	if err := viper.BindPFlags(flagSet); err != nil {
-		return nil, fmt.Errorf("viper.BindPFlags() failed: %w", err)
+	var bindErr error
+	flagSet.VisitAll(func(f *pflag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok {
+			key = f.Name
+		}
+		if err := viper.BindPFlag(key, f); err != nil && bindErr == nil {
+			bindErr = err
+		}
+	})
+	if bindErr != nil {
+		return nil, fmt.Errorf("viper.BindPFlag() failed: %w", bindErr)
 	}
 
 	configFile, _ := flagSet.GetString(configKey)
@@ -159,7 +455,7 @@ func LoadAndParse() (*Config, error) {
 
 	viper.SetEnvPrefix(envPrefix)
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	for _, key := range []string{inputKey, outputKey, formatKey, logLevelKey, logFileKey, themeKey} {
+	for _, key := range []string{inputKey, outputKey, bookKey, formatKey, logLevelKey, logFileKey, themeKey} {
 		_ = viper.BindEnv(key)
 	}
 
@@ -174,6 +470,34 @@ func LoadAndParse() (*Config, error) {
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("viper.Unmarshal() failed: %w", err)
 	}
+	cfg.Command = command
+	if command == CommandFmt {
+		cfg.Files = flagSet.Args()
+	}
+	if command == CommandSite {
+		switch flagSet.NArg() {
+		case 0:
+		case 1:
+			cfg.Site.Source = flagSet.Arg(0)
+		default:
+			return nil, fmt.Errorf("mdflux site takes one source directory, got %d arguments", flagSet.NArg())
+		}
+	}
 
 	return &cfg, nil
 }
+
+// splitCommand separates a leading subcommand from the flags in args.
+func splitCommand(args []string) (string, []string, error) {
+	for _, command := range commands {
+		words := strings.Fields(command)
+		if len(args) == 0 || args[0] != words[0] {
+			continue
+		}
+		if len(args) < len(words) || !slices.Equal(args[:len(words)], words) {
+			return "", nil, fmt.Errorf("unknown command %q, expected %q", args[0], command)
+		}
+		return command, args[len(words):], nil
+	}
+	return "", args, nil
+}
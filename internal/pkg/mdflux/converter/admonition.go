@@ -0,0 +1,192 @@
+package converter
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	alertPattern       = regexp.MustCompile(`^\[!([A-Za-z]+)\]\s*$`)
+	containerPattern   = regexp.MustCompile(`^(:{3,})\s*([A-Za-z][A-Za-z0-9_-]*)\s*(.*?)\s*$`)
+	containerEndSuffix = regexp.MustCompile(`^:{3,}\s*$`)
+
+	admonitionAliases = map[string]string{
+		"info":      "note",
+		"hint":      "tip",
+		"attention": "warning",
+		"danger":    "caution",
+		"error":     "caution",
+	}
+
+	admonitionTitles = map[string]string{
+		"note":      "Note",
+		"tip":       "Tip",
+		"important": "Important",
+		"warning":   "Warning",
+		"caution":   "Caution",
+	}
+)
+
+// KindAdmonition is the node kind of Admonition.
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// Admonition is a callout block such as a note or warning. It is produced
+// from GitHub alerts (> [!NOTE]) and from :::type fenced containers.
+type Admonition struct {
+	ast.BaseBlock
+	AdmonitionType string
+	Title          string
+}
+
+func (n *Admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+func (n *Admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Type": n.AdmonitionType, "Title": n.Title}, nil)
+}
+
+func newAdmonition(kind, title string) *Admonition {
+	kind = strings.ToLower(kind)
+	if alias, ok := admonitionAliases[kind]; ok {
+		kind = alias
+	}
+	if title == "" {
+		title = admonitionTitles[kind]
+	}
+	if title == "" {
+		title = strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	return &Admonition{AdmonitionType: kind, Title: title}
+}
+
+// admonitionParser parses :::type [title] ... ::: containers. Containers
+// nest when the inner fence is shorter than the outer one.
+type admonitionParser struct{}
+
+type admonitionFence struct {
+	length int
+}
+
+var admonitionFenceKey = parser.NewContextKey()
+
+func (p *admonitionParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (p *admonitionParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	m := containerPattern.FindSubmatch(bytes.TrimRight(line[pos:], "\r\n"))
+	if m == nil {
+		return nil, parser.NoChildren
+	}
+
+	node := newAdmonition(string(m[2]), string(m[3]))
+	fences, _ := pc.Get(admonitionFenceKey).([]admonitionFence)
+	pc.Set(admonitionFenceKey, append(fences, admonitionFence{length: len(m[1])}))
+
+	reader.Advance(segment.Len() - 1)
+	return node, parser.HasChildren
+}
+
+func (p *admonitionParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	fences, _ := pc.Get(admonitionFenceKey).([]admonitionFence)
+	depth := 0
+	for n := node.Parent(); n != nil; n = n.Parent() {
+		if _, ok := n.(*Admonition); ok {
+			depth++
+		}
+	}
+
+	trimmed := bytes.TrimSpace(line)
+	if depth < len(fences) && containerEndSuffix.Match(trimmed) && len(trimmed) >= fences[depth].length {
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *admonitionParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	fences, _ := pc.Get(admonitionFenceKey).([]admonitionFence)
+	if len(fences) > 0 {
+		pc.Set(admonitionFenceKey, fences[:len(fences)-1])
+	}
+}
+
+func (p *admonitionParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *admonitionParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// alertTransformer turns GitHub alert blockquotes (> [!NOTE]) into
+// admonitions.
+type alertTransformer struct{}
+
+func (t *alertTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var quotes []*ast.Blockquote
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if q, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, q)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, q := range quotes {
+		para, ok := q.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			continue
+		}
+		first := para.Lines().At(0)
+		m := alertPattern.FindSubmatch(util.TrimRightSpace(first.Value(source)))
+		if m == nil {
+			continue
+		}
+		kind := strings.ToLower(string(m[1]))
+		if _, ok := admonitionTitles[kind]; !ok {
+			continue
+		}
+
+		for c := para.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			t, ok := c.(*ast.Text)
+			if !ok || t.Segment.Start >= first.Stop {
+				break
+			}
+			para.RemoveChild(para, c)
+			c = next
+		}
+		rest := text.NewSegments()
+		for i := 1; i < para.Lines().Len(); i++ {
+			rest.Append(para.Lines().At(i))
+		}
+		para.SetLines(rest)
+		if !para.HasChildren() {
+			q.RemoveChild(q, para)
+		}
+
+		admonition := newAdmonition(kind, "")
+		parent := q.Parent()
+		parent.ReplaceChild(parent, q, admonition)
+		for c := q.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			admonition.AppendChild(admonition, c)
+			c = next
+		}
+	}
+}
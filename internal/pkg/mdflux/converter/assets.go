@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Assets writes diagrams to external files instead of inlining their SVG.
+// Files are named after a hash of their content, so identical diagrams
+// share a file and changed diagrams get a new URL that browsers have not
+// cached.
+type Assets struct {
+	// Dir is the directory asset files are written to.
+	Dir string
+	// URL is Dir relative to the page being rendered, with forward
+	// slashes. Empty when they are the same directory.
+	URL string
+}
+
+// RelativeTo sets URL for a page written to pagePath.
+func (a *Assets) RelativeTo(pagePath string) error {
+	dir, err := filepath.Abs(a.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	pageDir, err := filepath.Abs(filepath.Dir(pagePath))
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	rel, err := filepath.Rel(pageDir, dir)
+	if err != nil {
+		return fmt.Errorf("failed to locate assets from %s: %w", pagePath, err)
+	}
+	a.URL = filepath.ToSlash(rel)
+	if a.URL == "." {
+		a.URL = ""
+	}
+	return nil
+}
+
+// Write stores data in a file named after its hash and returns the URL of
+// the file relative to the page.
+func (a *Assets) Write(ext string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	name := "diagram-" + hex.EncodeToString(sum[:8]) + ext
+
+	file := filepath.Join(a.Dir, name)
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(a.Dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create assets directory: %w", err)
+		}
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			return "", fmt.Errorf("os.WriteFile() failed: %w", err)
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	if a.URL == "" {
+		return name, nil
+	}
+	return path.Join(a.URL, name), nil
+}
+
+// externalDiagramRenderer wraps the renderer of a diagram kind and moves
+// the SVG it produces to an asset file referenced by an <img>.
+type externalDiagramRenderer struct {
+	kind   ast.NodeKind
+	render renderer.NodeRendererFunc
+	assets *Assets
+	xhtml  bool
+}
+
+func (r *externalDiagramRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(r.kind, r.renderDiagram)
+}
+
+func (r *externalDiagramRenderer) renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	status, err := r.render(bw, source, node, entering)
+	if flushErr := bw.Flush(); err == nil {
+		err = flushErr
+	}
+	out := buf.Bytes()
+
+	start := bytes.Index(out, []byte("<svg"))
+	if decl := bytes.Index(out, []byte("<?xml")); decl >= 0 && decl < start {
+		start = decl
+	}
+	end := bytes.LastIndex(out, []byte("</svg>"))
+	if start >= 0 && end > start {
+		end += len("</svg>")
+		url, writeErr := r.assets.Write(".svg", out[start:end])
+		if writeErr != nil {
+			return ast.WalkStop, writeErr
+		}
+
+		alt := ""
+		if fig, ok := node.Parent().(*Figure); ok {
+			alt = fig.Caption
+		}
+		_, _ = w.Write(out[:start])
+		_, _ = w.WriteString(`<img src="`)
+		_, _ = w.Write(util.EscapeHTML(util.URLEscape([]byte(url), true)))
+		_, _ = w.WriteString(`" alt="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(alt)))
+		if r.xhtml {
+			_, _ = w.WriteString(`" />`)
+		} else {
+			_, _ = w.WriteString(`">`)
+		}
+		out = out[end:]
+	}
+	_, _ = w.Write(out)
+	return status, err
+}
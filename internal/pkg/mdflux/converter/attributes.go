@@ -0,0 +1,425 @@
+package converter
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	attributeNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+	lengthPattern        = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(%|px|em|rem|cm|mm|in|pt|vw|vh)$`)
+
+	// urlAttributes can load or navigate to another resource and are never
+	// accepted from attribute blocks, whatever html.WithUnsafe says.
+	urlAttributes = map[string]bool{
+		"href":       true,
+		"src":        true,
+		"srcset":     true,
+		"action":     true,
+		"formaction": true,
+		"background": true,
+		"poster":     true,
+		"data":       true,
+		"xlink:href": true,
+		"ping":       true,
+	}
+
+	unsafeStyles = []string{"url(", "expression(", "javascript:", "@import", "\\", "<"}
+)
+
+// parseAttributeBlock parses a {#id .class key=value} block at the start of
+// b and returns the attributes and the number of bytes consumed. Unlike
+// parser.ParseAttributes it accepts bare values such as width=50%.
+func parseAttributeBlock(b []byte) (parser.Attributes, int, bool) {
+	if len(b) == 0 || b[0] != '{' {
+		return nil, 0, false
+	}
+	var attrs parser.Attributes
+	var classes [][]byte
+	i := 1
+	for {
+		for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == ',') {
+			i++
+		}
+		if i >= len(b) || b[i] == '\n' || b[i] == '\r' {
+			return nil, 0, false
+		}
+		if b[i] == '}' {
+			i++
+			break
+		}
+
+		switch b[i] {
+		case '#', '.':
+			start := i + 1
+			j := start
+			for j < len(b) && isAttributeNameChar(b[j]) {
+				j++
+			}
+			if j == start {
+				return nil, 0, false
+			}
+			if b[i] == '#' {
+				attrs = append(attrs, parser.Attribute{Name: []byte("id"), Value: b[start:j]})
+			} else {
+				classes = append(classes, b[start:j])
+			}
+			i = j
+			continue
+		}
+
+		start := i
+		for i < len(b) && isAttributeNameChar(b[i]) {
+			i++
+		}
+		name := b[start:i]
+		if len(name) == 0 || i >= len(b) || b[i] != '=' {
+			return nil, 0, false
+		}
+		i++
+
+		var value []byte
+		if i < len(b) && (b[i] == '"' || b[i] == '\'') {
+			quote := b[i]
+			end := bytes.IndexByte(b[i+1:], quote)
+			if end < 0 {
+				return nil, 0, false
+			}
+			value = b[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(b) && b[i] != ' ' && b[i] != '\t' && b[i] != '}' && b[i] != '\n' && b[i] != '\r' {
+				i++
+			}
+			value = b[start:i]
+		}
+
+		if bytes.Equal(name, []byte("class")) {
+			classes = append(classes, bytes.Fields(value)...)
+		} else {
+			attrs = append(attrs, parser.Attribute{Name: name, Value: value})
+		}
+	}
+	if len(classes) > 0 {
+		attrs = append(attrs, parser.Attribute{Name: []byte("class"), Value: bytes.Join(classes, []byte(" "))})
+	}
+	return attrs, i, true
+}
+
+func isAttributeNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == ':' || c == '.'
+}
+
+// sanitizeAttributes drops event handlers, URL-valued attributes and style
+// declarations that could load resources or run script. Attribute blocks
+// are author input, so this applies even when raw HTML is allowed.
+func sanitizeAttributes(attrs parser.Attributes) parser.Attributes {
+	var out parser.Attributes
+	for _, attr := range attrs {
+		name := strings.ToLower(string(attr.Name))
+		value := AttributeString(attr.Value)
+		if !attributeNamePattern.MatchString(name) || strings.HasPrefix(name, "on") || urlAttributes[name] {
+			continue
+		}
+		if name == "style" && !safeStyle(value) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(value), "javascript:") {
+			continue
+		}
+		out = append(out, parser.Attribute{Name: []byte(name), Value: []byte(value)})
+	}
+	return out
+}
+
+func safeStyle(style string) bool {
+	lower := strings.ToLower(style)
+	for _, s := range unsafeStyles {
+		if strings.Contains(lower, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// setAttributes applies attrs to n. Classes are appended to any existing
+// class, other attributes replace earlier values. On images, width and
+// height with a unit (50%, 8cm) become inline styles since the HTML
+// attributes only take pixels.
+func setAttributes(n ast.Node, attrs parser.Attributes) {
+	_, isImage := n.(*ast.Image)
+	var styles []string
+	for _, attr := range sanitizeAttributes(attrs) {
+		name := string(attr.Name)
+		value := attr.Value.([]byte)
+		switch {
+		case name == "class":
+			if old, ok := n.AttributeString("class"); ok {
+				value = append(append([]byte(AttributeString(old)), ' '), value...)
+			}
+		case isImage && (name == "width" || name == "height") && lengthPattern.Match(value):
+			styles = append(styles, name+":"+string(value))
+			continue
+		}
+		n.SetAttribute(attr.Name, value)
+	}
+	if len(styles) > 0 {
+		style := strings.Join(styles, ";")
+		if old, ok := n.AttributeString("style"); ok {
+			style = strings.TrimRight(AttributeString(old), "; ") + ";" + style
+		}
+		n.SetAttribute([]byte("style"), []byte(style))
+	}
+}
+
+// removeAttribute removes the named attribute from n.
+func removeAttribute(n ast.Node, name string) {
+	attrs := n.Attributes()
+	n.RemoveAttributes()
+	for _, attr := range attrs {
+		if string(attr.Name) != name {
+			n.SetAttribute(attr.Name, attr.Value)
+		}
+	}
+}
+
+// headingAttributeTransformer sanitizes heading attributes, which goldmark
+// parses itself.
+type headingAttributeTransformer struct{}
+
+func (t *headingAttributeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		node, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		var attrs parser.Attributes
+		for _, attr := range node.Attributes() {
+			attrs = append(attrs, parser.Attribute{Name: attr.Name, Value: attr.Value})
+		}
+		if attrs != nil {
+			node.RemoveAttributes()
+			setAttributes(node, attrs)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// attributeTransformer applies {#id .class key=value} blocks:
+//
+//   - after an image, link or display math: ![alt](src){width=50%}
+//   - at the end of a paragraph: Some text. {.lead}
+//   - in a fenced code info string: ```go {#main .numberLines}
+//   - alone in a paragraph, to the preceding block (e.g. a table), or to the
+//     following block if there is none
+//
+// Heading attributes are sanitized by headingAttributeTransformer.
+type attributeTransformer struct{}
+
+func (t *attributeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var nodes []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			switch n.(type) {
+			case *ast.FencedCodeBlock, *ast.Paragraph:
+				nodes = append(nodes, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range nodes {
+		switch node := n.(type) {
+		case *ast.FencedCodeBlock:
+			t.transformFencedCodeBlock(node, source)
+		case *ast.Paragraph:
+			if t.transformStandalone(node, source) {
+				continue
+			}
+			consumed := t.transformInlines(node, source)
+			t.transformTrailing(node, source, consumed)
+		}
+	}
+}
+
+func (t *attributeTransformer) transformFencedCodeBlock(node *ast.FencedCodeBlock, source []byte) {
+	if node.Info == nil {
+		return
+	}
+	info := node.Info.Segment.Value(source)
+	i := bytes.IndexByte(info, '{')
+	if i < 0 {
+		return
+	}
+	if attrs, _, ok := parseAttributeBlock(info[i:]); ok {
+		setAttributes(node, attrs)
+	}
+}
+
+// transformStandalone handles a paragraph holding nothing but an attribute
+// block.
+func (t *attributeTransformer) transformStandalone(node *ast.Paragraph, source []byte) bool {
+	if node.Lines().Len() != 1 {
+		return false
+	}
+	seg := node.Lines().At(0)
+	line := util.TrimRightSpace(util.TrimLeftSpace(seg.Value(source)))
+	attrs, n, ok := parseAttributeBlock(line)
+	if !ok || n != len(line) {
+		return false
+	}
+
+	target := node.PreviousSibling()
+	if target == nil {
+		target = node.NextSibling()
+	}
+	if target == nil {
+		return false
+	}
+	setAttributes(target, attrs)
+	node.Parent().RemoveChild(node.Parent(), node)
+	return true
+}
+
+// transformInlines applies attribute blocks that directly follow an image,
+// a link or display math and returns the source offsets where they began.
+func (t *attributeTransformer) transformInlines(node *ast.Paragraph, source []byte) []int {
+	var consumed []int
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c.(type) {
+		case *ast.Image, *ast.Link, *katex.Block:
+		default:
+			continue
+		}
+		next, ok := c.NextSibling().(*ast.Text)
+		if !ok {
+			continue
+		}
+		start := next.Segment.Start
+		if _, isMath := c.(*katex.Block); isMath {
+			for start < next.Segment.Stop && (source[start] == ' ' || source[start] == '\t') {
+				start++
+			}
+		}
+		attrs, n, ok := parseAttributeBlock(source[start:lineEnd(node, source, start)])
+		if !ok {
+			continue
+		}
+		setAttributes(c, attrs)
+		consumeInlines(node, next, start+n)
+		consumed = append(consumed, start)
+	}
+	return consumed
+}
+
+// transformTrailing applies an attribute block ending the last line of a
+// paragraph to the paragraph itself.
+func (t *attributeTransformer) transformTrailing(node *ast.Paragraph, source []byte, consumed []int) {
+	lines := node.Lines()
+	if lines.Len() == 0 {
+		return
+	}
+	last := lines.At(lines.Len() - 1)
+	line := util.TrimRightSpace(last.Value(source))
+	if len(line) == 0 || line[len(line)-1] != '}' {
+		return
+	}
+
+	for i := 0; i < len(line); i++ {
+		if line[i] != '{' || (i > 0 && line[i-1] != ' ' && line[i-1] != '\t') {
+			continue
+		}
+		attrs, n, ok := parseAttributeBlock(line[i:])
+		if !ok || i+n != len(line) {
+			continue
+		}
+		start := last.Start + i
+		for _, c := range consumed {
+			if c == start {
+				return
+			}
+		}
+		if !trimInlines(node, source, start) {
+			return
+		}
+		setAttributes(node, attrs)
+		return
+	}
+}
+
+// lineEnd returns the end of the paragraph line containing offset.
+func lineEnd(node ast.Node, source []byte, offset int) int {
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		if offset >= seg.Start && offset < seg.Stop {
+			return seg.Stop
+		}
+	}
+	return offset
+}
+
+// consumeInlines removes the inline nodes from first up to the source offset
+// end. Nodes without a position, such as typographer quotes, are assumed to
+// lie inside the removed range.
+func consumeInlines(parent ast.Node, first ast.Node, end int) {
+	for c := first; c != nil; {
+		next := c.NextSibling()
+		switch n := c.(type) {
+		case *ast.Text:
+			if n.Segment.Start >= end {
+				return
+			}
+			if n.Segment.Stop > end {
+				n.Segment = n.Segment.WithStart(end)
+				return
+			}
+		case *ast.String:
+		default:
+			return
+		}
+		parent.RemoveChild(parent, c)
+		c = next
+	}
+}
+
+// trimInlines removes the trailing inline nodes of parent from the source
+// offset start on, along with the whitespace before it. It reports false,
+// leaving parent untouched, if a node other than text stands in the way.
+func trimInlines(parent ast.Node, source []byte, start int) bool {
+	var remove []ast.Node
+	var cut *ast.Text
+	for c := parent.LastChild(); c != nil && cut == nil; c = c.PreviousSibling() {
+		switch n := c.(type) {
+		case *ast.Text:
+			if n.Segment.Start < start {
+				cut = n
+				continue
+			}
+		case *ast.String:
+		default:
+			return false
+		}
+		remove = append(remove, c)
+	}
+
+	for _, c := range remove {
+		parent.RemoveChild(parent, c)
+	}
+	if cut != nil {
+		seg := cut.Segment.WithStop(start)
+		cut.Segment = seg.TrimRightSpace(source)
+	}
+	return true
+}
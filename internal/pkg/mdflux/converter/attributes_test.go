@@ -0,0 +1,53 @@
+package converter_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+// Attribute blocks are author input and are sanitized whether or not raw
+// HTML is allowed.
+func TestAttributeSanitizing(t *testing.T) {
+	attrs := []struct {
+		attr string
+		keep bool
+		want string
+	}{
+		{attr: `#intro`, keep: true, want: `id="intro"`},
+		{attr: `.lead`, keep: true, want: `class="lead`},
+		{attr: `data-x="1"`, keep: true, want: `data-x="1"`},
+		{attr: `style="color:red"`, keep: true, want: `style="color:red"`},
+		{attr: `onclick="alert(1)"`, want: `alert(1)`},
+		{attr: `OnMouseOver="alert(1)"`, want: `alert(1)`},
+		{attr: `href="javascript:alert(1)"`, want: `javascript:`},
+		{attr: `title="javascript:alert(1)"`, want: `javascript:`},
+		{attr: `src="https://example.com/x.js"`, want: `x.js`},
+		{attr: `style="background:url(https://example.com/x.png)"`, want: `x.png`},
+		{attr: `style="width:expression(alert(1))"`, want: `expression(`},
+		{attr: `#intro onclick="alert(1)" href="javascript:alert(1)" data-x="1"`, keep: true, want: `id="intro" data-x="1"`},
+	}
+	blocks := map[string]string{
+		"heading":     "# Title {%s}\n",
+		"fenced code": "```go {%s}\nx\n```\n",
+	}
+
+	for _, unsafe := range []bool{false, true} {
+		conv := newConverter(t, converter.Options{
+			Unsafe:     unsafe,
+			Extensions: converter.ExtensionOptions{Attributes: true},
+		})
+		for block, format := range blocks {
+			for _, a := range attrs {
+				t.Run(fmt.Sprintf("%s/unsafe=%v/%s", block, unsafe, a.attr), func(t *testing.T) {
+					html := renderBody(t, conv, fmt.Sprintf(format, a.attr))
+					if got := strings.Contains(html, a.want); got != a.keep {
+						t.Errorf("contains %q = %v, want %v:\n%s", a.want, got, a.keep, html)
+					}
+				})
+			}
+		}
+	}
+}
@@ -1,18 +1,26 @@
 package converter
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strings"
 
+	"mdflux/internal/pkg/mdflux/frontmatter"
+	"mdflux/internal/pkg/mdflux/include"
 	"mdflux/internal/pkg/mdflux/mermaid"
 
 	d2 "github.com/FurqanSoftware/goldmark-d2"
 	"github.com/FurqanSoftware/goldmark-katex"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
@@ -25,8 +33,16 @@ type Options struct {
 	XHTML               bool
 	Theme               string
 	EastAsianLineBreaks string
-	Extensions          ExtensionOptions
-	MermaidRenderer     *mermaid.Renderer
+	// PrintStyles is appended to the stylesheet, e.g. pagination rules.
+	PrintStyles     string
+	Watermark       Watermark
+	Extensions      ExtensionOptions
+	MermaidRenderer *mermaid.Renderer
+	// Assets moves diagram SVGs to external files when set.
+	Assets *Assets
+	// Page names the page templates, <Page>-header and <Page>-footer.
+	// Defaults to html5, or xhtml with XHTML.
+	Page string
 }
 
 type ExtensionOptions struct {
@@ -41,6 +57,16 @@ type ExtensionOptions struct {
 	D2             D2Options
 	KaTeX          bool
 	Mermaid        bool
+	Include        bool
+	Crossref       bool
+	Admonitions    bool
+	Attributes     bool
+	PageBreaks     bool
+	Numbering      NumberingOptions
+
+	// IncludeOutsideRoot permits includes of files outside the root
+	// directory, see WithIncludeRoot.
+	IncludeOutsideRoot bool
 }
 
 type D2Options struct {
@@ -49,12 +75,65 @@ type D2Options struct {
 	ThemeID int64
 }
 
+// Document is a parsed markdown source. Source is the input after front
+// matter has been blanked out and include directives have been resolved,
+// and is what node segments refer to.
+type Document struct {
+	Source []byte
+	Path   string
+	Root   ast.Node
+	Meta   *frontmatter.Meta
+
+	input      []byte
+	resolved   *include.Result
+	lineStarts []int
+}
+
+// Position returns the position of the byte at offset in Source in the
+// file it comes from: the input, whose front matter Source has blanked
+// out, or an included file.
+func (d *Document) Position(offset int) include.Position {
+	if d.resolved != nil {
+		return d.resolved.Position(offset)
+	}
+	if d.lineStarts == nil {
+		d.lineStarts = lineStarts(d.Source)
+	}
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+	return include.Position{
+		File:   d.Path,
+		Line:   line + 1,
+		Column: offset - d.lineStarts[line] + 1,
+		Offset: offset + len(d.input) - len(d.Source),
+	}
+}
+
+// lineStarts returns the offsets at which the lines of source start.
+func lineStarts(source []byte) []int {
+	starts := []int{0}
+	for i, c := range source {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
 type Converter struct {
-	markdown   goldmark.Markdown
-	templates  *Templates
-	xhtml      bool
-	theme      string
-	extensions ExtensionOptions
+	markdown    goldmark.Markdown
+	templates   *Templates
+	xhtml       bool
+	page        string
+	theme       string
+	printStyles string
+	watermark   Watermark
+	extensions  ExtensionOptions
+	hardWraps   bool
+
+	// formatParser parses the source as written for Format.
+	formatParser parser.Parser
 }
 
 func New(opts Options, templates *Templates) *Converter {
@@ -82,12 +161,54 @@ func New(opts Options, templates *Templates) *Converter {
 
 	gmOpts = append(gmOpts, goldmark.WithParserOptions(
 		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(
+			util.Prioritized(&numberingTransformer{opts: opts.Extensions.Numbering}, 50),
+		),
 	))
 
+	// Trailing {#id .class} on headings carries numbering classes and
+	// cross-reference labels, and is plain text otherwise.
+	if opts.Extensions.Attributes || opts.Extensions.Numbering.Enabled || opts.Extensions.Crossref {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithHeadingAttribute(),
+			parser.WithASTTransformers(
+				util.Prioritized(&headingAttributeTransformer{}, 40),
+			),
+		))
+	}
+
+	if opts.Extensions.Attributes {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithASTTransformers(
+				util.Prioritized(&attributeTransformer{}, 40),
+			),
+		))
+	}
+
+	if opts.Extensions.PageBreaks {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithASTTransformers(
+				util.Prioritized(&pageBreakTransformer{}, 45),
+			),
+		))
+	}
+
+	if opts.Extensions.Crossref {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithInlineParsers(
+				util.Prioritized(&crossrefParser{}, 500),
+			),
+			parser.WithASTTransformers(
+				util.Prioritized(&crossrefTransformer{}, 90),
+			),
+		))
+	}
+
 	gmOpts = append(gmOpts, goldmark.WithRenderer(
 		renderer.NewRenderer(
 			renderer.WithNodeRenderers(
 				util.Prioritized(htmlRenderer, 1000),
+				util.Prioritized(&nodeRenderer{xhtml: opts.XHTML}, 500),
 			),
 		),
 	))
@@ -117,6 +238,18 @@ func New(opts Options, templates *Templates) *Converter {
 		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.CJK))
 	}
 
+	if opts.Extensions.Admonitions {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithBlockParsers(
+				util.Prioritized(&admonitionParser{}, 150),
+			),
+			parser.WithASTTransformers(
+				util.Prioritized(&alertTransformer{}, 60),
+			),
+		))
+	}
+
+	var diagramRenderers []*externalDiagramRenderer
 	if opts.Extensions.D2.Enabled {
 		var layoutFunc d2graph.LayoutGraph
 		switch opts.Extensions.D2.Layout {
@@ -130,6 +263,10 @@ func New(opts Options, templates *Templates) *Converter {
 			Layout:  layoutFunc,
 			ThemeID: &themeID,
 		}))
+		if opts.Assets != nil {
+			d2Renderer := &d2.HTMLRenderer{Layout: layoutFunc, ThemeID: &themeID}
+			diagramRenderers = append(diagramRenderers, &externalDiagramRenderer{kind: d2.KindBlock, render: d2Renderer.Render})
+		}
 	}
 
 	if opts.Extensions.KaTeX {
@@ -140,48 +277,269 @@ func New(opts Options, templates *Templates) *Converter {
 		gmOpts = append(gmOpts, goldmark.WithExtensions(&mermaid.Extender{
 			Renderer: opts.MermaidRenderer,
 		}))
+		if opts.Assets != nil {
+			mermaidRenderer := &mermaid.HTMLRenderer{Renderer: opts.MermaidRenderer}
+			diagramRenderers = append(diagramRenderers, &externalDiagramRenderer{kind: mermaid.KindMermaidBlock, render: mermaidRenderer.Render})
+		}
 	}
 
 	md := goldmark.New(gmOpts...)
 
+	// The wrappers take precedence over the renderers of the extensions,
+	// which register at priority 0 and up.
+	for _, r := range diagramRenderers {
+		r.assets = opts.Assets
+		r.xhtml = opts.XHTML
+		md.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(r, -1)))
+	}
+
+	page := opts.Page
+	if page == "" {
+		page = "html5"
+		if opts.XHTML {
+			page = "xhtml"
+		}
+	}
+
 	return &Converter{
-		markdown:   md,
-		templates:  templates,
-		xhtml:      opts.XHTML,
-		theme:      opts.Theme,
-		extensions: opts.Extensions,
+		markdown:    md,
+		templates:   templates,
+		xhtml:       opts.XHTML,
+		page:        page,
+		theme:       opts.Theme,
+		printStyles: opts.PrintStyles,
+		watermark:   opts.Watermark,
+		extensions:  opts.Extensions,
+		hardWraps:   opts.HardWraps,
+
+		formatParser: newFormatParser(opts.Extensions),
 	}
 }
 
+// Convert renders source as a complete document. Include directives are
+// resolved relative to the working directory.
 func (c *Converter) Convert(source []byte, w io.Writer) error {
-	headerTemplate := "html5-header"
-	footerTemplate := "html5-footer"
-	if c.xhtml {
-		headerTemplate = "xhtml-header"
-		footerTemplate = "xhtml-footer"
+	return c.convert(source, "", w)
+}
+
+// ConvertFile renders the markdown file at path as a complete document.
+// Include directives are resolved relative to the file's directory.
+func (c *Converter) ConvertFile(path string, w io.Writer) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
 	}
+	return c.convert(source, path, w)
+}
 
-	data := HeaderData{
-		Title:  "Document",
-		Styles: c.templates.Styles(),
-		Theme:  c.theme,
+func (c *Converter) convert(source []byte, path string, w io.Writer) error {
+	doc, err := c.Parse(source, path, nil)
+	if err != nil {
+		return err
 	}
+	return c.Render(w, doc)
+}
 
-	if err := c.templates.Template().ExecuteTemplate(w, headerTemplate, data); err != nil {
-		return fmt.Errorf("failed to execute header template: %w", err)
+// Render writes doc as a complete page. The title and the author,
+// description and keywords meta tags come from the front matter.
+func (c *Converter) Render(w io.Writer, doc *Document) error {
+	if err := c.WriteHeader(w, DocumentHeader(doc)); err != nil {
+		return err
+	}
+
+	if err := c.RenderBody(w, doc); err != nil {
+		return err
 	}
 
-	if err := c.markdown.Convert(source, w); err != nil {
+	return c.WriteFooter(w)
+}
+
+// DocumentHeader returns the page title and meta tags for doc, which may be
+// nil, from its front matter.
+func DocumentHeader(doc *Document) HeaderData {
+	data := HeaderData{Title: "Document"}
+	if doc == nil || doc.Meta == nil {
+		return data
+	}
+	meta := doc.Meta
+	if meta.Title != "" {
+		data.Title = meta.Title
+	}
+	data.Lang = meta.Lang
+	data.Author = strings.Join(meta.Author, ", ")
+	data.Description = meta.Description
+	if data.Description == "" {
+		data.Description = meta.Subject
+	}
+	data.Keywords = strings.Join(meta.Keywords, ", ")
+	data.Status = meta.Status
+	return data
+}
+
+// Parse resolves include directives and parses source into a Document
+// without rendering it. The path is only used to resolve includes and in
+// diagnostics and may be empty. A nil pc parses with a fresh context.
+func (c *Converter) Parse(input []byte, path string, pc parser.Context) (*Document, error) {
+	meta, source, err := frontmatter.Split(input)
+	if err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, err
+	}
+
+	if pc == nil {
+		pc = parser.NewContext()
+	}
+
+	info := &sourceInfo{path: path}
+	if c.extensions.Include {
+		root, _ := pc.Get(includeRootKey).(string)
+		resolved, err := include.Resolve(source, path, include.Options{
+			Root:         root,
+			AllowOutside: c.extensions.IncludeOutsideRoot,
+			Offset:       len(input) - len(source),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("include resolution failed: %w", err)
+		}
+		source = resolved.Source
+		info.resolved = resolved
+	}
+
+	pc.Set(sourceInfoKey, info)
+
+	root := c.markdown.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+
+	if registry, ok := pc.Get(crossrefsKey).(*Crossrefs); ok && registry.local {
+		if err := registry.Check(); err != nil {
+			return nil, fmt.Errorf("cross-reference check failed: %w", err)
+		}
+	}
+
+	return &Document{
+		Source:   source,
+		Path:     path,
+		Root:     root,
+		Meta:     meta,
+		input:    input,
+		resolved: info.resolved,
+	}, nil
+}
+
+var sourceInfoKey = parser.NewContextKey()
+
+var includeRootKey = parser.NewContextKey()
+
+// WithIncludeRoot stores the directory that included files must lie in in
+// pc, for documents that belong to a larger tree such as a book. It
+// defaults to the directory of each document.
+func WithIncludeRoot(pc parser.Context, dir string) {
+	pc.Set(includeRootKey, dir)
+}
+
+type sourceInfo struct {
+	path     string
+	resolved *include.Result
+}
+
+// sourceOrigin describes the position of offset in source as file:line for
+// diagnostics, mapping included content back to the file it came from.
+func sourceOrigin(pc parser.Context, source []byte, offset int) string {
+	info, _ := pc.Get(sourceInfoKey).(*sourceInfo)
+	if info != nil && info.resolved != nil {
+		return info.resolved.Origin(offset).String()
+	}
+
+	name := "<stdin>"
+	if info != nil && info.path != "" {
+		name = info.path
+	}
+	if offset < 0 || offset > len(source) {
+		return name
+	}
+	return fmt.Sprintf("%s:%d", name, bytes.Count(source[:offset], []byte("\n"))+1)
+}
+
+// nodeOrigin is sourceOrigin for the first source line of n.
+func nodeOrigin(pc parser.Context, source []byte, n ast.Node) string {
+	offset := -1
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok && fcb.Info != nil {
+		offset = fcb.Info.Segment.Start
+	} else if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		offset = n.Lines().At(0).Start
+	} else {
+		_ = ast.Walk(n, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+			if t, ok := child.(*ast.Text); ok && entering {
+				offset = t.Segment.Start
+				return ast.WalkStop, nil
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+	return sourceOrigin(pc, source, offset)
+}
+
+// RenderBody writes the HTML for a parsed document without the surrounding
+// page template.
+func (c *Converter) RenderBody(w io.Writer, doc *Document) error {
+	if err := c.markdown.Renderer().Render(w, doc.Source, doc.Root); err != nil {
+		return fmt.Errorf("goldmark conversion failed: %w", err)
+	}
+	return nil
+}
+
+// RenderNode writes the HTML for n, a node of doc, and its descendants.
+func (c *Converter) RenderNode(w io.Writer, doc *Document, n ast.Node) error {
+	if err := c.markdown.Renderer().Render(w, doc.Source, n); err != nil {
 		return fmt.Errorf("goldmark conversion failed: %w", err)
 	}
+	return nil
+}
+
+// WriteHeader writes the page template up to and including the opening body
+// tag and the watermark. Styles, Theme and Watermark are filled in from the
+// converter when left empty.
+func (c *Converter) WriteHeader(w io.Writer, data HeaderData) error {
+	headerTemplate := c.page + "-header"
+
+	if data.Styles == "" {
+		data.Styles = c.templates.Styles() + c.printStyles
+	}
+	if data.Theme == "" {
+		data.Theme = c.theme
+	}
+	if data.Watermark == "" {
+		data.Watermark = c.watermark.render(data.Status, c.xhtml)
+	}
+
+	if err := c.templates.Template().ExecuteTemplate(w, headerTemplate, data); err != nil {
+		return fmt.Errorf("failed to execute header template: %w", err)
+	}
+	return nil
+}
+
+// WriteFooter closes the page opened by WriteHeader.
+func (c *Converter) WriteFooter(w io.Writer) error {
+	footerTemplate := c.page + "-footer"
 
 	if err := c.templates.Template().ExecuteTemplate(w, footerTemplate, nil); err != nil {
 		return fmt.Errorf("failed to execute footer template: %w", err)
 	}
-
 	return nil
 }
 
+// NumberingOptions returns the heading numbering the converter was
+// configured with.
+func (c *Converter) NumberingOptions() NumberingOptions {
+	return c.extensions.Numbering
+}
+
+// Templates returns the templates the converter renders pages with.
+func (c *Converter) Templates() *Templates {
+	return c.templates
+}
+
 func (c *Converter) ConvertReader(r io.Reader, w io.Writer) error {
 	source, err := io.ReadAll(r)
 	if err != nil {
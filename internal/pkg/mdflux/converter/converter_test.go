@@ -0,0 +1,32 @@
+package converter_test
+
+import (
+	"bytes"
+	"testing"
+
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/web"
+)
+
+func newConverter(t *testing.T, opts converter.Options) *converter.Converter {
+	t.Helper()
+	templates, err := converter.ParseTemplates(web.TemplateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return converter.New(opts, templates)
+}
+
+// renderBody converts source to HTML without the page template.
+func renderBody(t *testing.T, conv *converter.Converter, source string) string {
+	t.Helper()
+	doc, err := conv.Parse([]byte(source), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := conv.RenderBody(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
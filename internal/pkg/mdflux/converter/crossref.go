@@ -0,0 +1,537 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	crossrefFigure   = "fig"
+	crossrefTable    = "tbl"
+	crossrefEquation = "eq"
+	crossrefSection  = "sec"
+)
+
+var (
+	crossrefPattern     = regexp.MustCompile(`^@((?:fig|tbl|eq|sec):[A-Za-z0-9_][A-Za-z0-9_.:-]*)`)
+	tableCaptionPattern = regexp.MustCompile(`^(?:Table)?:\s+(.*?)\s*(\{[^}]*\})?\s*$`)
+	tableCaptionPrefix  = regexp.MustCompile(`^(?:Table)?:\s+`)
+
+	crossrefNames = map[string]string{
+		crossrefFigure:   "Figure",
+		crossrefTable:    "Table",
+		crossrefEquation: "Equation",
+		crossrefSection:  "Section",
+	}
+)
+
+var crossrefsKey = parser.NewContextKey()
+
+// WithCrossrefs stores c in pc so that documents parsed with pc share one
+// set of labels and figure, table and equation counters. The caller is then
+// responsible for calling Check once all documents have been parsed.
+func WithCrossrefs(pc parser.Context, c *Crossrefs) {
+	pc.Set(crossrefsKey, c)
+}
+
+type crossrefTarget struct {
+	prefix string
+	number string
+	title  string
+}
+
+// Crossrefs collects labelled figures, tables, equations and sections and
+// the references pointing at them.
+type Crossrefs struct {
+	counters map[string]int
+	targets  map[string]*crossrefTarget
+	refs     []*CrossrefLink
+	errs     []error
+	local    bool
+}
+
+// NewCrossrefs returns an empty cross-reference registry.
+func NewCrossrefs() *Crossrefs {
+	return &Crossrefs{
+		counters: map[string]int{},
+		targets:  map[string]*crossrefTarget{},
+	}
+}
+
+// Check reports duplicate labels and references to labels that were never
+// defined.
+func (c *Crossrefs) Check() error {
+	errs := append([]error{}, c.errs...)
+	for _, ref := range c.refs {
+		if _, ok := c.targets[ref.Label]; !ok {
+			errs = append(errs, fmt.Errorf("%s: unresolved reference @%s", ref.Origin, ref.Label))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Crossrefs) define(label, title, origin string) *crossrefTarget {
+	prefix, _, _ := strings.Cut(label, ":")
+	if _, ok := c.targets[label]; ok {
+		c.errs = append(c.errs, fmt.Errorf("%s: duplicate label #%s", origin, label))
+	}
+	c.counters[prefix]++
+	t := &crossrefTarget{
+		prefix: prefix,
+		number: strconv.Itoa(c.counters[prefix]),
+		title:  title,
+	}
+	c.targets[label] = t
+	return t
+}
+
+func (c *Crossrefs) lookup(label string) (*crossrefTarget, bool) {
+	t, ok := c.targets[label]
+	return t, ok
+}
+
+// KindCrossrefLink is the node kind of CrossrefLink.
+var KindCrossrefLink = ast.NewNodeKind("CrossrefLink")
+
+// CrossrefLink is an @fig:label style reference. It is rendered as a link
+// whose text is the number of its target, e.g. "Figure 3".
+type CrossrefLink struct {
+	ast.BaseInline
+	Label  string
+	Origin string
+
+	registry *Crossrefs
+}
+
+func (n *CrossrefLink) Kind() ast.NodeKind {
+	return KindCrossrefLink
+}
+
+func (n *CrossrefLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Label": n.Label}, nil)
+}
+
+// DisplayText returns the text the reference is rendered with.
+func (n *CrossrefLink) DisplayText() string {
+	t, ok := n.registry.lookup(n.Label)
+	if !ok {
+		return "@" + n.Label
+	}
+	if t.prefix == crossrefSection && t.number == "" {
+		return t.title
+	}
+	return crossrefNames[t.prefix] + " " + t.number
+}
+
+// KindFigure is the node kind of Figure.
+var KindFigure = ast.NewNodeKind("Figure")
+
+// Figure wraps a labelled image, diagram or table together with its
+// numbered caption.
+type Figure struct {
+	ast.BaseBlock
+	Label   string
+	Prefix  string
+	Number  string
+	Caption string
+}
+
+func (n *Figure) Kind() ast.NodeKind {
+	return KindFigure
+}
+
+func (n *Figure) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Label": n.Label, "Number": n.Number}, nil)
+}
+
+// CaptionText returns the caption prefixed with the figure number, e.g.
+// "Figure 3: Architecture".
+func (n *Figure) CaptionText() string {
+	if n.Caption == "" {
+		return n.name()
+	}
+	return n.name() + ": " + n.Caption
+}
+
+// name returns the figure number with its kind, e.g. "Table 2".
+func (n *Figure) name() string {
+	return crossrefNames[n.Prefix] + " " + n.Number
+}
+
+// KindFigureCaption is the node kind of FigureCaption.
+var KindFigureCaption = ast.NewNodeKind("FigureCaption")
+
+// FigureCaption holds the inline content of a figure caption. It is the
+// first child of a table figure and the last child of other figures.
+type FigureCaption struct {
+	ast.BaseBlock
+}
+
+func (n *FigureCaption) Kind() ast.NodeKind {
+	return KindFigureCaption
+}
+
+func (n *FigureCaption) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindEquation is the node kind of Equation.
+var KindEquation = ast.NewNodeKind("Equation")
+
+// Equation wraps labelled display math and its number.
+type Equation struct {
+	ast.BaseInline
+	Label  string
+	Number string
+}
+
+func (n *Equation) Kind() ast.NodeKind {
+	return KindEquation
+}
+
+func (n *Equation) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Label": n.Label, "Number": n.Number}, nil)
+}
+
+// crossrefParser parses @fig:label, @tbl:label, @eq:label and @sec:label
+// references.
+type crossrefParser struct{}
+
+func (p *crossrefParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p *crossrefParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if prev := block.PrecendingCharacter(); prev != ' ' && prev != '\n' && prev != '(' && prev != '[' && prev != '\t' && prev != -1 && prev != 0 {
+		return nil
+	}
+
+	line, segment := block.PeekLine()
+	m := crossrefPattern.FindSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	label := bytes.TrimRight(m[1], ".:-")
+
+	block.Advance(len(label) + 1)
+
+	return &CrossrefLink{
+		Label:  string(label),
+		Origin: sourceOrigin(pc, block.Source(), segment.Start),
+	}
+}
+
+// crossrefTransformer numbers labelled figures, tables, equations and
+// sections and links the references in the document to them.
+type crossrefTransformer struct{}
+
+func (t *crossrefTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	registry, ok := pc.Get(crossrefsKey).(*Crossrefs)
+	if !ok {
+		registry = NewCrossrefs()
+		registry.local = true
+		pc.Set(crossrefsKey, registry)
+	}
+
+	var nodes []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			nodes = append(nodes, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range nodes {
+		switch node := n.(type) {
+		case *ast.Heading:
+			label, ok := crossrefLabel(node, crossrefSection)
+			if !ok {
+				continue
+			}
+			target := registry.define(label, NodeText(node, source), nodeOrigin(pc, source, node))
+			target.number = ""
+			if num, ok := node.FirstChild().(*HeadingNumber); ok {
+				target.number = num.Number
+			}
+		case *ast.FencedCodeBlock:
+			t.transformFencedCodeBlock(node, registry, pc, source)
+		case *ast.Paragraph:
+			t.transformImage(node, registry, pc, source)
+		case *east.Table:
+			t.transformTable(node, registry, pc, source)
+		case *katex.Block:
+			t.transformEquation(node, registry, pc, source)
+		case *CrossrefLink:
+			node.registry = registry
+			registry.refs = append(registry.refs, node)
+		}
+	}
+}
+
+// transformFencedCodeBlock turns ```mermaid {#fig:flow caption="..."} into a
+// figure. The diagram extensions later replace the code block inside it.
+func (t *crossrefTransformer) transformFencedCodeBlock(node *ast.FencedCodeBlock, registry *Crossrefs, pc parser.Context, source []byte) {
+	label, caption, ok := fencedCodeLabel(node, source)
+	if !ok {
+		return
+	}
+	target := registry.define(label, caption, nodeOrigin(pc, source, node))
+	fig := &Figure{Label: label, Prefix: crossrefFigure, Number: target.number, Caption: caption}
+	wrapInFigure(node, fig)
+	captionNode := &FigureCaption{}
+	if caption != "" {
+		captionNode.AppendChild(captionNode, ast.NewString([]byte(caption)))
+	}
+	fig.AppendChild(fig, captionNode)
+}
+
+// fencedCodeLabel returns the figure label and caption of a fenced code
+// block, either from the attributes set by the attribute transformer or
+// from its info string.
+func fencedCodeLabel(node *ast.FencedCodeBlock, source []byte) (string, string, bool) {
+	if label, ok := crossrefLabel(node, crossrefFigure); ok {
+		caption := ""
+		if v, ok := node.AttributeString("caption"); ok {
+			caption = AttributeString(v)
+		}
+		removeAttribute(node, "id")
+		removeAttribute(node, "caption")
+		return label, caption, true
+	}
+
+	if node.Info == nil {
+		return "", "", false
+	}
+	info := node.Info.Segment.Value(source)
+	i := bytes.IndexByte(info, '{')
+	if i < 0 {
+		return "", "", false
+	}
+	attrs, ok := parser.ParseAttributes(text.NewReader(info[i:]))
+	if !ok {
+		return "", "", false
+	}
+	label, ok := attributeLabel(attrs, crossrefFigure)
+	if !ok {
+		return "", "", false
+	}
+	caption := ""
+	if v, ok := attrs.Find([]byte("caption")); ok {
+		caption = AttributeString(v)
+	}
+	return label, caption, true
+}
+
+// transformImage turns a paragraph holding only ![alt](src){#fig:label}
+// into a figure captioned with the alt text.
+func (t *crossrefTransformer) transformImage(node *ast.Paragraph, registry *Crossrefs, pc parser.Context, source []byte) {
+	img, ok := node.FirstChild().(*ast.Image)
+	if !ok {
+		return
+	}
+	label, ok := crossrefLabel(img, crossrefFigure)
+	if ok && img.NextSibling() == nil {
+		removeAttribute(img, "id")
+	} else {
+		attrText, ok := img.NextSibling().(*ast.Text)
+		if !ok || attrText.NextSibling() != nil {
+			return
+		}
+		attrs, ok := parser.ParseAttributes(text.NewReader(attrText.Segment.Value(source)))
+		if !ok {
+			return
+		}
+		label, ok = attributeLabel(attrs, crossrefFigure)
+		if !ok {
+			return
+		}
+		node.RemoveChild(node, attrText)
+	}
+
+	// The alt text moves to the caption and stays on the image as text.
+	captionNode := &FigureCaption{}
+	alt := NodeText(img, source)
+	for c := img.FirstChild(); c != nil; c = img.FirstChild() {
+		captionNode.AppendChild(captionNode, c)
+	}
+	altString := ast.NewString([]byte(alt))
+	altString.SetCode(true)
+	img.AppendChild(img, altString)
+
+	caption := PlainText(captionNode, source)
+	target := registry.define(label, caption, nodeOrigin(pc, source, node))
+	fig := &Figure{Label: label, Prefix: crossrefFigure, Number: target.number, Caption: caption}
+	wrapInFigure(node, fig)
+	fig.AppendChild(fig, captionNode)
+}
+
+// transformTable captions a table followed by a ": Caption {#tbl:label}"
+// paragraph.
+func (t *crossrefTransformer) transformTable(node *east.Table, registry *Crossrefs, pc parser.Context, source []byte) {
+	para, ok := node.NextSibling().(*ast.Paragraph)
+	if !ok {
+		return
+	}
+	var raw bytes.Buffer
+	lines := para.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		raw.Write(bytes.TrimRight(seg.Value(source), "\r\n"))
+		raw.WriteByte(' ')
+	}
+	m := tableCaptionPattern.FindSubmatch(bytes.TrimSpace(raw.Bytes()))
+	if m == nil || m[2] == nil {
+		return
+	}
+	attrs, ok := parser.ParseAttributes(text.NewReader(m[2]))
+	if !ok {
+		return
+	}
+	label, ok := attributeLabel(attrs, crossrefTable)
+	if !ok {
+		return
+	}
+
+	para.Parent().RemoveChild(para.Parent(), para)
+	captionNode := tableCaption(para, source)
+	if captionNode == nil {
+		captionNode = &FigureCaption{}
+		if len(m[1]) > 0 {
+			captionNode.AppendChild(captionNode, ast.NewString(m[1]))
+		}
+	}
+	caption := PlainText(captionNode, source)
+	target := registry.define(label, caption, nodeOrigin(pc, source, node))
+	fig := &Figure{Label: label, Prefix: crossrefTable, Number: target.number, Caption: caption}
+	wrapInFigure(node, fig)
+	fig.InsertBefore(fig, node, captionNode)
+}
+
+// tableCaption moves the inline content of a table caption paragraph into
+// a FigureCaption, without the "Table:" prefix and the attributes, unless
+// the attribute transformer already removed them. It returns nil when the
+// prefix or the attributes are not plain text.
+func tableCaption(para *ast.Paragraph, source []byte) *FigureCaption {
+	first, ok := para.FirstChild().(*ast.Text)
+	if !ok {
+		return nil
+	}
+	prefix := tableCaptionPrefix.Find(first.Segment.Value(source))
+	if prefix == nil {
+		return nil
+	}
+	if last, ok := para.LastChild().(*ast.Text); ok {
+		if i := bytes.LastIndexByte(last.Segment.Value(source), '{'); i >= 0 {
+			if !trimInlines(para, source, last.Segment.Start+i) {
+				return nil
+			}
+		}
+	}
+	if first.Segment.Len() > len(prefix) {
+		first.Segment = first.Segment.WithStart(first.Segment.Start + len(prefix))
+	} else {
+		para.RemoveChild(para, first)
+	}
+
+	captionNode := &FigureCaption{}
+	for c := para.FirstChild(); c != nil; c = para.FirstChild() {
+		captionNode.AppendChild(captionNode, c)
+	}
+	return captionNode
+}
+
+// transformEquation numbers $$...$$ {#eq:label} display math.
+func (t *crossrefTransformer) transformEquation(node *katex.Block, registry *Crossrefs, pc parser.Context, source []byte) {
+	if label, ok := crossrefLabel(node, crossrefEquation); ok {
+		removeAttribute(node, "id")
+		parent := node.Parent()
+		target := registry.define(label, "", nodeOrigin(pc, source, parent))
+		eq := &Equation{Label: label, Number: target.number}
+		parent.ReplaceChild(parent, node, eq)
+		eq.AppendChild(eq, node)
+		return
+	}
+
+	attrText, ok := node.NextSibling().(*ast.Text)
+	if !ok {
+		return
+	}
+	value := attrText.Segment.Value(source)
+	reader := text.NewReader(value)
+	attrs, ok := parser.ParseAttributes(reader)
+	if !ok {
+		return
+	}
+	label, ok := attributeLabel(attrs, crossrefEquation)
+	if !ok {
+		return
+	}
+
+	_, pos := reader.Position()
+	if rest := attrText.Segment.WithStart(attrText.Segment.Start + pos.Start); rest.Len() > 0 {
+		attrText.Segment = rest
+	} else {
+		attrText.Parent().RemoveChild(attrText.Parent(), attrText)
+	}
+
+	parent := node.Parent()
+	target := registry.define(label, "", sourceOrigin(pc, source, attrText.Segment.Start))
+	eq := &Equation{Label: label, Number: target.number}
+	parent.ReplaceChild(parent, node, eq)
+	eq.AppendChild(eq, node)
+}
+
+func wrapInFigure(node ast.Node, fig *Figure) {
+	parent := node.Parent()
+	parent.ReplaceChild(parent, node, fig)
+	fig.AppendChild(fig, node)
+}
+
+func crossrefLabel(n ast.Node, prefix string) (string, bool) {
+	v, ok := n.AttributeString("id")
+	if !ok {
+		return "", false
+	}
+	label := AttributeString(v)
+	if !strings.HasPrefix(label, prefix+":") {
+		return "", false
+	}
+	return label, true
+}
+
+func attributeLabel(attrs parser.Attributes, prefix string) (string, bool) {
+	v, ok := attrs.Find([]byte("id"))
+	if !ok {
+		return "", false
+	}
+	label := AttributeString(v)
+	if !strings.HasPrefix(label, prefix+":") {
+		return "", false
+	}
+	return label, true
+}
+
+// AttributeString returns an attribute value, which goldmark stores as
+// []byte or string, as a string.
+func AttributeString(v interface{}) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	default:
+		return fmt.Sprint(s)
+	}
+}
@@ -0,0 +1,152 @@
+package converter_test
+
+import (
+	"strings"
+	"testing"
+
+	"mdflux/internal/pkg/mdflux/converter"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+func newCrossrefConverter(t *testing.T) *converter.Converter {
+	return newConverter(t, converter.Options{
+		Extensions: converter.ExtensionOptions{
+			Table:      true,
+			KaTeX:      true,
+			Crossref:   true,
+			Attributes: true,
+			Numbering:  converter.NumberingOptions{Enabled: true},
+		},
+	})
+}
+
+func TestCrossrefNumbering(t *testing.T) {
+	source := `# Intro {#sec:intro}
+
+See @fig:arch, @tbl:data, @eq:euler and @sec:intro.
+
+![Architecture *overview*](arch.png){#fig:arch}
+
+| a | b |
+|---|---|
+| 1 | 2 |
+
+: Data {#tbl:data}
+
+$$e^{i\pi} + 1 = 0$$ {#eq:euler}
+
+![Second](second.png){#fig:second}
+
+Also @fig:second.
+`
+	html := renderBody(t, newCrossrefConverter(t), source)
+	for _, want := range []string{
+		`<h1 id="sec:intro"><span class="heading-number">1</span> Intro</h1>`,
+		`<a class="xref" href="#fig:arch">Figure 1</a>`,
+		`<a class="xref" href="#tbl:data">Table 1</a>`,
+		`<a class="xref" href="#eq:euler">Equation 1</a>`,
+		`<a class="xref" href="#sec:intro">Section 1</a>`,
+		`<a class="xref" href="#fig:second">Figure 2</a>`,
+		`<figcaption>Figure 1: Architecture <em>overview</em></figcaption>`,
+		`<figcaption>Table 1: Data</figcaption>`,
+		`<span class="equation-number">(1)</span>`,
+		`<figcaption>Figure 2: Second</figcaption>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %q in:\n%s", want, html)
+		}
+	}
+}
+
+func TestCrossrefErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "unresolved reference",
+			source: "# Title\n\nSee @fig:missing.\n",
+			want:   "doc.md:3: unresolved reference @fig:missing",
+		},
+		{
+			name:   "duplicate label",
+			source: "![A](a.png){#fig:a}\n\n![B](b.png){#fig:a}\n",
+			want:   "doc.md:3: duplicate label #fig:a",
+		},
+		{
+			name:   "duplicate label of another kind",
+			source: "# A {#sec:a}\n\n# B {#sec:a}\n",
+			want:   "doc.md:3: duplicate label #sec:a",
+		},
+	}
+	conv := newCrossrefConverter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := conv.Parse([]byte(tt.source), "doc.md", nil)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Chapters of a book share one registry, so numbers continue across
+// chapters and references may point into other chapters.
+func TestCrossrefBookChapters(t *testing.T) {
+	conv := newCrossrefConverter(t)
+	crossrefs := converter.NewCrossrefs()
+	numberer := converter.NewHeadingNumberer(conv.NumberingOptions())
+
+	chapters := []struct {
+		path   string
+		source string
+	}{
+		{"one.md", "# One {#sec:one}\n\n![A](a.png){#fig:a}\n\nSee @fig:b in @sec:two.\n"},
+		{"two.md", "# Two {#sec:two}\n\n![B](b.png){#fig:b}\n\nSee @fig:a in @sec:one.\n"},
+	}
+	var docs []*converter.Document
+	for _, ch := range chapters {
+		pc := parser.NewContext()
+		converter.WithHeadingNumberer(pc, numberer)
+		converter.WithCrossrefs(pc, crossrefs)
+		doc, err := conv.Parse([]byte(ch.source), ch.path, pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := crossrefs.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	wants := [][]string{
+		{`<figcaption>Figure 1: A</figcaption>`, `<a class="xref" href="#fig:b">Figure 2</a>`, `<a class="xref" href="#sec:two">Section 2</a>`},
+		{`<figcaption>Figure 2: B</figcaption>`, `<a class="xref" href="#fig:a">Figure 1</a>`, `<a class="xref" href="#sec:one">Section 1</a>`},
+	}
+	for i, doc := range docs {
+		var out strings.Builder
+		if err := conv.RenderBody(&out, doc); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range wants[i] {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: missing %q in:\n%s", chapters[i].path, want, out.String())
+			}
+		}
+	}
+
+	pc := parser.NewContext()
+	converter.WithCrossrefs(pc, crossrefs)
+	if _, err := conv.Parse([]byte("\nSee @fig:c.\n"), "three.md", pc); err != nil {
+		t.Fatal(err)
+	}
+	err := crossrefs.Check()
+	if err == nil || !strings.Contains(err.Error(), "three.md:2: unresolved reference @fig:c") {
+		t.Errorf("error = %v, want the unresolved reference in three.md", err)
+	}
+}
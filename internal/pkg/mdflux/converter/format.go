@@ -0,0 +1,798 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mdflux/internal/pkg/mdflux/frontmatter"
+	"mdflux/internal/pkg/mdflux/include"
+
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/mattn/go-runewidth"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Paragraph wrapping modes of FormatOptions.
+const (
+	WrapKeep  = "keep"
+	WrapNone  = "none"
+	WrapWidth = "width"
+)
+
+// FormatOptions configures the Markdown formatter.
+type FormatOptions struct {
+	// Wrap keeps the line breaks of paragraphs as written, unwraps each
+	// paragraph into a single line, or wraps paragraphs at Width columns.
+	Wrap  string
+	Width int
+	// Bullet is the marker of bullet lists: "-", "*" or "+". A list that
+	// directly follows another uses a different marker, as the two would
+	// otherwise merge.
+	Bullet string
+	// Fence is the code fence, "```" or "~~~". Indented code blocks are
+	// written as fenced ones.
+	Fence string
+}
+
+var (
+	headingAttributePattern = regexp.MustCompile(`\{[^{}]*\}\s*$`)
+	attributeLinePattern    = regexp.MustCompile(`^\{[^{}]*\}$`)
+
+	// unsafeLineStarts are words that start a block when they begin a
+	// line, so wrapping never breaks a line before them.
+	unsafeLineStarts = regexp.MustCompile("^(#{1,6}|[-+*:]|[0-9]{1,9}[.)]|=+|-+|\\*+|_+)$|^(>|\\||<|\\{|\\[\\^?[^\\]]*\\]:|\\[!|```|~~~|:::|\\$\\$)")
+	whitespaceRun    = regexp.MustCompile(`\s+`)
+)
+
+// Format rewrites source as canonical Markdown: ATX headings, consistent
+// list markers and code fences, padded tables, paragraphs wrapped as
+// configured, and link reference definitions sorted at the end. Front
+// matter and inline content are kept as written. The formatted source is
+// checked to render to the same HTML as source, ignoring differences in
+// whitespace; the path is used to resolve includes for that check.
+func (c *Converter) Format(source []byte, path string, opts FormatOptions) ([]byte, error) {
+	if opts.Wrap != WrapKeep && opts.Wrap != WrapNone && opts.Wrap != WrapWidth {
+		return nil, fmt.Errorf("unsupported wrap mode %q, expected keep, none or width", opts.Wrap)
+	}
+	if opts.Bullet != "-" && opts.Bullet != "*" && opts.Bullet != "+" {
+		return nil, fmt.Errorf("unsupported bullet %q, expected -, * or +", opts.Bullet)
+	}
+	if opts.Fence != "```" && opts.Fence != "~~~" {
+		return nil, fmt.Errorf("unsupported fence %q, expected ``` or ~~~", opts.Fence)
+	}
+	if c.hardWraps {
+		// Every line break of a paragraph renders as <br>.
+		opts.Wrap = WrapKeep
+	}
+
+	_, body, err := frontmatter.Split(source)
+	if err != nil {
+		return nil, err
+	}
+	rest := bytes.TrimLeft(body, "\n")
+	front := source[:len(source)-len(rest)]
+
+	pc := parser.NewContext()
+	root := c.formatParser.Parse(text.NewReader(rest), parser.WithContext(pc))
+	f := &formatter{opts: opts, source: rest}
+
+	var out bytes.Buffer
+	if len(bytes.TrimSpace(front)) > 0 {
+		out.Write(bytes.TrimRight(front, "\n"))
+		out.WriteString("\n\n")
+	}
+	lines := f.blocks(root, f.width(), false)
+	if refs := f.references(pc.References()); len(refs) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, refs...)
+	}
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+	formatted := out.Bytes()
+	if len(lines) == 0 {
+		formatted = bytes.TrimRight(formatted, "\n")
+		if len(formatted) > 0 {
+			formatted = append(formatted, '\n')
+		}
+	}
+
+	if err := c.checkFormat(source, formatted, path); err != nil {
+		return nil, err
+	}
+	return formatted, nil
+}
+
+// checkFormat reports an error if formatted renders differently from
+// source.
+func (c *Converter) checkFormat(source, formatted []byte, path string) error {
+	render := func(src []byte) (string, error) {
+		doc, err := c.Parse(src, path, nil)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := c.RenderBody(&buf, doc); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(whitespaceRun.ReplaceAllString(buf.String(), " ")), nil
+	}
+
+	// The KaTeX renderer wraps display math in a div only the first time
+	// it renders an equation, so source is rendered once to fill its cache.
+	if _, err := render(source); err != nil {
+		return err
+	}
+	before, err := render(source)
+	if err != nil {
+		return err
+	}
+	after, err := render(formatted)
+	if err != nil {
+		return fmt.Errorf("formatted source does not parse: %w", err)
+	}
+	if before != after {
+		i := 0
+		for i < len(before) && i < len(after) && before[i] == after[i] {
+			i++
+		}
+		return fmt.Errorf("formatting would change the rendered HTML near %q", after[max(i-20, 0):min(i+40, len(after))])
+	}
+	return nil
+}
+
+// newFormatParser returns a parser for the syntax of the enabled
+// extensions that leaves the tree as written: no transformer rewrites
+// nodes, includes are not resolved and diagrams stay code blocks.
+func newFormatParser(opts ExtensionOptions) parser.Parser {
+	gmOpts := []goldmark.Option{goldmark.WithParserOptions(parser.WithHeadingAttribute())}
+	if opts.Table {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.Table))
+	}
+	if opts.Strikethrough {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.Strikethrough))
+	}
+	if opts.Linkify {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.Linkify))
+	}
+	if opts.TaskList {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.TaskList))
+	}
+	if opts.DefinitionList {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.DefinitionList))
+	}
+	if opts.Footnote {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(extension.Footnote))
+	}
+	if opts.KaTeX {
+		gmOpts = append(gmOpts, goldmark.WithExtensions(&katex.Extender{}))
+	}
+	if opts.Crossref {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithInlineParsers(util.Prioritized(&crossrefParser{}, 500)),
+		))
+	}
+	if opts.Admonitions {
+		gmOpts = append(gmOpts, goldmark.WithParserOptions(
+			parser.WithBlockParsers(util.Prioritized(&admonitionParser{}, 150)),
+		))
+	}
+	return goldmark.New(gmOpts...).Parser()
+}
+
+type formatter struct {
+	opts   FormatOptions
+	source []byte
+}
+
+// width is the column paragraphs wrap at, or 0 when they do not wrap.
+func (f *formatter) width() int {
+	if f.opts.Wrap == WrapWidth {
+		return max(f.opts.Width, 1)
+	}
+	return 0
+}
+
+// blocks formats the children of parent, separated by blank lines unless
+// tight.
+func (f *formatter) blocks(parent ast.Node, width int, tight bool) []string {
+	var out []string
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		var lines []string
+		if end := f.displayMathEnd(c); end >= 0 {
+			// Display math is copied as written. It may run on into the
+			// following blocks, e.g. when a line of "=" in it turns its
+			// beginning into a setext heading.
+			first := c
+			end = max(end, lastOffset(c))
+			for next := c.NextSibling(); next != nil; next = c.NextSibling() {
+				if start := firstOffset(next); start < 0 || start >= end {
+					break
+				}
+				c = next
+				end = max(end, lastOffset(c))
+			}
+			lines = f.sourceLines(first, end)
+		} else {
+			lines = f.block(c, width)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if len(out) > 0 && !tight {
+			out = append(out, "")
+		}
+		out = append(out, lines...)
+	}
+	return out
+}
+
+func (f *formatter) block(node ast.Node, width int) []string {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return []string{f.heading(n)}
+	case *ast.Paragraph, *ast.TextBlock:
+		return f.inlines(n, width)
+	case *ast.ThematicBreak:
+		return []string{"---"}
+	case *ast.FencedCodeBlock:
+		info := ""
+		if n.Info != nil {
+			info = string(bytes.TrimSpace(n.Info.Segment.Value(f.source)))
+		}
+		return f.code(n, info)
+	case *ast.CodeBlock:
+		return f.code(n, "")
+	case *ast.HTMLBlock:
+		lines := f.lines(n)
+		if n.HasClosure() {
+			lines = append(lines, strings.TrimRight(string(n.ClosureLine.Value(f.source)), "\r\n"))
+		}
+		return lines
+	case *ast.Blockquote:
+		lines := f.blocks(n, width-2, false)
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return lines
+	case *ast.List:
+		return f.list(n, width)
+	case *east.Table:
+		return f.table(n)
+	case *east.DefinitionList:
+		return f.definitionList(n, width)
+	case *east.FootnoteList:
+		var out []string
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			fn, ok := c.(*east.Footnote)
+			if !ok {
+				continue
+			}
+			if len(out) > 0 {
+				out = append(out, "")
+			}
+			lines := f.blocks(fn, width-4, false)
+			out = append(out, hangMarker(lines, "[^"+string(fn.Ref)+"]:", 4)...)
+		}
+		return out
+	case *Admonition:
+		colons := strings.Repeat(":", 2+admonitionDepth(n))
+		open := colons + n.AdmonitionType
+		if n.Title != newAdmonition(n.AdmonitionType, "").Title {
+			open += " " + n.Title
+		}
+		lines := append([]string{open}, f.blocks(n, width, false)...)
+		return append(lines, colons)
+	default:
+		return f.blocks(n, width, false)
+	}
+}
+
+// displayMathEnd returns the source offset after the closing $$ of the
+// last display math in the inline content of n, or -1 when it has none.
+func (f *formatter) displayMathEnd(n ast.Node) int {
+	if n.Type() != ast.TypeBlock || n.Lines().Len() == 0 {
+		return -1
+	}
+	end := -1
+	from := n.Lines().At(0).Start
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			from = max(from, c.Segment.Stop)
+		case *katex.Block:
+			open := bytes.Index(f.source[from:], []byte("$$"))
+			if open < 0 {
+				return end
+			}
+			open += from + 2
+			closing := bytes.Index(f.source[open:], []byte("$$"))
+			if closing < 0 {
+				return end
+			}
+			end = open + closing + 2
+			from = end
+		}
+	}
+	return end
+}
+
+// sourceLines returns the source of the block n up to the end of the line
+// holding offset end, without the indentation and blockquote markers of
+// the containers of n.
+func (f *formatter) sourceLines(n ast.Node, end int) []string {
+	start := firstOffset(n)
+	if _, ok := n.(*ast.Heading); ok {
+		// Include the marker of an ATX heading.
+		i := start
+		for i > 0 && f.source[i-1] == ' ' {
+			i--
+		}
+		if i > 0 && f.source[i-1] == '#' {
+			for i > 0 && f.source[i-1] == '#' {
+				i--
+			}
+			start = i
+		}
+	}
+	lineStart := bytes.LastIndexByte(f.source[:start], '\n') + 1
+	prefix := start - lineStart
+	if i := bytes.IndexByte(f.source[end:], '\n'); i >= 0 {
+		end += i
+	} else {
+		end = len(f.source)
+	}
+
+	lines := strings.Split(string(f.source[start:end]), "\n")
+	for i := 1; i < len(lines); i++ {
+		cut := 0
+		for cut < prefix && cut < len(lines[i]) && (lines[i][cut] == ' ' || lines[i][cut] == '>') {
+			cut++
+		}
+		lines[i] = lines[i][cut:]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines
+}
+
+// firstOffset returns the start of the first source line of n or of its
+// first descendant with source lines, or -1.
+func firstOffset(n ast.Node) int {
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		return n.Lines().At(0).Start
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if start := firstOffset(c); start >= 0 {
+			return start
+		}
+	}
+	return -1
+}
+
+// lastOffset returns the end of the last source line of n or of its last
+// descendant with source lines, or -1.
+func lastOffset(n ast.Node) int {
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		return n.Lines().At(n.Lines().Len() - 1).Stop
+	}
+	for c := n.LastChild(); c != nil; c = c.PreviousSibling() {
+		if stop := lastOffset(c); stop >= 0 {
+			return stop
+		}
+	}
+	return -1
+}
+
+// heading writes an ATX heading, keeping an attribute block written after
+// the text.
+func (f *formatter) heading(n *ast.Heading) string {
+	var parts []string
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		parts = append(parts, strings.TrimSpace(string(seg.Value(f.source))))
+	}
+	s := strings.Repeat("#", n.Level)
+	if content := strings.Join(parts, " "); content != "" {
+		s += " " + content
+	}
+	if lines.Len() > 0 {
+		stop := lines.At(lines.Len() - 1).Stop
+		end := stop
+		for end < len(f.source) && f.source[end] != '\n' {
+			end++
+		}
+		if m := headingAttributePattern.Find(f.source[stop:end]); m != nil {
+			s += " " + strings.TrimSpace(string(m))
+		}
+	}
+	return s
+}
+
+// lines returns the source lines of a block without line endings.
+func (f *formatter) lines(n ast.Node) []string {
+	var out []string
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		out = append(out, strings.TrimRight(string(seg.Value(f.source)), "\r\n"))
+	}
+	return out
+}
+
+// code writes a fenced code block with a fence longer than any fence-like
+// line of the content.
+func (f *formatter) code(n ast.Node, info string) []string {
+	char := f.opts.Fence[0]
+	if char == '`' && strings.Contains(info, "`") {
+		char = '~'
+	}
+	content := f.lines(n)
+	length := 3
+	for _, line := range content {
+		trimmed := strings.TrimLeft(line, " ")
+		run := 0
+		for run < len(trimmed) && trimmed[run] == char {
+			run++
+		}
+		length = max(length, run+1)
+	}
+	fence := strings.Repeat(string(char), length)
+	out := []string{strings.TrimRight(fence+info, " ")}
+	out = append(out, content...)
+	return append(out, fence)
+}
+
+func (f *formatter) list(n *ast.List, width int) []string {
+	bullet := f.listMarker(n)
+	number := n.Start
+	var out []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		marker := string(bullet)
+		if n.IsOrdered() {
+			marker = strconv.Itoa(number) + string(bullet)
+			number++
+		}
+		indent := len(marker) + 1
+		lines := f.blocks(c, width-indent, n.IsTight)
+		if len(out) > 0 && !n.IsTight {
+			out = append(out, "")
+		}
+		out = append(out, hangMarker(lines, marker, indent)...)
+	}
+	return out
+}
+
+// listMarker returns the bullet, or the delimiter after the number, of a
+// list. Lists that directly follow a list of the same kind alternate
+// markers so that they stay separate lists.
+func (f *formatter) listMarker(n *ast.List) byte {
+	primary, alternate := f.opts.Bullet[0], byte('*')
+	if primary == '*' {
+		alternate = '-'
+	}
+	if n.IsOrdered() {
+		primary, alternate = '.', ')'
+	}
+	if prev, ok := n.PreviousSibling().(*ast.List); ok && prev.IsOrdered() == n.IsOrdered() {
+		if f.listMarker(prev) == primary {
+			return alternate
+		}
+	}
+	return primary
+}
+
+// table writes a table with its columns padded to the same width.
+func (f *formatter) table(n *east.Table) []string {
+	var rows [][]string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, strings.TrimSpace(strings.Join(f.lines(cell), " ")))
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(n.Alignments))
+	for i := range widths {
+		widths[i] = 3
+	}
+	for _, cells := range rows {
+		for i, s := range cells {
+			if i < len(widths) {
+				widths[i] = max(widths[i], runewidth.StringWidth(s))
+			}
+		}
+	}
+
+	format := func(cells []string) string {
+		var b strings.Builder
+		b.WriteString("|")
+		for i, w := range widths {
+			s := ""
+			if i < len(cells) {
+				s = cells[i]
+			}
+			b.WriteString(" " + padCell(s, w, n.Alignments[i]) + " |")
+		}
+		return b.String()
+	}
+
+	var out []string
+	for i, cells := range rows {
+		out = append(out, format(cells))
+		if i == 0 {
+			delims := make([]string, len(widths))
+			for col, w := range widths {
+				d := strings.Repeat("-", w)
+				switch n.Alignments[col] {
+				case east.AlignLeft:
+					d = ":" + d[1:]
+				case east.AlignRight:
+					d = d[1:] + ":"
+				case east.AlignCenter:
+					d = ":" + d[2:] + ":"
+				}
+				delims[col] = d
+			}
+			out = append(out, "| "+strings.Join(delims, " | ")+" |")
+		}
+	}
+	return out
+}
+
+func padCell(s string, width int, align east.Alignment) string {
+	fill := width - runewidth.StringWidth(s)
+	if fill <= 0 {
+		return s
+	}
+	switch align {
+	case east.AlignRight:
+		return strings.Repeat(" ", fill) + s
+	case east.AlignCenter:
+		return strings.Repeat(" ", fill/2) + s + strings.Repeat(" ", fill-fill/2)
+	default:
+		return s + strings.Repeat(" ", fill)
+	}
+}
+
+func (f *formatter) definitionList(n *east.DefinitionList, width int) []string {
+	var out []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch item := c.(type) {
+		case *east.DefinitionTerm:
+			if len(out) > 0 && c.PreviousSibling().Kind() != east.KindDefinitionTerm {
+				out = append(out, "")
+			}
+			out = append(out, strings.Join(f.inlines(item, 0), " "))
+		case *east.DefinitionDescription:
+			if !item.IsTight {
+				out = append(out, "")
+			}
+			lines := f.blocks(item, width-2, false)
+			out = append(out, hangMarker(lines, ":", 2)...)
+		}
+	}
+	return out
+}
+
+// references writes the link reference definitions sorted by label.
+func (f *formatter) references(refs []parser.Reference) []string {
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := strings.ToLower(string(refs[i].Label())), strings.ToLower(string(refs[j].Label()))
+		if a != b {
+			return a < b
+		}
+		return string(refs[i].Label()) < string(refs[j].Label())
+	})
+
+	var out []string
+	for _, ref := range refs {
+		dest := string(ref.Destination())
+		if dest == "" || strings.ContainsAny(dest, " \t<>") || strings.Count(dest, "(") != strings.Count(dest, ")") {
+			dest = "<" + dest + ">"
+		}
+		line := "[" + string(ref.Label()) + "]: " + dest
+		if title := string(ref.Title()); title != "" {
+			switch {
+			case !strings.Contains(title, `"`):
+				line += ` "` + title + `"`
+			case !strings.Contains(title, "'"):
+				line += " '" + title + "'"
+			default:
+				line += " (" + title + ")"
+			}
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// inlines writes the inline content of n as written, rewrapped as
+// configured. Lines are only broken at spaces of plain text outside
+// attribute blocks, never before a word that would start a block, and
+// never after an escape or an attribute block, which would drop the line
+// break.
+func (f *formatter) inlines(n ast.Node, width int) []string {
+	breakable := map[int]bool{}
+	keep := f.opts.Wrap == WrapKeep
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *katex.Block:
+			// Display math spans lines of its own.
+			keep = true
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan, *ast.Image, *ast.RawHTML, *ast.AutoLink:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			for i := c.Segment.Start; i < c.Segment.Stop; i++ {
+				if f.source[i] == ' ' {
+					breakable[i] = true
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// A word is text between breakable spaces. Words starting a source
+	// line that has to stay a line of its own, or following a hard line
+	// break, start a new output line.
+	type word struct {
+		text    string
+		newline bool
+	}
+	var words []word
+	lines := n.Lines()
+	forceNext := false
+	// depth counts the open braces of attribute blocks, which must stay on
+	// one line.
+	depth := 0
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		start, stop := seg.Start, seg.Stop
+		for stop > start && (f.source[stop-1] == '\n' || f.source[stop-1] == '\r') {
+			stop--
+		}
+		for start < stop && (f.source[start] == ' ' || f.source[start] == '\t') {
+			start++
+		}
+		line := string(f.source[start:stop])
+		hard := i < lines.Len()-1 && hasHardBreak(line)
+		if !hard {
+			trimmed := strings.TrimRight(line, " \t")
+			stop -= len(line) - len(trimmed)
+			line = trimmed
+		}
+		fixed := isFixedLine(line)
+
+		if keep || fixed {
+			words = append(words, word{text: line, newline: true})
+			forceNext = fixed
+			if hard {
+				forceNext = true
+			}
+			continue
+		}
+		first := true
+		wordStart := start
+		for o := start; o <= stop; o++ {
+			if o < stop {
+				switch f.source[o] {
+				case '{':
+					depth++
+				case '}':
+					depth = max(depth-1, 0)
+				}
+				if !breakable[o] || depth > 0 {
+					continue
+				}
+			}
+			if o > wordStart {
+				words = append(words, word{text: string(f.source[wordStart:o]), newline: first && forceNext})
+				first = false
+			}
+			wordStart = o + 1
+		}
+		forceNext = hard
+	}
+
+	var out []string
+	var cur strings.Builder
+	curWidth := 0
+	for i, w := range words {
+		ww := runewidth.StringWidth(w.text)
+		if i > 0 {
+			prev := words[i-1].text
+			switch {
+			case w.newline:
+				out = append(out, cur.String())
+				cur.Reset()
+				curWidth = 0
+			case width > 0 && curWidth+1+ww > width && !unsafeLineStarts.MatchString(w.text) && !strings.HasSuffix(prev, "\\") && !strings.HasSuffix(prev, "}"):
+				out = append(out, cur.String())
+				cur.Reset()
+				curWidth = 0
+			default:
+				cur.WriteString(" ")
+				curWidth++
+			}
+		}
+		cur.WriteString(w.text)
+		curWidth += ww
+	}
+	if cur.Len() > 0 || len(words) > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+// hasHardBreak reports whether a line ends with a hard line break: two
+// spaces or a backslash.
+func hasHardBreak(line string) bool {
+	if strings.HasSuffix(line, "  ") {
+		return true
+	}
+	trailing := len(line) - len(strings.TrimRight(line, "\\"))
+	return trailing%2 == 1
+}
+
+// isFixedLine reports whether a paragraph line has meaning as a line of
+// its own and must not be joined with its neighbours: include directives,
+// alert markers, page break commands and attribute blocks.
+func isFixedLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return include.IsDirective(line) ||
+		alertPattern.MatchString(trimmed) ||
+		pageBreakCommand.MatchString(trimmed) ||
+		attributeLinePattern.MatchString(trimmed)
+}
+
+// hangMarker puts marker before the first line and indents the others by
+// indent columns.
+func hangMarker(lines []string, marker string, indent int) []string {
+	if len(lines) == 0 {
+		return []string{marker}
+	}
+	pad := strings.Repeat(" ", indent)
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			out[i] = marker + strings.Repeat(" ", max(indent-len(marker), 1)) + line
+		case line == "":
+			out[i] = ""
+		default:
+			out[i] = pad + line
+		}
+	}
+	return out
+}
+
+// admonitionDepth is the nesting depth of admonitions in n, including n.
+func admonitionDepth(n ast.Node) int {
+	depth := 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		depth = max(depth, admonitionDepth(c))
+	}
+	if _, ok := n.(*Admonition); ok {
+		depth++
+	}
+	return depth
+}
@@ -0,0 +1,50 @@
+package converter_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+func newFormatConverter(t *testing.T) *converter.Converter {
+	return newConverter(t, converter.Options{
+		Extensions: converter.ExtensionOptions{Table: true, KaTeX: true},
+	})
+}
+
+// test/katex-examples.md has display math with a line of "=", which
+// Markdown alone would read as a setext heading underline.
+func TestFormatDisplayMath(t *testing.T) {
+	path := "../../../../test/katex-examples.md"
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := newFormatConverter(t)
+	opts := converter.FormatOptions{Wrap: converter.WrapWidth, Width: 20, Bullet: "-", Fence: "```"}
+	out, err := conv.Format(source, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := conv.Format(out, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, out) {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+}
+
+func TestFormatDisplayMathInContainers(t *testing.T) {
+	source := "- item\n  $$\n  a\n  =\n  b\n  $$\n\n> $$\n> x\n> =\n> y\n> $$\n\nText before $$y$$ and after\nwhich wraps.\n"
+	opts := converter.FormatOptions{Wrap: converter.WrapWidth, Width: 20, Bullet: "-", Fence: "```"}
+	out, err := newFormatConverter(t).Format([]byte(source), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != source {
+		t.Errorf("Format changed display math:\n%s", out)
+	}
+}
@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Heading describes a heading of a parsed document.
+type Heading struct {
+	Level int
+	ID    string
+	// Text is plain text, see PlainText.
+	Text string
+}
+
+// TOCEntry is a heading together with the headings nested below it.
+type TOCEntry struct {
+	Heading
+	Children []*TOCEntry
+}
+
+// Headings returns the headings of doc in document order. Text includes the
+// heading number when numbering is enabled.
+func Headings(doc *Document) []Heading {
+	var headings []Heading
+
+	_ = ast.Walk(doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		h, ok := n.(*ast.Heading)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		heading := Heading{
+			Level: h.Level,
+			Text:  PlainText(h, doc.Source),
+		}
+		if id, ok := h.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				heading.ID = string(b)
+			}
+		}
+		headings = append(headings, heading)
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	return headings
+}
+
+// BuildTOC nests a flat list of headings by level.
+func BuildTOC(headings []Heading) []*TOCEntry {
+	var roots []*TOCEntry
+	var stack []*TOCEntry
+
+	for _, h := range headings {
+		entry := &TOCEntry{Heading: h}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= h.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+	}
+
+	return roots
+}
+
+// NodeText returns the plain text content of n and its descendants.
+func NodeText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	writeNodeText(&sb, n, source)
+	return strings.TrimSpace(sb.String())
+}
+
+// PlainText returns the text of n like NodeText, with the HTML entities
+// of typographer substitutions decoded, for output that is not HTML or
+// that is escaped again.
+func PlainText(n ast.Node, source []byte) string {
+	return html.UnescapeString(NodeText(n, source))
+}
+
+// StringText returns the value of s as plain text. The typographer stores
+// its substitutions as HTML entities in code strings.
+func StringText(s *ast.String) string {
+	if s.IsCode() {
+		return html.UnescapeString(string(s.Value))
+	}
+	return string(s.Value)
+}
+
+func writeNodeText(sb *strings.Builder, n ast.Node, source []byte) {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch t := c.(type) {
+		case *ast.Text:
+			sb.Write(t.Segment.Value(source))
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(t.Value)
+		case *HeadingNumber:
+			sb.WriteString(t.Number)
+			sb.WriteByte(' ')
+		default:
+			writeNodeText(sb, c, source)
+		}
+	}
+}
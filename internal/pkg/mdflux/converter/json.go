@@ -0,0 +1,287 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"mdflux/internal/pkg/mdflux/mermaid"
+
+	d2 "github.com/FurqanSoftware/goldmark-d2"
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// JSONVersion is the version of the JSON document schema. It changes
+// only when existing fields change meaning or are removed.
+const JSONVersion = 1
+
+// jsonTypes renames node kinds whose names are ambiguous across
+// extensions.
+var jsonTypes = map[ast.NodeKind]string{
+	d2.KindBlock:     "D2Block",
+	katex.KindBlock:  "MathBlock",
+	katex.KindInline: "MathInline",
+}
+
+type jsonOutput struct {
+	Version   int             `json:"version"`
+	Documents []*jsonDocument `json:"documents"`
+}
+
+type jsonDocument struct {
+	Path        string         `json:"path,omitempty"`
+	FrontMatter map[string]any `json:"front_matter"`
+	Root        *jsonNode      `json:"root"`
+}
+
+type jsonNode struct {
+	Type       string         `json:"type"`
+	Position   *jsonPosition  `json:"position,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Properties map[string]any `json:"properties,omitempty"`
+	Children   []*jsonNode    `json:"children,omitempty"`
+}
+
+type jsonPosition struct {
+	Start jsonPoint `json:"start"`
+	End   jsonPoint `json:"end"`
+}
+
+// jsonPoint is a position in the input file, or in the included file
+// named by File. Lines and columns are 1-based, columns and offsets count
+// bytes.
+type jsonPoint struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+}
+
+// RenderJSON writes the parsed documents as JSON: the front matter and
+// the syntax tree with node types, attributes, properties and source
+// positions. The schema is versioned by JSONVersion.
+func (c *Converter) RenderJSON(w io.Writer, docs []*Document) error {
+	out := jsonOutput{Version: JSONVersion, Documents: []*jsonDocument{}}
+	for _, doc := range docs {
+		jd := &jsonDocument{Path: doc.Path, FrontMatter: map[string]any{}}
+		if doc.Meta != nil && doc.Meta.Params != nil {
+			jd.FrontMatter = doc.Meta.Params
+		}
+		b := &jsonBuilder{doc: doc}
+		jd.Root, _, _ = b.node(doc.Root)
+		out.Documents = append(out.Documents, jd)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return fmt.Errorf("JSON encoding failed: %w", err)
+	}
+	return nil
+}
+
+type jsonBuilder struct {
+	doc *Document
+}
+
+// node converts n and its descendants. It also returns the source span of
+// n, which for nodes without segments of their own is the span of their
+// descendants, or -1 when none has a position.
+func (b *jsonBuilder) node(n ast.Node) (*jsonNode, int, int) {
+	jn := &jsonNode{Type: jsonType(n), Properties: b.properties(n)}
+	if attrs := n.Attributes(); len(attrs) > 0 {
+		jn.Attributes = map[string]any{}
+		for _, attr := range attrs {
+			jn.Attributes[string(attr.Name)] = jsonAttributeValue(attr.Value)
+		}
+	}
+
+	start, stop := ownSpan(n)
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		child, childStart, childStop := b.node(c)
+		jn.Children = append(jn.Children, child)
+		if childStart < 0 {
+			continue
+		}
+		if start < 0 || childStart < start {
+			start = childStart
+		}
+		if childStop > stop {
+			stop = childStop
+		}
+	}
+
+	if n.Kind() == ast.KindDocument {
+		// The document spans the whole input, including leading blank
+		// lines and front matter.
+		jn.Position = &jsonPosition{Start: jsonPoint{Line: 1, Column: 1}, End: inputEnd(b.doc.input)}
+		return jn, 0, len(b.doc.Source)
+	}
+	if start >= 0 {
+		jn.Position = &jsonPosition{Start: b.point(start), End: b.point(stop)}
+	}
+	return jn, start, stop
+}
+
+// ownSpan returns the span of the segments of n itself, or -1.
+func ownSpan(n ast.Node) (int, int) {
+	switch n := n.(type) {
+	case *ast.Text:
+		return n.Segment.Start, n.Segment.Stop
+	case *ast.RawHTML:
+		if n.Segments.Len() > 0 {
+			return n.Segments.At(0).Start, n.Segments.At(n.Segments.Len() - 1).Stop
+		}
+	}
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		lines := n.Lines()
+		return lines.At(0).Start, lines.At(lines.Len() - 1).Stop
+	}
+	return -1, -1
+}
+
+// point maps offset in the document source to the file it came from.
+func (b *jsonBuilder) point(offset int) jsonPoint {
+	pos := b.doc.Position(offset)
+	p := jsonPoint{Line: pos.Line, Column: pos.Column, Offset: pos.Offset}
+	if pos.Included {
+		p.File = pos.File
+	}
+	return p
+}
+
+// inputEnd returns the point after the last byte of input.
+func inputEnd(input []byte) jsonPoint {
+	last := bytes.LastIndexByte(input, '\n')
+	return jsonPoint{
+		Line:   bytes.Count(input, []byte("\n")) + 1,
+		Column: len(input) - last,
+		Offset: len(input),
+	}
+}
+
+func jsonType(n ast.Node) string {
+	if name, ok := jsonTypes[n.Kind()]; ok {
+		return name
+	}
+	return n.Kind().String()
+}
+
+// properties returns the fields of n that are not expressed by its
+// children.
+func (b *jsonBuilder) properties(node ast.Node) map[string]any {
+	source := b.doc.Source
+	switch n := node.(type) {
+	case *ast.Heading:
+		props := map[string]any{"level": n.Level}
+		if id, ok := n.AttributeString("id"); ok {
+			props["id"] = AttributeString(id)
+		}
+		return props
+	case *ast.Text:
+		props := map[string]any{"value": string(n.Segment.Value(source))}
+		if n.SoftLineBreak() {
+			props["soft_line_break"] = true
+		}
+		if n.HardLineBreak() {
+			props["hard_line_break"] = true
+		}
+		return props
+	case *ast.String:
+		return map[string]any{"value": StringText(n)}
+	case *ast.Emphasis:
+		return map[string]any{"level": n.Level}
+	case *ast.Link:
+		return map[string]any{"destination": string(n.Destination), "title": string(n.Title)}
+	case *ast.Image:
+		return map[string]any{"destination": string(n.Destination), "title": string(n.Title)}
+	case *ast.AutoLink:
+		kind := "url"
+		if n.AutoLinkType == ast.AutoLinkEmail {
+			kind = "email"
+		}
+		return map[string]any{"kind": kind, "url": string(n.URL(source)), "label": string(n.Label(source))}
+	case *ast.RawHTML:
+		var raw bytes.Buffer
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			raw.Write(seg.Value(source))
+		}
+		return map[string]any{"value": raw.String()}
+	case *ast.FencedCodeBlock:
+		return map[string]any{
+			"language": string(n.Language(source)),
+			"info":     infoString(n, source),
+			"value":    string(n.Lines().Value(source)),
+		}
+	case *ast.CodeBlock, *ast.HTMLBlock, *d2.Block:
+		return map[string]any{"value": string(n.Lines().Value(source))}
+	case *ast.List:
+		props := map[string]any{"ordered": n.IsOrdered(), "marker": string(n.Marker), "tight": n.IsTight}
+		if n.IsOrdered() {
+			props["start"] = n.Start
+		}
+		return props
+	case *east.Table:
+		aligns := make([]string, len(n.Alignments))
+		for i, a := range n.Alignments {
+			aligns[i] = a.String()
+		}
+		return map[string]any{"alignments": aligns}
+	case *east.TableCell:
+		return map[string]any{"alignment": n.Alignment.String()}
+	case *east.TaskCheckBox:
+		return map[string]any{"checked": n.IsChecked}
+	case *east.Footnote:
+		return map[string]any{"index": n.Index, "ref": string(n.Ref)}
+	case *east.FootnoteLink:
+		return map[string]any{"index": n.Index}
+	case *east.FootnoteBacklink:
+		return map[string]any{"index": n.Index}
+	case *mermaid.CodeBlock:
+		return map[string]any{"value": string(n.Code)}
+	case *katex.Block:
+		return map[string]any{"value": string(n.Equation)}
+	case *katex.Inline:
+		return map[string]any{"value": string(n.Equation)}
+	case *HeadingNumber:
+		return map[string]any{"number": n.Number}
+	case *CrossrefLink:
+		return map[string]any{"label": n.Label, "text": n.DisplayText()}
+	case *Figure:
+		return map[string]any{"label": n.Label, "prefix": n.Prefix, "number": n.Number, "caption": n.Caption}
+	case *Equation:
+		return map[string]any{"label": n.Label, "number": n.Number}
+	case *Admonition:
+		return map[string]any{"admonition_type": n.AdmonitionType, "title": n.Title}
+	}
+	return nil
+}
+
+func infoString(n *ast.FencedCodeBlock, source []byte) string {
+	if n.Info == nil {
+		return ""
+	}
+	return string(n.Info.Segment.Value(source))
+}
+
+// jsonAttributeValue converts the byte slices of parsed attribute values
+// to strings.
+func jsonAttributeValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, e := range v {
+			values[i] = jsonAttributeValue(e)
+		}
+		return values
+	default:
+		return v
+	}
+}
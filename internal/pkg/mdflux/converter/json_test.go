@@ -0,0 +1,83 @@
+package converter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+type jsonTestPoint struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+}
+
+type jsonTestNode struct {
+	Type     string `json:"type"`
+	Position *struct {
+		Start jsonTestPoint `json:"start"`
+		End   jsonTestPoint `json:"end"`
+	} `json:"position"`
+	Children []*jsonTestNode `json:"children"`
+}
+
+// Positions refer to the file a node came from, with the front matter of
+// the input and of included files counted.
+func TestRenderJSONPositions(t *testing.T) {
+	dir := t.TempDir()
+	main := "---\ntitle: T\n---\n# Main\n\n!include parts/a.md\n\nEnd.\n"
+	included := "---\nx: 1\n---\nIncluded.\n"
+	if err := os.MkdirAll(filepath.Join(dir, "parts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "parts", "a.md"), []byte(included), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "main.md")
+	conv := newConverter(t, converter.Options{Extensions: converter.ExtensionOptions{Include: true}})
+	doc, err := conv.Parse([]byte(main), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := conv.RenderJSON(&out, []*converter.Document{doc}); err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Documents []struct {
+			Root *jsonTestNode `json:"root"`
+		} `json:"documents"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	root := parsed.Documents[0].Root
+	if got, want := root.Position.End, (jsonTestPoint{Line: 9, Column: 1, Offset: len(main)}); got != want {
+		t.Errorf("document end = %+v, want %+v", got, want)
+	}
+	includedPath := filepath.Join(dir, "parts", "a.md")
+	want := []struct {
+		typ   string
+		start jsonTestPoint
+	}{
+		{"Heading", jsonTestPoint{Line: 4, Column: 3, Offset: len("---\ntitle: T\n---\n# ")}},
+		{"Paragraph", jsonTestPoint{File: includedPath, Line: 4, Column: 1, Offset: len("---\nx: 1\n---\n")}},
+		{"Paragraph", jsonTestPoint{Line: 8, Column: 1, Offset: len(main) - len("End.\n")}},
+	}
+	if len(root.Children) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(root.Children), len(want))
+	}
+	for i, w := range want {
+		n := root.Children[i]
+		if n.Type != w.typ || n.Position == nil || n.Position.Start != w.start {
+			t.Errorf("block %d = %s at %+v, want %s at %+v", i, n.Type, n.Position, w.typ, w.start)
+		}
+	}
+}
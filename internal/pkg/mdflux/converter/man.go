@@ -0,0 +1,734 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mdflux/internal/pkg/mdflux/mermaid"
+
+	d2 "github.com/FurqanSoftware/goldmark-d2"
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// ManOptions configures man page output. The fields are defaults for the
+// front matter fields of the same name.
+type ManOptions struct {
+	// Section is the manual section, "1" for commands.
+	Section string
+	// Source is the project the page belongs to, such as "mdflux 1.2".
+	Source string
+	// Manual is the title of the manual. It defaults to the name of the
+	// section's volume.
+	Manual string
+}
+
+// manVolumes are the traditional titles of the manual sections.
+var manVolumes = map[string]string{
+	"1": "General Commands Manual",
+	"2": "System Calls Manual",
+	"3": "Library Functions Manual",
+	"4": "Kernel Interfaces Manual",
+	"5": "File Formats Manual",
+	"6": "Games Manual",
+	"7": "Miscellaneous Information Manual",
+	"8": "System Manager's Manual",
+	"9": "Kernel Developer's Manual",
+}
+
+var (
+	// manTitlePattern matches titles that carry the section, "mdflux(1)".
+	manTitlePattern = regexp.MustCompile(`^\s*(\S+)\s*\(([0-9][A-Za-z0-9]*)\)\s*$`)
+	manSectionName  = regexp.MustCompile(`^[0-9][A-Za-z0-9]*$`)
+)
+
+// manBreak marks a hard line break in inline output, which becomes a .br
+// request.
+const manBreak = "\x00"
+
+// RenderMan writes doc as a man page in roff with the man macros. The
+// title, section, date, source and manual of the page come from the front
+// matter, falling back to opts. Unless the document has them, a NAME
+// section is made from the title and description, and a SYNOPSIS section
+// from the synopsis field. A leading level 1 heading is taken as the
+// title, and the highest remaining heading level becomes the sections.
+func (c *Converter) RenderMan(w io.Writer, doc *Document, opts ManOptions) error {
+	r := &manRenderer{source: doc.Source}
+	if err := r.render(doc, opts); err != nil {
+		return fmt.Errorf("man conversion failed: %w", err)
+	}
+	if _, err := io.WriteString(w, r.b.String()); err != nil {
+		return fmt.Errorf("man conversion failed: %w", err)
+	}
+	return nil
+}
+
+type manRenderer struct {
+	source []byte
+	b      strings.Builder
+	// sectionLevel is the heading level written as .SH.
+	sectionLevel int
+	// synopsis is written before the section after NAME when the
+	// document has a NAME section but no SYNOPSIS.
+	synopsis    []string
+	inName      bool
+	hasSynopsis bool
+	// compact is set within tight lists, which turn off paragraph
+	// spacing until the outermost one ends.
+	compact bool
+}
+
+func (r *manRenderer) render(doc *Document, opts ManOptions) error {
+	params := map[string]any{}
+	title, description := "", ""
+	if doc.Meta != nil {
+		if doc.Meta.Params != nil {
+			params = doc.Meta.Params
+		}
+		title = doc.Meta.Title
+		description = doc.Meta.Description
+		if description == "" {
+			description = doc.Meta.Subject
+		}
+	}
+	field := func(key, fallback string) string {
+		if v, ok := params[key]; ok && v != nil {
+			if s := strings.TrimSpace(fmt.Sprint(v)); s != "" {
+				return s
+			}
+		}
+		return fallback
+	}
+
+	// A level 1 heading that starts the document and is the only one is
+	// the title.
+	first := doc.Root.FirstChild()
+	if h, ok := first.(*ast.Heading); !ok || h.Level != 1 || r.countHeadings(doc.Root, 1) != 1 {
+		first = nil
+	} else if title == "" {
+		title = strings.TrimSpace(NodeText(h, r.source))
+	}
+
+	name, section := title, field("section", opts.Section)
+	if m := manTitlePattern.FindStringSubmatch(title); m != nil {
+		name, section = m[1], m[2]
+	}
+	if name == "" {
+		return fmt.Errorf("the page has no name, set title in the front matter")
+	}
+	if !manSectionName.MatchString(section) {
+		return fmt.Errorf("invalid section %q", section)
+	}
+	manual := field("manual", opts.Manual)
+	if manual == "" {
+		manual = manVolumes[section[:1]]
+	}
+	date := ""
+	if doc.Meta != nil {
+		date = doc.Meta.Date
+	}
+
+	r.synopsis = manSynopsis(params["synopsis"])
+	r.sectionLevel = 7
+	var headings []*ast.Heading
+	for n := doc.Root.FirstChild(); n != nil; n = n.NextSibling() {
+		if h, ok := n.(*ast.Heading); ok && n != first {
+			r.sectionLevel = min(r.sectionLevel, h.Level)
+			headings = append(headings, h)
+		}
+	}
+	hasName := false
+	for _, h := range headings {
+		switch strings.ToUpper(r.headingText(h)) {
+		case "NAME":
+			hasName = hasName || h.Level == r.sectionLevel
+		case "SYNOPSIS":
+			r.hasSynopsis = r.hasSynopsis || h.Level == r.sectionLevel
+		}
+	}
+
+	if hasTable(doc.Root) {
+		// Tells man to run the page through tbl.
+		r.b.WriteString("'\\\" t\n")
+	}
+	r.b.WriteString(".\\\" Generated by mdflux\n")
+	r.macro("TH", strings.ToUpper(name), section, date, field("source", opts.Source), manual)
+
+	if !hasName {
+		r.macro("SH", "NAME")
+		nameLine := name
+		if description != "" {
+			nameLine += " - " + description
+		}
+		r.text(manEscape(nameLine))
+		r.writeSynopsis()
+	}
+
+	leading := true
+	for n := doc.Root.FirstChild(); n != nil; n = n.NextSibling() {
+		if n == first {
+			continue
+		}
+		h, isHeading := n.(*ast.Heading)
+		if isHeading && h.Level == r.sectionLevel {
+			leading = false
+		} else if leading {
+			if _, ok := n.(*east.FootnoteList); !ok {
+				r.macro("SH", "DESCRIPTION")
+			}
+			leading = false
+		}
+		if err := r.block(n); err != nil {
+			return err
+		}
+	}
+	if r.inName {
+		r.writeSynopsis()
+	}
+	return nil
+}
+
+// writeSynopsis writes the SYNOPSIS section from the front matter, once,
+// unless the document has one. The first word of each line is the
+// command, in bold.
+func (r *manRenderer) writeSynopsis() {
+	if r.hasSynopsis || len(r.synopsis) == 0 {
+		return
+	}
+	r.hasSynopsis = true
+	r.macro("SH", "SYNOPSIS")
+	for i, line := range r.synopsis {
+		if i > 0 {
+			r.b.WriteString(".br\n")
+		}
+		command, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		s := "\\fB" + manEscape(command) + "\\fR"
+		if args != "" {
+			s += " " + manEscape(args)
+		}
+		r.text(s)
+	}
+}
+
+// manSynopsis returns the lines of the synopsis front matter field, a
+// string or a list of strings.
+func manSynopsis(v any) []string {
+	switch v := v.(type) {
+	case string:
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(v), "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	case []any:
+		var lines []string
+		for _, e := range v {
+			lines = append(lines, manSynopsis(e)...)
+		}
+		return lines
+	}
+	return nil
+}
+
+func (r *manRenderer) countHeadings(root ast.Node, level int) int {
+	count := 0
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := n.(*ast.Heading); ok && entering && h.Level == level {
+			count++
+		}
+		return ast.WalkContinue, nil
+	})
+	return count
+}
+
+func hasTable(root ast.Node) bool {
+	found := false
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if _, ok := n.(*east.Table); ok {
+			found = true
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+// headingText is the text of a heading without its number.
+func (r *manRenderer) headingText(h *ast.Heading) string {
+	s := PlainText(h, r.source)
+	if number, ok := h.FirstChild().(*HeadingNumber); ok {
+		s = strings.TrimPrefix(s, number.Number+" ")
+	}
+	return s
+}
+
+// macro writes a macro call with quoted arguments.
+func (r *manRenderer) macro(name string, args ...string) {
+	r.b.WriteString("." + name)
+	for _, arg := range args {
+		r.b.WriteString(" \"" + strings.ReplaceAll(arg, "\"", "\\(dq") + "\"")
+	}
+	r.b.WriteString("\n")
+}
+
+// text writes escaped inline output as text lines. Lines are trimmed, as
+// leading spaces would break the line, and empty lines are left out.
+func (r *manRenderer) text(s string) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.Trim(line, " ")
+		switch {
+		case line == "":
+		case line == manBreak:
+			r.b.WriteString(".br\n")
+		default:
+			r.b.WriteString(manLineStart(line) + "\n")
+		}
+	}
+}
+
+// literal writes lines without filling, for code and diagrams.
+func (r *manRenderer) literal(lines []string) {
+	r.b.WriteString(".PP\n.RS 4\n.nf\n")
+	for _, line := range lines {
+		r.b.WriteString(manLineStart(manEscape(line)) + "\n")
+	}
+	r.b.WriteString(".fi\n.RE\n")
+}
+
+func (r *manRenderer) blocks(parent ast.Node) error {
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.block(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *manRenderer) block(node ast.Node) error {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return r.heading(n)
+	case *ast.Paragraph, *ast.TextBlock:
+		if IsDisplayMath(n) {
+			s, err := r.inlines(n)
+			if err != nil {
+				return err
+			}
+			r.b.WriteString(".PP\n.RS 4\n")
+			r.text(s)
+			r.b.WriteString(".RE\n")
+			return nil
+		}
+		r.b.WriteString(".PP\n")
+		return r.paragraph(n)
+	case *ast.ThematicBreak:
+		r.b.WriteString(".PP\n.ce 1\n* * *\n")
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		r.literal(strings.Split(strings.TrimRight(string(n.Lines().Value(r.source)), "\n"), "\n"))
+	case *mermaid.CodeBlock:
+		r.b.WriteString(".PP\n[Mermaid diagram]\n")
+	case *d2.Block:
+		tr := newTextRenderer(TextOptions{Diagrams: "ascii"})
+		r.literal(tr.d2(string(n.Lines().Value(r.source))))
+	case *ast.Blockquote:
+		r.b.WriteString(".RS 4\n")
+		if err := r.blocks(n); err != nil {
+			return err
+		}
+		r.b.WriteString(".RE\n")
+	case *ast.List:
+		return r.list(n)
+	case *ast.HTMLBlock, *PageBreak:
+	case *FigureCaption:
+		// The Figure case writes the caption.
+	case *east.Table:
+		return r.table(n)
+	case *east.DefinitionList:
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			switch c := c.(type) {
+			case *east.DefinitionTerm:
+				s, err := r.boldInlines(c)
+				if err != nil {
+					return err
+				}
+				if c.PreviousSibling() != nil && c.PreviousSibling().Kind() == east.KindDefinitionTerm {
+					r.b.WriteString(".TQ\n")
+				} else {
+					r.b.WriteString(".TP\n")
+				}
+				r.text(strings.ReplaceAll(s, "\n", " "))
+			case *east.DefinitionDescription:
+				if c.PreviousSibling() != nil && c.PreviousSibling().Kind() == east.KindDefinitionDescription {
+					r.b.WriteString(".IP\n")
+				}
+				if err := r.item(c); err != nil {
+					return err
+				}
+			}
+		}
+	case *east.FootnoteList:
+		r.macro("SH", "NOTES")
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			fn, ok := c.(*east.Footnote)
+			if !ok {
+				continue
+			}
+			r.macro("IP", fmt.Sprintf("[%d]", fn.Index), "4")
+			if err := r.item(fn); err != nil {
+				return err
+			}
+		}
+	case *Figure:
+		caption := func() {
+			r.b.WriteString(".PP\n")
+			r.text("\\fI" + manEscape(n.CaptionText()) + "\\fR")
+		}
+		if n.Prefix == crossrefTable {
+			caption()
+		}
+		if err := r.blocks(n); err != nil {
+			return err
+		}
+		if n.Prefix != crossrefTable {
+			caption()
+		}
+	case *Admonition:
+		r.b.WriteString(".PP\n")
+		r.text("\\fB" + manEscape(n.Title) + "\\fR")
+		r.b.WriteString(".RS 4\n")
+		if err := r.blocks(n); err != nil {
+			return err
+		}
+		r.b.WriteString(".RE\n")
+	default:
+		return r.blocks(n)
+	}
+	return nil
+}
+
+// heading writes section headings as .SH in capitals, the next level as
+// .SS and deeper levels as bold paragraphs.
+func (r *manRenderer) heading(n *ast.Heading) error {
+	if n.Level == r.sectionLevel {
+		// Section headings are set in bold capitals, so inline markup is
+		// dropped.
+		upper := strings.ToUpper(r.headingText(n))
+		if r.inName && upper != "NAME" {
+			r.writeSynopsis()
+		}
+		r.inName = upper == "NAME"
+		r.macro("SH", manEscape(upper))
+		return nil
+	}
+
+	if n.Level == r.sectionLevel+1 {
+		s, err := r.inlines(n)
+		if err != nil {
+			return err
+		}
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\n"+manBreak+"\n", " "), "\n", " ")
+		r.b.WriteString(".SS " + manLineStart(strings.TrimSpace(s)) + "\n")
+		return nil
+	}
+	s, err := r.boldInlines(n)
+	if err != nil {
+		return err
+	}
+	r.b.WriteString(".PP\n")
+	r.text(s)
+	return nil
+}
+
+func (r *manRenderer) paragraph(n ast.Node) error {
+	s, err := r.inlines(n)
+	if err != nil {
+		return err
+	}
+	r.text(s)
+	return nil
+}
+
+// item writes the blocks of a list item, footnote or definition after
+// its tag: a leading paragraph continues the tag's line, and the other
+// blocks are indented to the tag's indent.
+func (r *manRenderer) item(n ast.Node) error {
+	c := n.FirstChild()
+	switch c.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		if !IsDisplayMath(c) {
+			if err := r.paragraph(c); err != nil {
+				return err
+			}
+			c = c.NextSibling()
+		}
+	}
+	if c == nil {
+		return nil
+	}
+	r.b.WriteString(".RS\n")
+	for ; c != nil; c = c.NextSibling() {
+		if err := r.block(c); err != nil {
+			return err
+		}
+	}
+	r.b.WriteString(".RE\n")
+	return nil
+}
+
+func (r *manRenderer) list(n *ast.List) error {
+	compact := n.IsTight && !r.compact
+	if compact {
+		r.b.WriteString(".PD 0\n")
+		r.compact = true
+		defer func() {
+			r.b.WriteString(".PD\n")
+			r.compact = false
+		}()
+	}
+	number := n.Start
+	indent := "2"
+	if n.IsOrdered() {
+		indent = strconv.Itoa(len(strconv.Itoa(n.Start+n.ChildCount()-1)) + 2)
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		tag := "\\(bu"
+		if n.IsOrdered() {
+			tag = strconv.Itoa(number) + string(n.Marker)
+			number++
+		}
+		r.b.WriteString(".IP " + tag + " " + indent + "\n")
+		if err := r.item(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// table writes a table for the tbl preprocessor, with bold headers.
+// Cells are separated by tabs.
+func (r *manRenderer) table(n *east.Table) error {
+	r.b.WriteString(".PP\n.TS\nallbox;\n")
+	var formats []string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cols []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			col := "l"
+			switch cell.(*east.TableCell).Alignment {
+			case east.AlignRight:
+				col = "r"
+			case east.AlignCenter:
+				col = "c"
+			}
+			if row.Kind() == east.KindTableHeader {
+				col += "b"
+			}
+			cols = append(cols, col)
+		}
+		formats = append(formats, strings.Join(cols, " "))
+		if row.Kind() != east.KindTableHeader {
+			// The last format line applies to the remaining rows.
+			break
+		}
+	}
+	r.b.WriteString(strings.Join(formats, "\n") + ".\n")
+
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			s, err := r.inlines(cell)
+			if err != nil {
+				return err
+			}
+			s = strings.ReplaceAll(strings.ReplaceAll(s, "\n"+manBreak+"\n", " "), "\n", " ")
+			cells = append(cells, strings.ReplaceAll(strings.TrimSpace(s), "\t", " "))
+		}
+		r.b.WriteString(manLineStart(strings.Join(cells, "\t")) + "\n")
+	}
+	r.b.WriteString(".TE\n")
+	return nil
+}
+
+// inlines renders the inline children of n as escaped roff text. Soft
+// line breaks are newlines, hard line breaks are manBreak lines.
+func (r *manRenderer) inlines(n ast.Node) (string, error) {
+	iw := &manInlineWriter{}
+	if err := r.inlineChildren(iw, n); err != nil {
+		return "", err
+	}
+	return iw.b.String(), nil
+}
+
+// boldInlines is inlines set in bold.
+func (r *manRenderer) boldInlines(n ast.Node) (string, error) {
+	iw := &manInlineWriter{}
+	err := iw.font(true, false, func() error {
+		return r.inlineChildren(iw, n)
+	})
+	return iw.b.String(), err
+}
+
+func (r *manRenderer) inlineChildren(iw *manInlineWriter, n ast.Node) error {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.inline(iw, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *manRenderer) inline(iw *manInlineWriter, node ast.Node) error {
+	children := func() error {
+		return r.inlineChildren(iw, node)
+	}
+	switch n := node.(type) {
+	case *ast.Text:
+		iw.b.WriteString(manEscape(string(n.Segment.Value(r.source))))
+		if n.HardLineBreak() {
+			iw.b.WriteString("\n" + manBreak + "\n")
+		} else if n.SoftLineBreak() {
+			iw.b.WriteString("\n")
+		}
+	case *ast.String:
+		iw.b.WriteString(manEscape(StringText(n)))
+	case *ast.CodeSpan:
+		return iw.font(true, false, children)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			return iw.font(true, false, children)
+		}
+		return iw.font(false, true, children)
+	case *ast.Link:
+		start := iw.b.Len()
+		if err := children(); err != nil {
+			return err
+		}
+		dest := string(n.Destination)
+		label := iw.b.String()[start:]
+		if dest != "" && !strings.HasPrefix(dest, "#") && label != manEscape(dest) {
+			iw.b.WriteString(" <" + manEscape(dest) + ">")
+		}
+	case *ast.AutoLink:
+		iw.b.WriteString(manEscape(string(n.Label(r.source))))
+	case *ast.Image:
+		alt := strings.TrimSpace(NodeText(n, r.source))
+		if alt == "" {
+			iw.b.WriteString("[Image]")
+		} else {
+			iw.b.WriteString("[Image: " + manEscape(alt) + "]")
+		}
+	case *ast.RawHTML:
+		var raw strings.Builder
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			raw.Write(seg.Value(r.source))
+		}
+		if lineBreakTag.MatchString(strings.TrimSpace(raw.String())) {
+			iw.b.WriteString("\n" + manBreak + "\n")
+		}
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			iw.b.WriteString("[x] ")
+		} else {
+			iw.b.WriteString("[ ] ")
+		}
+	case *east.FootnoteLink:
+		iw.b.WriteString(fmt.Sprintf("[%d]", n.Index))
+	case *east.FootnoteBacklink:
+	case *HeadingNumber:
+		iw.b.WriteString(manEscape(n.Number) + " ")
+	case *CrossrefLink:
+		iw.b.WriteString(manEscape(n.DisplayText()))
+	case *Equation:
+		if err := children(); err != nil {
+			return err
+		}
+		iw.b.WriteString(" (" + manEscape(n.Number) + ")")
+	case *katex.Block:
+		return iw.font(false, true, func() error {
+			iw.b.WriteString(manEscape(strings.TrimSpace(string(n.Equation))))
+			return nil
+		})
+	case *katex.Inline:
+		return iw.font(false, true, func() error {
+			iw.b.WriteString(manEscape(string(n.Equation)))
+			return nil
+		})
+	default:
+		return children()
+	}
+	return nil
+}
+
+// manInlineWriter collects inline roff text. Fonts nest: closing one
+// selects the font of the enclosing nodes. Escapes are only written where
+// the font changes.
+type manInlineWriter struct {
+	b            strings.Builder
+	bold, italic int
+	shown        string
+}
+
+func (iw *manInlineWriter) font(bold, italic bool, content func() error) error {
+	if bold {
+		iw.bold++
+	}
+	if italic {
+		iw.italic++
+	}
+	iw.switchFont()
+	err := content()
+	if bold {
+		iw.bold--
+	}
+	if italic {
+		iw.italic--
+	}
+	iw.switchFont()
+	return err
+}
+
+// switchFont selects the current font if it differs from the last one
+// written. Text starts in the roman font.
+func (iw *manInlineWriter) switchFont() {
+	f := iw.current()
+	if iw.shown == "" {
+		iw.shown = "\\fR"
+	}
+	if f != iw.shown {
+		iw.b.WriteString(f)
+		iw.shown = f
+	}
+}
+
+func (iw *manInlineWriter) current() string {
+	switch {
+	case iw.bold > 0 && iw.italic > 0:
+		return "\\f(BI"
+	case iw.bold > 0:
+		return "\\fB"
+	case iw.italic > 0:
+		return "\\fI"
+	}
+	return "\\fR"
+}
+
+// manEscape escapes backslashes and writes hyphens as minus signs, which
+// keeps options searchable and copyable.
+func manEscape(s string) string {
+	return strings.NewReplacer("\\", "\\e", "-", "\\-").Replace(s)
+}
+
+// manLineStart protects a text line that would otherwise be read as a
+// request.
+func manLineStart(line string) string {
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		return "\\&" + line
+	}
+	return line
+}
@@ -0,0 +1,259 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	defaultNumberingFormat = "1.1"
+	unnumberedClass        = "unnumbered"
+	maxHeadingLevel        = 6
+)
+
+// NumberingOptions configures hierarchical heading numbering.
+type NumberingOptions struct {
+	Enabled bool
+	// StartLevel is the first heading level that is numbered. Setting it to
+	// 2 leaves H1 titles unnumbered and numbers H2 as 1, 2, 3.
+	StartLevel int
+	// MaxLevel is the deepest heading level that is numbered.
+	MaxLevel int
+	// Format describes the counter style of each level, e.g. "1.1",
+	// "1.1.", "A.1" or "I.A.1.a". Supported styles are 1 (decimal), a and A
+	// (alphabetic) and i and I (roman). The last style repeats for deeper
+	// levels and any text after it becomes a suffix.
+	Format string
+}
+
+// ValidateNumberFormat reports whether format can be used as
+// NumberingOptions.Format.
+func ValidateNumberFormat(format string) error {
+	_, err := parseNumberFormat(format)
+	return err
+}
+
+var headingNumbererKey = parser.NewContextKey()
+
+// WithHeadingNumberer stores n in pc so that every document parsed with pc
+// continues the same numbering sequence instead of starting at 1.
+func WithHeadingNumberer(pc parser.Context, n *HeadingNumberer) {
+	pc.Set(headingNumbererKey, n)
+}
+
+// KindHeadingNumber is the node kind of HeadingNumber.
+var KindHeadingNumber = ast.NewNodeKind("HeadingNumber")
+
+// HeadingNumber is the section number prepended to a numbered heading.
+type HeadingNumber struct {
+	ast.BaseInline
+	Number string
+}
+
+func (n *HeadingNumber) Kind() ast.NodeKind {
+	return KindHeadingNumber
+}
+
+func (n *HeadingNumber) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Number": n.Number}, nil)
+}
+
+// HeadingNumberer assigns hierarchical numbers (1, 1.1, 1.1.2) to headings.
+// Counters persist across calls to Apply so that several documents can be
+// numbered as one continuous sequence.
+type HeadingNumberer struct {
+	opts     NumberingOptions
+	format   numberFormat
+	counters [maxHeadingLevel]int
+}
+
+// NewHeadingNumberer returns a numberer for opts. An invalid format falls
+// back to the default "1.1"; use ValidateNumberFormat to reject it earlier.
+func NewHeadingNumberer(opts NumberingOptions) *HeadingNumberer {
+	if opts.StartLevel < 1 {
+		opts.StartLevel = 1
+	}
+	if opts.MaxLevel < 1 || opts.MaxLevel > maxHeadingLevel {
+		opts.MaxLevel = maxHeadingLevel
+	}
+
+	format, err := parseNumberFormat(opts.Format)
+	if err != nil {
+		format, _ = parseNumberFormat(defaultNumberingFormat)
+	}
+
+	return &HeadingNumberer{
+		opts:   opts,
+		format: format,
+	}
+}
+
+// Apply prepends a HeadingNumber to every numbered heading below root.
+// Headings with the class "unnumbered" are skipped and do not advance the
+// counters.
+func (n *HeadingNumberer) Apply(root ast.Node) {
+	if !n.opts.Enabled {
+		return
+	}
+
+	var headings []*ast.Heading
+	_ = ast.Walk(root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := node.(*ast.Heading); ok && entering {
+			headings = append(headings, h)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, h := range headings {
+		if h.Level < n.opts.StartLevel || h.Level > n.opts.MaxLevel || hasClass(h, unnumberedClass) {
+			continue
+		}
+
+		depth := h.Level - n.opts.StartLevel
+		n.counters[depth]++
+		for i := depth + 1; i < len(n.counters); i++ {
+			n.counters[i] = 0
+		}
+
+		number := &HeadingNumber{Number: n.format.render(n.counters[:depth+1])}
+		if h.FirstChild() != nil {
+			h.InsertBefore(h, h.FirstChild(), number)
+		} else {
+			h.AppendChild(h, number)
+		}
+	}
+}
+
+// numberingTransformer numbers headings after parsing. Documents parsed with
+// a context carrying a HeadingNumberer share it; otherwise every document is
+// numbered from 1.
+type numberingTransformer struct {
+	opts NumberingOptions
+}
+
+func (t *numberingTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	n, ok := pc.Get(headingNumbererKey).(*HeadingNumberer)
+	if !ok {
+		n = NewHeadingNumberer(t.opts)
+	}
+	n.Apply(node)
+}
+
+func hasClass(n ast.Node, class string) bool {
+	v, ok := n.AttributeString("class")
+	if !ok {
+		return false
+	}
+	var classes string
+	switch c := v.(type) {
+	case []byte:
+		classes = string(c)
+	case string:
+		classes = c
+	}
+	for _, field := range strings.Fields(classes) {
+		if field == class {
+			return true
+		}
+	}
+	return false
+}
+
+type numberFormat struct {
+	styles     []byte
+	separators []string
+	suffix     string
+}
+
+func parseNumberFormat(format string) (numberFormat, error) {
+	if format == "" {
+		format = defaultNumberingFormat
+	}
+
+	var f numberFormat
+	var sep strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch c {
+		case '1', 'a', 'A', 'i', 'I':
+			if len(f.styles) > 0 {
+				f.separators = append(f.separators, sep.String())
+			} else if sep.Len() > 0 {
+				return numberFormat{}, fmt.Errorf("number format %q must start with a counter style", format)
+			}
+			sep.Reset()
+			f.styles = append(f.styles, c)
+		default:
+			sep.WriteByte(c)
+		}
+	}
+	if len(f.styles) == 0 {
+		return numberFormat{}, fmt.Errorf("number format %q contains no counter style (1, a, A, i, I)", format)
+	}
+	f.suffix = sep.String()
+
+	return f, nil
+}
+
+func (f numberFormat) render(counters []int) string {
+	var sb strings.Builder
+	for i, c := range counters {
+		if i > 0 {
+			sep := "."
+			if len(f.separators) > 0 {
+				sep = f.separators[min(i-1, len(f.separators)-1)]
+			}
+			sb.WriteString(sep)
+		}
+		sb.WriteString(formatCounter(c, f.styles[min(i, len(f.styles)-1)]))
+	}
+	sb.WriteString(f.suffix)
+	return sb.String()
+}
+
+func formatCounter(n int, style byte) string {
+	if n <= 0 {
+		return "0"
+	}
+	switch style {
+	case 'a':
+		return alphaCounter(n, 'a')
+	case 'A':
+		return alphaCounter(n, 'A')
+	case 'i':
+		return strings.ToLower(romanCounter(n))
+	case 'I':
+		return romanCounter(n)
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+func alphaCounter(n int, base byte) string {
+	var b []byte
+	for n > 0 {
+		n--
+		b = append([]byte{base + byte(n%26)}, b...)
+		n /= 26
+	}
+	return string(b)
+}
+
+func romanCounter(n int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var sb strings.Builder
+	for i, v := range values {
+		for n >= v {
+			sb.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return sb.String()
+}
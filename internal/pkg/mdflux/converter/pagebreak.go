@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+var (
+	pageBreakComment = regexp.MustCompile(`(?i)^<!--\s*(pagebreak|page-break|newpage)\s*-->$`)
+	pageBreakCommand = regexp.MustCompile(`^\\(newpage|pagebreak|clearpage)$`)
+)
+
+// KindPageBreak is the node kind of PageBreak.
+var KindPageBreak = ast.NewNodeKind("PageBreak")
+
+// PageBreak forces the following content onto a new page when printing.
+type PageBreak struct {
+	ast.BaseBlock
+}
+
+func (n *PageBreak) Kind() ast.NodeKind {
+	return KindPageBreak
+}
+
+func (n *PageBreak) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// pageBreakTransformer replaces <!-- pagebreak --> comments and \newpage
+// lines with page breaks. Comments are recognised even when raw HTML is
+// not rendered.
+type pageBreakTransformer struct{}
+
+func (t *pageBreakTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var breaks []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Type() != ast.TypeBlock || n.Lines().Len() != 1 {
+			return ast.WalkContinue, nil
+		}
+		line := n.Lines().At(0)
+		value := bytes.TrimSpace(line.Value(source))
+		switch n.(type) {
+		case *ast.HTMLBlock:
+			if pageBreakComment.Match(value) {
+				breaks = append(breaks, n)
+			}
+		case *ast.Paragraph:
+			if pageBreakCommand.Match(value) {
+				breaks = append(breaks, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range breaks {
+		n.Parent().ReplaceChild(n.Parent(), n, &PageBreak{})
+	}
+}
@@ -0,0 +1,200 @@
+package converter
+
+import (
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// nodeRenderer renders the AST nodes introduced by the converter itself.
+type nodeRenderer struct {
+	xhtml bool
+}
+
+func (r *nodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindHeadingNumber, r.renderHeadingNumber)
+	reg.Register(KindCrossrefLink, r.renderCrossrefLink)
+	reg.Register(KindFigure, r.renderFigure)
+	reg.Register(KindFigureCaption, r.renderFigureCaption)
+	reg.Register(KindEquation, r.renderEquation)
+	reg.Register(KindAdmonition, r.renderAdmonition)
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+	reg.Register(KindPageBreak, r.renderPageBreak)
+}
+
+// renderClassAttributes writes a class attribute made of class and any
+// classes set on node, followed by the other attributes of node.
+func renderClassAttributes(w util.BufWriter, node ast.Node, class string) {
+	if v, ok := node.AttributeString("class"); ok {
+		class += " " + AttributeString(v)
+	}
+	_, _ = w.WriteString(` class="`)
+	_, _ = w.Write(util.EscapeHTML([]byte(class)))
+	_ = w.WriteByte('"')
+	for _, attr := range node.Attributes() {
+		if string(attr.Name) == "class" || string(attr.Name) == "id" {
+			continue
+		}
+		if !html.GlobalAttributeFilter.Contains(attr.Name) {
+			continue
+		}
+		_, _ = w.WriteString(" ")
+		_, _ = w.Write(attr.Name)
+		_, _ = w.WriteString(`="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(AttributeString(attr.Value))))
+		_ = w.WriteByte('"')
+	}
+}
+
+func (r *nodeRenderer) renderHeadingNumber(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*HeadingNumber)
+	_, _ = w.WriteString(`<span class="heading-number">`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.Number)))
+	_, _ = w.WriteString("</span> ")
+
+	return ast.WalkContinue, nil
+}
+
+func (r *nodeRenderer) renderCrossrefLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*CrossrefLink)
+	_, _ = w.WriteString(`<a class="xref" href="#`)
+	_, _ = w.Write(util.EscapeHTML(util.URLEscape([]byte(n.Label), false)))
+	_, _ = w.WriteString(`">`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.DisplayText())))
+	_, _ = w.WriteString("</a>")
+
+	return ast.WalkContinue, nil
+}
+
+func (r *nodeRenderer) renderFigure(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Figure)
+
+	if entering {
+		_, _ = w.WriteString(`<figure`)
+		renderClassAttributes(w, n, "figure-"+n.Prefix)
+		_, _ = w.WriteString(` id="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.Label)))
+		_, _ = w.WriteString("\">\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("</figure>\n")
+
+	return ast.WalkContinue, nil
+}
+
+func (r *nodeRenderer) renderFigureCaption(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		fig := node.Parent().(*Figure)
+		_, _ = w.WriteString("<figcaption>")
+		_, _ = w.Write(util.EscapeHTML([]byte(fig.name())))
+		if node.HasChildren() {
+			_, _ = w.WriteString(": ")
+		}
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("</figcaption>\n")
+
+	return ast.WalkContinue, nil
+}
+
+// renderEquation renders labelled display math. The math stays inside its
+// paragraph, so the equation is a span and KaTeX is called directly: its
+// block renderer wraps the first rendering of an equation in a div.
+func (r *nodeRenderer) renderEquation(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Equation)
+
+	_, _ = w.WriteString(`<span class="equation" id="`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.Label)))
+	_, _ = w.WriteString(`">`)
+	if math, ok := n.FirstChild().(*katex.Block); ok {
+		if err := katex.Render(w, math.Equation, true); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	_, _ = w.WriteString(`<span class="equation-number">(`)
+	_, _ = w.WriteString(n.Number)
+	_, _ = w.WriteString(")</span></span>")
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *nodeRenderer) renderAdmonition(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Admonition)
+
+	// XHTML 1.0 Strict has no aside element.
+	tag := "aside"
+	if r.xhtml {
+		tag = "div"
+	}
+
+	if entering {
+		_, _ = w.WriteString("<" + tag)
+		if v, ok := n.AttributeString("id"); ok {
+			_, _ = w.WriteString(` id="`)
+			_, _ = w.Write(util.EscapeHTML([]byte(AttributeString(v))))
+			_ = w.WriteByte('"')
+		}
+		renderClassAttributes(w, n, "admonition admonition-"+n.AdmonitionType)
+		_, _ = w.WriteString(">\n")
+		_, _ = w.WriteString(`<p class="admonition-title">`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.Title)))
+		_, _ = w.WriteString("</p>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("</" + tag + ">\n")
+
+	return ast.WalkContinue, nil
+}
+
+func (r *nodeRenderer) renderPageBreak(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(`<div class="page-break"></div>` + "\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+// renderFencedCodeBlock renders like the goldmark HTML renderer but also
+// writes the attributes of the block onto the pre element.
+func (r *nodeRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.FencedCodeBlock)
+
+	if entering {
+		_, _ = w.WriteString("<pre")
+		if n.Attributes() != nil {
+			html.RenderAttributes(w, n, html.GlobalAttributeFilter)
+		}
+		_, _ = w.WriteString("><code")
+		if language := n.Language(source); language != nil {
+			_, _ = w.WriteString(` class="language-`)
+			html.DefaultWriter.Write(w, language)
+			_ = w.WriteByte('"')
+		}
+		_ = w.WriteByte('>')
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			html.DefaultWriter.RawWrite(w, line.Value(source))
+		}
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("</code></pre>\n")
+
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,139 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Slide split modes of SlideOptions.
+const (
+	SplitBoth    = "both"
+	SplitRule    = "rule"
+	SplitHeading = "heading"
+)
+
+// SlideOptions configures slide decks.
+type SlideOptions struct {
+	// Split starts a new slide at a thematic break (---), which is
+	// dropped, at a level 2 heading, or at either.
+	Split string
+}
+
+// notesPattern matches the start of speaker notes, a paragraph beginning
+// with "Note:" or "Notes:".
+var notesPattern = regexp.MustCompile(`(?i)^notes?:[ \t]*`)
+
+// slide is a section of a deck: the blocks shown and the blocks that are
+// speaker notes.
+type slide struct {
+	doc     *Document
+	content []ast.Node
+	notes   []ast.Node
+}
+
+// RenderSlides writes docs as one slide deck, a page with a section per
+// slide, keyboard navigation and speaker notes. The page title and meta
+// tags come from the front matter of the first document.
+func (c *Converter) RenderSlides(w io.Writer, docs []*Document, opts SlideOptions) error {
+	if opts.Split != SplitBoth && opts.Split != SplitRule && opts.Split != SplitHeading {
+		return fmt.Errorf("unsupported slide split %q, expected both, rule or heading", opts.Split)
+	}
+
+	var slides []*slide
+	for _, doc := range docs {
+		slides = append(slides, splitSlides(doc, opts.Split)...)
+	}
+
+	var meta *Document
+	if len(docs) > 0 {
+		meta = docs[0]
+	}
+	if err := c.WriteHeader(w, DocumentHeader(meta)); err != nil {
+		return err
+	}
+	for i, s := range slides {
+		if _, err := fmt.Fprintf(w, "<section class=\"slide\" id=\"slide-%d\">\n", i+1); err != nil {
+			return err
+		}
+		for _, n := range s.content {
+			if err := c.RenderNode(w, s.doc, n); err != nil {
+				return err
+			}
+		}
+		if len(s.notes) > 0 {
+			if _, err := io.WriteString(w, "<aside class=\"notes\">\n"); err != nil {
+				return err
+			}
+			for _, n := range s.notes {
+				if err := c.RenderNode(w, s.doc, n); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, "</aside>\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "</section>\n"); err != nil {
+			return err
+		}
+	}
+	return c.WriteFooter(w)
+}
+
+// splitSlides divides the top-level blocks of doc into slides. Within a
+// slide, a paragraph starting with "Note:" and the blocks after it are
+// speaker notes.
+func splitSlides(doc *Document, split string) []*slide {
+	var slides []*slide
+	cur := &slide{doc: doc}
+	flush := func() {
+		if len(cur.content) > 0 || len(cur.notes) > 0 {
+			slides = append(slides, cur)
+		}
+		cur = &slide{doc: doc}
+	}
+
+	for n := doc.Root.FirstChild(); n != nil; n = n.NextSibling() {
+		if _, ok := n.(*ast.ThematicBreak); ok && split != SplitHeading {
+			flush()
+			continue
+		}
+		if h, ok := n.(*ast.Heading); ok && h.Level == 2 && split != SplitRule {
+			flush()
+		}
+		if len(cur.notes) > 0 || stripNotesMarker(n, doc.Source) {
+			cur.notes = append(cur.notes, n)
+			continue
+		}
+		cur.content = append(cur.content, n)
+	}
+	flush()
+	return slides
+}
+
+// stripNotesMarker reports whether n is a paragraph starting speaker notes
+// and removes the marker from its text.
+func stripNotesMarker(n ast.Node, source []byte) bool {
+	p, ok := n.(*ast.Paragraph)
+	if !ok {
+		return false
+	}
+	t, ok := p.FirstChild().(*ast.Text)
+	if !ok {
+		return false
+	}
+	m := notesPattern.Find(t.Segment.Value(source))
+	if m == nil {
+		return false
+	}
+	if len(m) == t.Segment.Len() && (t.NextSibling() == nil || t.SoftLineBreak() || t.HardLineBreak()) {
+		p.RemoveChild(p, t)
+	} else {
+		t.Segment = text.NewSegment(t.Segment.Start+len(m), t.Segment.Stop)
+	}
+	return true
+}
@@ -21,9 +21,18 @@ type Templates struct {
 }
 
 type HeaderData struct {
-	Title  string
-	Styles string
-	Theme  string
+	Title       string
+	Styles      string
+	Theme       string
+	Lang        string
+	Author      string
+	Description string
+	Keywords    string
+	// Status is the front matter status, which can enable the watermark.
+	Status string
+	// Watermark is the watermark markup. It is filled in from the converter
+	// when left empty.
+	Watermark string
 }
 
 func ParseTemplates(templateFS fs.FS) (*Templates, error) {
@@ -0,0 +1,704 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mdflux/internal/pkg/mdflux/mermaid"
+
+	d2 "github.com/FurqanSoftware/goldmark-d2"
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/mattn/go-runewidth"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2elklayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2renderers/d2ascii"
+	"oss.terrastruct.com/d2/d2renderers/d2ascii/charset"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	d2log "oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// TextOptions configures plain text and ANSI terminal output.
+type TextOptions struct {
+	// Width is the column paragraphs are wrapped at; 0 disables wrapping.
+	Width int
+	// ANSI styles the text with escape sequences for terminals.
+	ANSI bool
+	// Diagrams is "ascii" to draw D2 diagrams with box characters, or
+	// "placeholder" to replace them like Mermaid diagrams.
+	Diagrams string
+}
+
+// SGR parameters of the ANSI styles.
+const (
+	ansiBold      = "1"
+	ansiDim       = "2"
+	ansiItalic    = "3"
+	ansiStrike    = "9"
+	ansiHeading   = "1;36"
+	ansiCode      = "33"
+	ansiLink      = "4;34"
+	ansiCheckMark = "32"
+)
+
+var (
+	ansiSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	lineBreakTag = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
+	admonitionColors = map[string]string{
+		"note":      "34",
+		"tip":       "32",
+		"important": "35",
+		"warning":   "33",
+		"caution":   "31",
+	}
+)
+
+// RenderText writes doc as wrapped plain text, or styled for terminals
+// with opts.ANSI.
+func (c *Converter) RenderText(w io.Writer, doc *Document, opts TextOptions) error {
+	if err := newTextRenderer(opts).Render(w, doc.Source, doc.Root); err != nil {
+		return fmt.Errorf("text conversion failed: %w", err)
+	}
+	return nil
+}
+
+// textRenderer is a goldmark renderer for plain text and ANSI output. It
+// renders each block to lines, which enclosing blocks indent or prefix.
+type textRenderer struct {
+	opts   TextOptions
+	source []byte
+}
+
+func newTextRenderer(opts TextOptions) *textRenderer {
+	return &textRenderer{opts: opts}
+}
+
+// AddOptions implements renderer.Renderer. The text renderer has no
+// node renderers to add.
+func (r *textRenderer) AddOptions(...renderer.Option) {}
+
+// Render implements renderer.Renderer.
+func (r *textRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
+	r.source = source
+	lines, err := r.block(n, r.opts.Width)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocks renders the children of parent, separated by blank lines unless
+// tight.
+func (r *textRenderer) blocks(parent ast.Node, width int, tight bool) ([]string, error) {
+	var out []string
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		lines, err := r.block(c, width)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if len(out) > 0 && !tight {
+			out = append(out, "")
+		}
+		out = append(out, lines...)
+	}
+	return out, nil
+}
+
+func (r *textRenderer) block(node ast.Node, width int) ([]string, error) {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return r.heading(n, width)
+	case *ast.Paragraph, *ast.TextBlock:
+		s, err := r.inlines(n)
+		if err != nil {
+			return nil, err
+		}
+		if IsDisplayMath(n) {
+			return indentLines(wrap(s, width-4), "    "), nil
+		}
+		return wrap(s, width), nil
+	case *ast.ThematicBreak:
+		return []string{r.rule(width)}, nil
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		return r.code(string(n.Lines().Value(r.source))), nil
+	case *mermaid.CodeBlock:
+		return []string{r.styled(ansiDim, "[Mermaid diagram]")}, nil
+	case *d2.Block:
+		return r.d2(string(n.Lines().Value(r.source))), nil
+	case *ast.Blockquote:
+		lines, err := r.blocks(n, width-2, false)
+		if err != nil {
+			return nil, err
+		}
+		return r.quote(lines, ansiDim), nil
+	case *ast.List:
+		return r.list(n, width)
+	case *ast.HTMLBlock:
+		return nil, nil
+	case *east.Table:
+		return r.table(n)
+	case *east.DefinitionList:
+		var out []string
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			lines, err := r.block(c, width)
+			if err != nil {
+				return nil, err
+			}
+			if len(out) > 0 && c.Kind() == east.KindDefinitionTerm {
+				out = append(out, "")
+			}
+			out = append(out, lines...)
+		}
+		return out, nil
+	case *east.DefinitionTerm:
+		s, err := r.inlines(n)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(r.styled(ansiBold, s), width), nil
+	case *east.DefinitionDescription:
+		lines, err := r.blocks(n, width-4, false)
+		if err != nil {
+			return nil, err
+		}
+		return indentLines(lines, "    "), nil
+	case *east.FootnoteList:
+		return r.footnotes(n, width)
+	case *Figure:
+		lines, err := r.blocks(n, width, false)
+		if err != nil {
+			return nil, err
+		}
+		caption := wrap(r.styled(ansiItalic, n.CaptionText()), width)
+		if n.Prefix == crossrefTable {
+			return append(caption, lines...), nil
+		}
+		return append(lines, caption...), nil
+	case *Admonition:
+		lines, err := r.blocks(n, width-2, false)
+		if err != nil {
+			return nil, err
+		}
+		color := admonitionColors[n.AdmonitionType]
+		title := r.styled(ansiBold+";"+color, n.Title)
+		if color == "" {
+			title = r.styled(ansiBold, n.Title)
+		}
+		return r.quote(append([]string{title}, lines...), color), nil
+	case *PageBreak:
+		return nil, nil
+	case *FigureCaption:
+		// The Figure case writes the caption.
+		return nil, nil
+	default:
+		return r.blocks(n, width, false)
+	}
+}
+
+// heading underlines the first two levels like setext headings and marks
+// the others like ATX headings.
+func (r *textRenderer) heading(n *ast.Heading, width int) ([]string, error) {
+	s, err := r.inlines(n)
+	if err != nil {
+		return nil, err
+	}
+	if n.Level > 2 {
+		if !r.opts.ANSI {
+			s = strings.Repeat("#", n.Level) + " " + s
+		}
+		return wrap(r.styled(ansiHeading, s), width), nil
+	}
+
+	lines := wrap(r.styled(ansiHeading, s), width)
+	underline := 0
+	for _, line := range lines {
+		underline = max(underline, textWidth(line))
+	}
+	char := "="
+	if n.Level == 2 {
+		char = "-"
+	}
+	if r.opts.ANSI {
+		char = map[string]string{"=": "═", "-": "─"}[char]
+	}
+	return append(lines, r.styled(ansiHeading, strings.Repeat(char, underline))), nil
+}
+
+// code indents the lines of a code block without wrapping them.
+func (r *textRenderer) code(s string) []string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "    " + r.styled(ansiCode, line)
+	}
+	return lines
+}
+
+// d2 draws a D2 diagram with box characters, or returns a placeholder
+// when disabled or when the diagram cannot be drawn.
+func (r *textRenderer) d2(src string) []string {
+	placeholder := []string{r.styled(ansiDim, "[D2 diagram]")}
+	if r.opts.Diagrams != "ascii" {
+		return placeholder
+	}
+
+	ruler, err := textmeasure.NewRuler()
+	if err != nil {
+		return placeholder
+	}
+	compileOpts := &d2lib.CompileOptions{
+		Ruler: ruler,
+		// Like the d2 CLI, ASCII output always uses the ELK layout, which
+		// routes connections orthogonally.
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2elklayout.DefaultLayout, nil
+		},
+	}
+	ctx := d2log.With(context.Background(), slog.New(slog.DiscardHandler))
+	diagram, _, err := d2lib.Compile(ctx, src, compileOpts, &d2svg.RenderOpts{})
+	if err != nil {
+		return placeholder
+	}
+	out, err := d2ascii.NewASCIIartist().Render(ctx, diagram, &d2ascii.RenderOpts{Charset: charset.Unicode})
+	if err != nil {
+		return placeholder
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return lines
+}
+
+// quote prefixes lines with a bar in the given style, or with "> " in
+// plain text.
+func (r *textRenderer) quote(lines []string, style string) []string {
+	if !r.opts.ANSI {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return lines
+	}
+	bar := r.styled(style, "│")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(bar+" "+line, " ")
+	}
+	return lines
+}
+
+func (r *textRenderer) list(n *ast.List, width int) ([]string, error) {
+	markers := []string{}
+	number := n.Start
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch {
+		case n.IsOrdered():
+			markers = append(markers, strconv.Itoa(number)+string(n.Marker))
+			number++
+		case r.opts.ANSI:
+			markers = append(markers, "•")
+		default:
+			markers = append(markers, "-")
+		}
+	}
+	markerWidth := 0
+	for _, m := range markers {
+		markerWidth = max(markerWidth, runewidth.StringWidth(m)+1)
+	}
+
+	var out []string
+	i := 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		lines, err := r.blocks(c, width-markerWidth, n.IsTight)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > 0 && !n.IsTight {
+			out = append(out, "")
+		}
+		marker := markers[i]
+		if r.opts.ANSI && !n.IsOrdered() {
+			marker = r.styled(ansiDim, marker)
+		}
+		out = append(out, hang(lines, marker, markerWidth)...)
+		i++
+	}
+	return out, nil
+}
+
+// table draws a table with box characters. Cells are not wrapped.
+func (r *textRenderer) table(n *east.Table) ([]string, error) {
+	var rows [][]string
+	var aligns []east.Alignment
+	header := false
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		if row.Kind() == east.KindTableHeader {
+			header = true
+		}
+		var cells []string
+		col := 0
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			s, err := r.inlines(cell)
+			if err != nil {
+				return nil, err
+			}
+			s = strings.ReplaceAll(s, "\n", " ")
+			if row.Kind() == east.KindTableHeader {
+				s = r.styled(ansiBold, s)
+			}
+			cells = append(cells, s)
+			if col >= len(aligns) {
+				aligns = append(aligns, cell.(*east.TableCell).Alignment)
+			}
+			col++
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(aligns))
+	for _, cells := range rows {
+		for i, s := range cells {
+			widths[i] = max(widths[i], textWidth(s))
+		}
+	}
+
+	border := func(left, mid, right string) string {
+		parts := make([]string, len(widths))
+		for i, w := range widths {
+			parts[i] = strings.Repeat("─", w+2)
+		}
+		return left + strings.Join(parts, mid) + right
+	}
+
+	out := []string{border("┌", "┬", "┐")}
+	for i, cells := range rows {
+		var b strings.Builder
+		b.WriteString("│")
+		for col, w := range widths {
+			s := ""
+			if col < len(cells) {
+				s = cells[col]
+			}
+			b.WriteString(" " + pad(s, w, aligns[col]) + " │")
+		}
+		out = append(out, b.String())
+		if i == 0 && header && len(rows) > 1 {
+			out = append(out, border("├", "┼", "┤"))
+		}
+	}
+	return append(out, border("└", "┴", "┘")), nil
+}
+
+// footnotes lists the footnotes after a short rule, numbered like their
+// references.
+func (r *textRenderer) footnotes(n *east.FootnoteList, width int) ([]string, error) {
+	out := []string{r.rule(min(width, 20))}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		fn, ok := c.(*east.Footnote)
+		if !ok {
+			continue
+		}
+		marker := fmt.Sprintf("[%d]", fn.Index)
+		markerWidth := len(marker) + 1
+		lines, err := r.blocks(fn, width-markerWidth, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, hang(lines, r.styled(ansiDim, marker), markerWidth)...)
+	}
+	return out, nil
+}
+
+// rule is a horizontal line across width columns, or a short one when
+// the text is not wrapped.
+func (r *textRenderer) rule(width int) string {
+	if width <= 0 {
+		width = 20
+	}
+	if r.opts.ANSI {
+		return r.styled(ansiDim, strings.Repeat("─", width))
+	}
+	return strings.Repeat("-", width)
+}
+
+// inlines renders the inline children of n as a single string, with
+// hard line breaks as newlines.
+func (r *textRenderer) inlines(n ast.Node) (string, error) {
+	iw := &inlineWriter{ansi: r.opts.ANSI}
+	if err := r.inlineChildren(iw, n); err != nil {
+		return "", err
+	}
+	return iw.b.String(), nil
+}
+
+func (r *textRenderer) inlineChildren(iw *inlineWriter, n ast.Node) error {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.inline(iw, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *textRenderer) inline(iw *inlineWriter, node ast.Node) error {
+	children := func() error {
+		return r.inlineChildren(iw, node)
+	}
+	switch n := node.(type) {
+	case *ast.Text:
+		iw.b.Write(n.Segment.Value(r.source))
+		if n.HardLineBreak() {
+			iw.b.WriteString("\n")
+		} else if n.SoftLineBreak() {
+			iw.b.WriteString(" ")
+		}
+	case *ast.String:
+		iw.b.WriteString(StringText(n))
+	case *ast.CodeSpan:
+		return iw.styled(ansiCode, children)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			return iw.styled(ansiBold, children)
+		}
+		return iw.styled(ansiItalic, children)
+	case *east.Strikethrough:
+		if !r.opts.ANSI {
+			iw.b.WriteString("~~")
+			err := children()
+			iw.b.WriteString("~~")
+			return err
+		}
+		return iw.styled(ansiStrike, children)
+	case *ast.Link:
+		start := iw.b.Len()
+		if err := iw.styled(ansiLink, children); err != nil {
+			return err
+		}
+		dest := string(n.Destination)
+		label := ansiSequence.ReplaceAllString(iw.b.String()[start:], "")
+		if dest != "" && !strings.HasPrefix(dest, "#") && dest != label {
+			iw.b.WriteString(" ")
+			iw.text(ansiDim, "("+dest+")")
+		}
+	case *ast.AutoLink:
+		iw.text(ansiLink, string(n.Label(r.source)))
+	case *ast.Image:
+		alt := strings.TrimSpace(NodeText(n, r.source))
+		if alt == "" {
+			iw.text(ansiDim, "[Image]")
+		} else {
+			iw.text(ansiDim, "[Image: "+alt+"]")
+		}
+	case *ast.RawHTML:
+		var raw strings.Builder
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			raw.Write(seg.Value(r.source))
+		}
+		if lineBreakTag.MatchString(strings.TrimSpace(raw.String())) {
+			iw.b.WriteString("\n")
+		}
+	case *east.TaskCheckBox:
+		switch {
+		case !r.opts.ANSI && n.IsChecked:
+			iw.b.WriteString("[x] ")
+		case !r.opts.ANSI:
+			iw.b.WriteString("[ ] ")
+		case n.IsChecked:
+			iw.text(ansiCheckMark, "☒")
+			iw.b.WriteString(" ")
+		default:
+			iw.b.WriteString("☐ ")
+		}
+	case *east.FootnoteLink:
+		iw.text(ansiDim, fmt.Sprintf("[%d]", n.Index))
+	case *east.FootnoteBacklink:
+	case *HeadingNumber:
+		iw.b.WriteString(n.Number + " ")
+	case *CrossrefLink:
+		iw.text(ansiLink, n.DisplayText())
+	case *Equation:
+		if err := children(); err != nil {
+			return err
+		}
+		iw.b.WriteString(" (" + n.Number + ")")
+	case *katex.Block:
+		iw.text(ansiItalic, strings.TrimSpace(string(n.Equation)))
+	case *katex.Inline:
+		iw.text(ansiItalic, string(n.Equation))
+	default:
+		return children()
+	}
+	return nil
+}
+
+// styled wraps s in the given style in ANSI output.
+func (r *textRenderer) styled(style, s string) string {
+	if !r.opts.ANSI || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+// inlineWriter collects inline text. Styles nest: closing one restores
+// the styles of the enclosing nodes.
+type inlineWriter struct {
+	b     strings.Builder
+	ansi  bool
+	stack []string
+}
+
+func (iw *inlineWriter) styled(style string, content func() error) error {
+	if !iw.ansi {
+		return content()
+	}
+	iw.stack = append(iw.stack, style)
+	iw.b.WriteString("\x1b[" + style + "m")
+	err := content()
+	iw.stack = iw.stack[:len(iw.stack)-1]
+	iw.b.WriteString("\x1b[0m")
+	for _, s := range iw.stack {
+		iw.b.WriteString("\x1b[" + s + "m")
+	}
+	return err
+}
+
+func (iw *inlineWriter) text(style, s string) {
+	_ = iw.styled(style, func() error {
+		iw.b.WriteString(s)
+		return nil
+	})
+}
+
+// wrap breaks s into lines of at most width columns at spaces, and at the
+// newlines of hard line breaks. Words longer than width get a line of
+// their own. Each line resets the styles still open at its end and
+// restores them at the start of the next, so that the prefixes enclosing
+// blocks add stay unstyled.
+func wrap(s string, width int) []string {
+	var lines []string
+	for _, hard := range strings.Split(s, "\n") {
+		hard = strings.Trim(hard, " ")
+		if width <= 0 {
+			lines = append(lines, hard)
+			continue
+		}
+		var cur strings.Builder
+		curWidth := 0
+		for i, word := range strings.Split(hard, " ") {
+			w := textWidth(word)
+			if i > 0 {
+				if curWidth > 0 && curWidth+1+w > width {
+					lines = append(lines, cur.String())
+					cur.Reset()
+					curWidth = 0
+				} else {
+					cur.WriteString(" ")
+					curWidth++
+				}
+			}
+			cur.WriteString(word)
+			curWidth += w
+		}
+		lines = append(lines, cur.String())
+	}
+
+	var active []string
+	for i, line := range lines {
+		restore := strings.Join(active, "")
+		for _, seq := range ansiSequence.FindAllString(line, -1) {
+			if seq == "\x1b[0m" {
+				active = nil
+			} else {
+				active = append(active, seq)
+			}
+		}
+		if len(active) > 0 {
+			line += "\x1b[0m"
+		}
+		lines[i] = restore + line
+	}
+	return lines
+}
+
+// textWidth is the number of columns s takes in a terminal.
+func textWidth(s string) int {
+	return runewidth.StringWidth(ansiSequence.ReplaceAllString(s, ""))
+}
+
+// pad fills s with spaces to width columns.
+func pad(s string, width int, align east.Alignment) string {
+	fill := width - textWidth(s)
+	if fill <= 0 {
+		return s
+	}
+	switch align {
+	case east.AlignRight:
+		return strings.Repeat(" ", fill) + s
+	case east.AlignCenter:
+		return strings.Repeat(" ", fill/2) + s + strings.Repeat(" ", fill-fill/2)
+	default:
+		return s + strings.Repeat(" ", fill)
+	}
+}
+
+// hang puts marker before the first line and indents the others to line
+// up with it.
+func hang(lines []string, marker string, width int) []string {
+	if len(lines) == 0 {
+		return []string{marker}
+	}
+	out := make([]string, len(lines))
+	indent := strings.Repeat(" ", width)
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			out[i] = marker + strings.Repeat(" ", max(width-textWidth(marker), 1)) + line
+		case line == "":
+			out[i] = ""
+		default:
+			out[i] = indent + line
+		}
+	}
+	return out
+}
+
+// indentLines prefixes the non-empty lines with prefix.
+func indentLines(lines []string, prefix string) []string {
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return lines
+}
+
+// IsDisplayMath reports whether a paragraph holds only display math.
+func IsDisplayMath(n ast.Node) bool {
+	found := false
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c.(type) {
+		case *katex.Block, *Equation:
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var watermarkColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%\s]+\))$`)
+
+// Watermark is drawn across every page. It is a fixed overlay, which
+// browsers repeat on every printed page.
+type Watermark struct {
+	// Enabled draws the watermark on every document.
+	Enabled bool
+	// Statuses are front matter status values that enable the watermark,
+	// e.g. "draft".
+	Statuses []string
+	// Text defaults to the document status in upper case.
+	Text string
+	// Image is a URL, typically a data URI, drawn instead of the text.
+	Image   string
+	Opacity float64
+	Angle   float64
+	Color   string
+}
+
+// Validate checks the values that end up in inline styles.
+func (w Watermark) Validate() error {
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return fmt.Errorf("opacity %g out of range 0 to 1", w.Opacity)
+	}
+	if w.Color != "" && !watermarkColor.MatchString(w.Color) {
+		return fmt.Errorf("invalid color %q", w.Color)
+	}
+	return nil
+}
+
+// render returns the watermark markup for a document with the given front
+// matter status, or "" when the watermark does not apply.
+func (w Watermark) render(status string, xhtml bool) string {
+	active := w.Enabled
+	for _, s := range w.Statuses {
+		if status != "" && strings.EqualFold(s, status) {
+			active = true
+		}
+	}
+	if !active {
+		return ""
+	}
+
+	style := "opacity: " + strconv.FormatFloat(w.Opacity, 'f', -1, 64) + ";"
+	rotate := "transform: rotate(" + strconv.FormatFloat(w.Angle, 'f', -1, 64) + "deg);"
+
+	var content string
+	if w.Image != "" {
+		end := ">"
+		if xhtml {
+			end = " />"
+		}
+		content = `<img src="` + html.EscapeString(w.Image) + `" alt="" style="` + rotate + `"` + end
+	} else {
+		text := w.Text
+		if text == "" {
+			text = strings.ToUpper(status)
+		}
+		if text == "" {
+			text = "DRAFT"
+		}
+		if w.Color != "" {
+			rotate += " color: " + w.Color + ";"
+		}
+		content = `<span style="` + rotate + `">` + html.EscapeString(text) + `</span>`
+	}
+	return `<div class="watermark" aria-hidden="true" style="` + style + `">` + content + "</div>\n"
+}
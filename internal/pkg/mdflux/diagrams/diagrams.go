@@ -0,0 +1,136 @@
+package diagrams
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/mermaid"
+
+	d2 "github.com/FurqanSoftware/goldmark-d2"
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/yuin/goldmark/ast"
+)
+
+// Kind names the renderer a diagram comes from.
+type Kind string
+
+const (
+	KindMermaid Kind = "mermaid"
+	KindD2      Kind = "d2"
+	KindMath    Kind = "math"
+)
+
+const (
+	NamesNumber = "number"
+	NamesID     = "id"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Diagram is a rendered diagram or display equation of a document.
+type Diagram struct {
+	Kind Kind
+	// Index is the 1-based position of the diagram in the document.
+	Index int
+	// ID is the cross-reference label of the enclosing figure or
+	// equation, e.g. fig:flow, or empty.
+	ID string
+	// Markup is SVG for diagrams and KaTeX HTML for math.
+	Markup []byte
+	// Node is the diagram or math block in the document.
+	Node ast.Node
+}
+
+// ParseNames validates a file naming scheme.
+func ParseNames(names string) (string, error) {
+	switch names {
+	case NamesNumber, NamesID:
+		return names, nil
+	default:
+		return "", fmt.Errorf("unknown naming scheme %q, expected %q or %q", names, NamesNumber, NamesID)
+	}
+}
+
+// Collect renders the Mermaid, D2 and display math blocks of doc with the
+// renderers configured in conv, in document order.
+func Collect(conv *converter.Converter, doc *converter.Document) ([]Diagram, error) {
+	var diagrams []Diagram
+	err := ast.Walk(doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		var kind Kind
+		switch n.Kind() {
+		case mermaid.KindMermaidBlock:
+			kind = KindMermaid
+		case d2.KindBlock:
+			kind = KindD2
+		case katex.KindBlock:
+			kind = KindMath
+		default:
+			return ast.WalkContinue, nil
+		}
+
+		d := Diagram{Kind: kind, Index: len(diagrams) + 1, ID: label(n), Node: n}
+		var buf bytes.Buffer
+		if err := conv.RenderNode(&buf, doc, n); err != nil {
+			return ast.WalkStop, fmt.Errorf("diagram %d: %w", d.Index, err)
+		}
+		markup, err := unwrap(kind, buf.Bytes())
+		if err != nil {
+			return ast.WalkStop, fmt.Errorf("diagram %d: %w", d.Index, err)
+		}
+		d.Markup = markup
+		diagrams = append(diagrams, d)
+		return ast.WalkSkipChildren, nil
+	})
+	return diagrams, err
+}
+
+// label returns the cross-reference label of the figure or equation that
+// holds n.
+func label(n ast.Node) string {
+	switch p := n.Parent().(type) {
+	case *converter.Figure:
+		return p.Label
+	case *converter.Equation:
+		return p.Label
+	}
+	return ""
+}
+
+// unwrap strips the HTML the renderers place around a diagram. Diagrams
+// that failed to render are written as source code and have no SVG.
+func unwrap(kind Kind, html []byte) ([]byte, error) {
+	if kind == KindMath {
+		html = bytes.TrimSpace(html)
+		html = bytes.TrimPrefix(html, []byte("<div>"))
+		return bytes.TrimSuffix(html, []byte("</div>")), nil
+	}
+
+	start := bytes.Index(html, []byte("<svg"))
+	end := bytes.LastIndex(html, []byte("</svg>"))
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("failed to render %s diagram", kind)
+	}
+	return html[start : end+len("</svg>")], nil
+}
+
+// FileName returns the file name of d without extension. Numbered names
+// are zero-padded to the width of count, e.g. 03-mermaid. With NamesID,
+// labelled diagrams are named after their label, e.g. fig-flow, and the
+// others are numbered.
+func FileName(d Diagram, names string, count int) string {
+	if names == NamesID {
+		name := strings.Trim(unsafeNameChars.ReplaceAllString(strings.ReplaceAll(d.ID, ":", "-"), "-"), "-.")
+		if name != "" {
+			return name
+		}
+	}
+	width := max(2, len(fmt.Sprint(count)))
+	return fmt.Sprintf("%0*d-%s", width, d.Index, d.Kind)
+}
@@ -0,0 +1,828 @@
+package docx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	d2 "github.com/FurqanSoftware/goldmark-d2"
+	"github.com/FurqanSoftware/goldmark-katex"
+	"github.com/rs/zerolog/log"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+
+	"mdflux/internal/pkg/mdflux/converter"
+	"mdflux/internal/pkg/mdflux/mermaid"
+)
+
+const (
+	emuPerPixel = 9525
+	emuPerTwip  = 635
+	// listIndent is the indentation of each list level in twips.
+	listIndent = 720
+	// maxBookmarkName is the longest bookmark name Word accepts.
+	maxBookmarkName = 40
+	// svgBlipExt is the extension URI of SVG pictures with a raster
+	// fallback.
+	svgBlipExt = "{96DAC541-7B7A-43D3-8B79-37D633B846F1}"
+)
+
+var (
+	unsafeBookmarkChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+	lineBreakTag        = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
+	contentTypes = map[string]string{
+		"png":  "image/png",
+		"jpeg": "image/jpeg",
+		"gif":  "image/gif",
+		"svg":  "image/svg+xml",
+	}
+
+	// placeholderPNG is the raster fallback of SVG pictures for Word
+	// versions that cannot display SVG, a transparent pixel.
+	placeholderPNG = func() []byte {
+		var buf bytes.Buffer
+		_ = png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+		return buf.Bytes()
+	}()
+)
+
+// paraProps are the properties of a paragraph.
+type paraProps struct {
+	style    string
+	keepNext bool
+	numID    int
+	ilvl     int
+	// indent is the left indentation in twips.
+	indent int
+	align  string
+}
+
+// runProps are the properties of a run of text.
+type runProps struct {
+	style  string
+	bold   bool
+	italic bool
+	strike bool
+}
+
+// blockContext is the formatting blocks inherit from their container.
+type blockContext struct {
+	// style is the paragraph style, e.g. Quote inside block quotes.
+	style  string
+	indent int
+	// item numbers the first paragraph of a list item.
+	item *list
+	// depth is the list nesting depth.
+	depth int
+}
+
+func (c blockContext) paraProps() paraProps {
+	pp := paraProps{style: c.style, indent: c.indent}
+	if c.item != nil {
+		pp.numID = c.item.ID
+		pp.ilvl = c.item.Level
+		pp.indent = 0
+	}
+	return pp
+}
+
+// writer converts parsed documents into the body and footnotes of a Word
+// document.
+type writer struct {
+	opts     Options
+	maxWidth int64
+
+	body     bytes.Buffer
+	notes    bytes.Buffer
+	docRels  relationships
+	noteRels relationships
+	// out and rels are those of the part being written, the body or the
+	// footnotes.
+	out  *bytes.Buffer
+	rels *relationships
+	// pending is written at the start of the next paragraph, e.g.
+	// bookmarks and footnote marks.
+	pending bytes.Buffer
+	// lastRun is the end offset in out and the properties of the last
+	// text run, which following text with the same properties joins.
+	lastRun      int
+	lastRunProps string
+	lastRunOut   *bytes.Buffer
+
+	media      map[string][]byte
+	mediaNames []string
+	lists      []list
+	bookmarks  map[string]string
+	used       map[string]bool
+	bookmarkID int
+	drawingID  int
+	noteID     int
+
+	// source, dir and footnotes belong to the document being written.
+	source    []byte
+	dir       string
+	footnotes map[int]*east.Footnote
+}
+
+func newWriter(opts Options, textWidth int) *writer {
+	w := &writer{
+		opts:      opts,
+		maxWidth:  int64(textWidth) * emuPerTwip,
+		media:     map[string][]byte{},
+		bookmarks: map[string]string{},
+		used:      map[string]bool{},
+	}
+	w.out = &w.body
+	w.rels = &w.docRels
+	return w
+}
+
+func (w *writer) chapter(ch Chapter) error {
+	w.source = ch.Doc.Source
+	w.dir = w.opts.BaseDir
+	if ch.Doc.Path != "" {
+		w.dir = filepath.Dir(ch.Doc.Path)
+	}
+	w.footnotes = map[int]*east.Footnote{}
+	_ = ast.Walk(ch.Doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fn, ok := n.(*east.Footnote); ok && entering {
+			w.footnotes[fn.Index] = fn
+		}
+		return ast.WalkContinue, nil
+	})
+
+	if ch.PageBreak && w.body.Len() > 0 {
+		w.pageBreak()
+	}
+	if ch.Anchor != "" {
+		w.bookmark(ch.Anchor)
+	}
+	return w.blocks(ch.Doc.Root, blockContext{})
+}
+
+// tableOfContents writes a TOC field, which Word fills in when the
+// document is opened.
+func (w *writer) tableOfContents(depth int) {
+	w.out.WriteString(`<w:p><w:pPr><w:pStyle w:val="TOCHeading"/></w:pPr><w:r><w:t>Contents</w:t></w:r></w:p>`)
+	fmt.Fprintf(w.out, `<w:p><w:r><w:fldChar w:fldCharType="begin" w:dirty="true"/></w:r><w:r><w:instrText xml:space="preserve"> TOC \o "1-%d" \h \z \u </w:instrText></w:r><w:r><w:fldChar w:fldCharType="separate"/></w:r>`, min(depth, 9))
+	w.out.WriteString(`<w:r><w:t>Update the table of contents to fill it in.</w:t></w:r><w:r><w:fldChar w:fldCharType="end"/></w:r></w:p>`)
+	w.pageBreak()
+}
+
+func (w *writer) blocks(parent ast.Node, ctx blockContext) error {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		if err := w.block(n, ctx); err != nil {
+			return err
+		}
+		// Only the first paragraph of a list item is numbered.
+		ctx.item = nil
+	}
+	return nil
+}
+
+func (w *writer) block(node ast.Node, ctx blockContext) error {
+	switch n := node.(type) {
+	case *ast.Heading:
+		if id, ok := n.AttributeString("id"); ok {
+			w.bookmark(converter.AttributeString(id))
+		}
+		return w.paragraph(paraProps{style: fmt.Sprintf("Heading%d", n.Level)}, func() error {
+			return w.inlines(n, runProps{})
+		})
+	case *ast.Paragraph, *ast.TextBlock:
+		pp := ctx.paraProps()
+		if converter.IsDisplayMath(n) {
+			pp.style = "Equation"
+		}
+		return w.paragraph(pp, func() error {
+			return w.inlines(n, runProps{})
+		})
+	case *ast.ThematicBreak:
+		return w.paragraph(paraProps{style: "HorizontalRule"}, nil)
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		return w.code(n.Lines().Value(w.source), ctx)
+	case *mermaid.CodeBlock:
+		if img, ok := w.opts.Diagrams[n]; ok {
+			return w.figure(img, n, ctx)
+		}
+		return w.code(n.Code, ctx)
+	case *d2.Block:
+		if img, ok := w.opts.Diagrams[n]; ok {
+			return w.figure(img, n, ctx)
+		}
+		return w.code(n.Lines().Value(w.source), ctx)
+	case *ast.Blockquote:
+		ctx.style = "Quote"
+		return w.blocks(n, ctx)
+	case *ast.List:
+		return w.list(n, ctx)
+	case *ast.HTMLBlock:
+		log.Debug().Msg("Raw HTML is left out of DOCX output")
+		return nil
+	case *east.Table:
+		return w.table(n)
+	case *east.DefinitionTerm:
+		return w.paragraph(paraProps{style: "DefinitionTerm"}, func() error {
+			return w.inlines(n, runProps{})
+		})
+	case *east.DefinitionDescription:
+		ctx.style = "Definition"
+		return w.blocks(n, ctx)
+	case *east.FootnoteList:
+		// Footnotes are written where they are referenced.
+		return nil
+	case *converter.Figure:
+		if n.Prefix == "tbl" {
+			if err := w.caption(n, true); err != nil {
+				return err
+			}
+		}
+		ctx.style = "Figure"
+		if err := w.blocks(n, ctx); err != nil {
+			return err
+		}
+		if n.Prefix != "tbl" {
+			return w.caption(n, false)
+		}
+		return nil
+	case *converter.Admonition:
+		if err := w.paragraph(paraProps{style: "AdmonitionTitle"}, func() error {
+			w.text(runProps{}, n.Title)
+			return nil
+		}); err != nil {
+			return err
+		}
+		ctx.style = "Admonition"
+		return w.blocks(n, ctx)
+	case *converter.PageBreak:
+		w.pageBreak()
+		return nil
+	case *converter.FigureCaption:
+		// The Figure case writes the caption.
+		return nil
+	default:
+		return w.blocks(n, ctx)
+	}
+}
+
+// paragraph writes a paragraph whose runs are written by content, which
+// may be nil for an empty paragraph.
+func (w *writer) paragraph(pp paraProps, content func() error) error {
+	w.out.WriteString("<w:p>")
+	var props strings.Builder
+	if pp.style != "" {
+		fmt.Fprintf(&props, `<w:pStyle w:val="%s"/>`, pp.style)
+	}
+	if pp.keepNext {
+		props.WriteString("<w:keepNext/>")
+	}
+	if pp.numID > 0 {
+		fmt.Fprintf(&props, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, pp.ilvl, pp.numID)
+	}
+	if pp.indent > 0 {
+		fmt.Fprintf(&props, `<w:ind w:left="%d"/>`, pp.indent)
+	}
+	if pp.align != "" {
+		fmt.Fprintf(&props, `<w:jc w:val="%s"/>`, pp.align)
+	}
+	if props.Len() > 0 {
+		w.out.WriteString("<w:pPr>" + props.String() + "</w:pPr>")
+	}
+	w.flushPending()
+
+	var err error
+	if content != nil {
+		err = content()
+	}
+	w.out.WriteString("</w:p>")
+	return err
+}
+
+func (w *writer) flushPending() {
+	w.out.Write(w.pending.Bytes())
+	w.pending.Reset()
+}
+
+func (w *writer) pageBreak() {
+	w.out.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+}
+
+// bookmark marks the start of the next paragraph as the target of links
+// to id.
+func (w *writer) bookmark(id string) {
+	w.bookmarkID++
+	fmt.Fprintf(&w.pending, `<w:bookmarkStart w:id="%d" w:name="%s"/><w:bookmarkEnd w:id="%d"/>`, w.bookmarkID, w.bookmarkName(id), w.bookmarkID)
+}
+
+// bookmarkName maps an HTML ID onto a bookmark name, which Word limits to
+// 40 letters, digits and underscores starting with a letter. Names
+// starting with an underscore are hidden bookmarks.
+func (w *writer) bookmarkName(id string) string {
+	if name, ok := w.bookmarks[id]; ok {
+		return name
+	}
+	base := unsafeBookmarkChars.ReplaceAllString(id, "_")
+	if base == "" || !isLetter(base[0]) {
+		base = "_" + base
+	}
+	if len(base) > maxBookmarkName {
+		base = base[:maxBookmarkName]
+	}
+	name := base
+	for i := 2; w.used[name]; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		name = base[:min(len(base), maxBookmarkName-len(suffix))] + suffix
+	}
+	w.used[name] = true
+	w.bookmarks[id] = name
+	return name
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// code writes a code block as one paragraph with a line break per line.
+func (w *writer) code(source []byte, ctx blockContext) error {
+	pp := ctx.paraProps()
+	pp.style = "SourceCode"
+	lines := strings.Split(strings.TrimRight(string(source), "\r\n"), "\n")
+	return w.paragraph(pp, func() error {
+		for i, line := range lines {
+			if i > 0 {
+				w.out.WriteString("<w:r><w:br/></w:r>")
+			}
+			w.text(runProps{style: "VerbatimChar"}, strings.TrimRight(line, "\r"))
+		}
+		return nil
+	})
+}
+
+// figure writes a diagram as a centered picture.
+func (w *writer) figure(img Image, n ast.Node, ctx blockContext) error {
+	alt := ""
+	if fig, ok := n.Parent().(*converter.Figure); ok {
+		alt = fig.Caption
+	}
+	return w.paragraph(paraProps{style: "Figure", indent: ctx.indent}, func() error {
+		return w.drawing(img, alt)
+	})
+}
+
+// caption writes the numbered caption of a figure or table. Table captions
+// precede the table and are kept with it.
+func (w *writer) caption(fig *converter.Figure, keepNext bool) error {
+	w.bookmark(fig.Label)
+	return w.paragraph(paraProps{style: "Caption", keepNext: keepNext}, func() error {
+		w.text(runProps{}, fig.CaptionText())
+		return nil
+	})
+}
+
+func (w *writer) list(n *ast.List, ctx blockContext) error {
+	l := list{ID: len(w.lists) + 1, Ordered: n.IsOrdered(), Level: min(ctx.depth, listLevels-1), Start: n.Start}
+	if l.Start == 0 {
+		l.Start = 1
+	}
+	w.lists = append(w.lists, l)
+
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		itemCtx := ctx
+		itemCtx.item = &l
+		itemCtx.depth = ctx.depth + 1
+		itemCtx.indent = listIndent * (l.Level + 1)
+		if !item.HasChildren() {
+			if err := w.paragraph(itemCtx.paraProps(), nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.blocks(item, itemCtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writer) table(n *east.Table) error {
+	cols := len(n.Alignments)
+	if cols == 0 {
+		if row := n.FirstChild(); row != nil {
+			cols = row.ChildCount()
+		}
+	}
+	colWidth := int(w.maxWidth/emuPerTwip) / max(cols, 1)
+
+	w.out.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="Table"/><w:tblW w:w="5000" w:type="pct"/><w:tblLook w:val="04A0" w:firstRow="1" w:lastRow="0" w:firstColumn="0" w:lastColumn="0" w:noHBand="0" w:noVBand="1"/></w:tblPr><w:tblGrid>`)
+	for range cols {
+		fmt.Fprintf(w.out, `<w:gridCol w:w="%d"/>`, colWidth)
+	}
+	w.out.WriteString("</w:tblGrid>")
+
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		header := row.Kind() == east.KindTableHeader
+		w.out.WriteString("<w:tr>")
+		if header {
+			w.out.WriteString("<w:trPr><w:tblHeader/></w:trPr>")
+		}
+		for c := row.FirstChild(); c != nil; c = c.NextSibling() {
+			cell := c.(*east.TableCell)
+			fmt.Fprintf(w.out, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr>`, colWidth)
+			pp := paraProps{}
+			switch cell.Alignment {
+			case east.AlignLeft:
+				pp.align = "left"
+			case east.AlignCenter:
+				pp.align = "center"
+			case east.AlignRight:
+				pp.align = "right"
+			}
+			if err := w.paragraph(pp, func() error {
+				return w.inlines(cell, runProps{bold: header})
+			}); err != nil {
+				return err
+			}
+			w.out.WriteString("</w:tc>")
+		}
+		w.out.WriteString("</w:tr>")
+	}
+	w.out.WriteString("</w:tbl>")
+	return nil
+}
+
+func (w *writer) inlines(parent ast.Node, rp runProps) error {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		if err := w.inline(n, rp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writer) inline(node ast.Node, rp runProps) error {
+	switch n := node.(type) {
+	case *ast.Text:
+		w.text(rp, string(n.Segment.Value(w.source)))
+		if n.HardLineBreak() {
+			w.out.WriteString("<w:r><w:br/></w:r>")
+		} else if n.SoftLineBreak() {
+			w.text(rp, " ")
+		}
+	case *ast.String:
+		w.text(rp, converter.StringText(n))
+	case *ast.CodeSpan:
+		rp.style = "VerbatimChar"
+		return w.inlines(n, rp)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			rp.bold = true
+		} else {
+			rp.italic = true
+		}
+		return w.inlines(n, rp)
+	case *east.Strikethrough:
+		rp.strike = true
+		return w.inlines(n, rp)
+	case *ast.Link:
+		rp.style = "Hyperlink"
+		return w.link(string(n.Destination), func() error {
+			return w.inlines(n, rp)
+		})
+	case *ast.AutoLink:
+		dest := string(n.URL(w.source))
+		if n.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(dest), "mailto:") {
+			dest = "mailto:" + dest
+		}
+		rp.style = "Hyperlink"
+		return w.link(dest, func() error {
+			w.text(rp, string(n.Label(w.source)))
+			return nil
+		})
+	case *ast.Image:
+		return w.image(n, rp)
+	case *ast.RawHTML:
+		var raw bytes.Buffer
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			raw.Write(seg.Value(w.source))
+		}
+		if lineBreakTag.Match(bytes.TrimSpace(raw.Bytes())) {
+			w.out.WriteString("<w:r><w:br/></w:r>")
+		}
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			w.text(rp, "☒ ")
+		} else {
+			w.text(rp, "☐ ")
+		}
+	case *east.FootnoteLink:
+		return w.footnote(n.Index)
+	case *east.FootnoteBacklink:
+	case *converter.HeadingNumber:
+		w.text(rp, n.Number+" ")
+	case *converter.CrossrefLink:
+		rp.style = "Hyperlink"
+		return w.link("#"+n.Label, func() error {
+			w.text(rp, n.DisplayText())
+			return nil
+		})
+	case *converter.Equation:
+		w.bookmark(n.Label)
+		w.flushPending()
+		if err := w.inlines(n, rp); err != nil {
+			return err
+		}
+		w.text(runProps{}, " ("+n.Number+")")
+	case *katex.Block:
+		if img, ok := w.opts.Diagrams[n]; ok {
+			return w.drawing(img, string(n.Equation))
+		}
+		rp.italic = true
+		w.text(rp, strings.TrimSpace(string(n.Equation)))
+	case *katex.Inline:
+		rp.italic = true
+		w.text(rp, string(n.Equation))
+	default:
+		return w.inlines(n, rp)
+	}
+	return nil
+}
+
+// text writes s as a run.
+func (w *writer) text(rp runProps, s string) {
+	if s == "" {
+		return
+	}
+	var props strings.Builder
+	if rp.style != "" {
+		fmt.Fprintf(&props, `<w:rStyle w:val="%s"/>`, rp.style)
+	}
+	if rp.bold {
+		props.WriteString("<w:b/><w:bCs/>")
+	}
+	if rp.italic {
+		props.WriteString("<w:i/><w:iCs/>")
+	}
+	if rp.strike {
+		props.WriteString("<w:strike/>")
+	}
+	if w.lastRunOut == w.out && w.lastRun == w.out.Len() && w.lastRunProps == props.String() {
+		w.out.Truncate(w.out.Len() - len("</w:r>"))
+	} else {
+		w.out.WriteString("<w:r>")
+		if props.Len() > 0 {
+			w.out.WriteString("<w:rPr>" + props.String() + "</w:rPr>")
+		}
+	}
+	for i, part := range strings.Split(s, "\t") {
+		if i > 0 {
+			w.out.WriteString("<w:tab/>")
+		}
+		if part != "" {
+			w.out.WriteString(`<w:t xml:space="preserve">`)
+			_ = xml.EscapeText(w.out, []byte(part))
+			w.out.WriteString("</w:t>")
+		}
+	}
+	w.out.WriteString("</w:r>")
+	w.lastRun, w.lastRunProps, w.lastRunOut = w.out.Len(), props.String(), w.out
+}
+
+// link writes the runs written by content as a hyperlink. Fragment links
+// point at the bookmark of their target.
+func (w *writer) link(dest string, content func() error) error {
+	switch {
+	case dest == "":
+		return content()
+	case strings.HasPrefix(dest, "#"):
+		fmt.Fprintf(w.out, `<w:hyperlink w:anchor="%s" w:history="1">`, w.bookmarkName(dest[1:]))
+	default:
+		fmt.Fprintf(w.out, `<w:hyperlink r:id="%s" w:history="1">`, w.rels.add(relHyperlink, dest, true))
+	}
+	err := content()
+	w.out.WriteString("</w:hyperlink>")
+	return err
+}
+
+// footnote writes the footnote with the given index to the footnotes part
+// and a reference to it. Every reference gets a footnote of its own.
+func (w *writer) footnote(index int) error {
+	fn, ok := w.footnotes[index]
+	if !ok {
+		return nil
+	}
+	w.noteID++
+	id := w.noteID
+
+	out, rels := w.out, w.rels
+	w.out, w.rels = &w.notes, &w.noteRels
+	fmt.Fprintf(w.out, `<w:footnote w:id="%d">`, id)
+	w.pending.WriteString(`<w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteRef/></w:r><w:r><w:t xml:space="preserve"> </w:t></w:r>`)
+	err := w.blocks(fn, blockContext{style: "FootnoteText"})
+	if err == nil && w.pending.Len() > 0 {
+		err = w.paragraph(paraProps{style: "FootnoteText"}, nil)
+	}
+	w.out.WriteString("</w:footnote>")
+	w.out, w.rels = out, rels
+
+	fmt.Fprintf(w.out, `<w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteReference w:id="%d"/></w:r>`, id)
+	return err
+}
+
+// image embeds a local image. Remote images and formats Word cannot show
+// are written as their alt text.
+func (w *writer) image(n *ast.Image, rp runProps) error {
+	dest := string(n.Destination)
+	alt := converter.NodeText(n, w.source)
+	fallback := func() {
+		if alt == "" {
+			alt = dest
+		}
+		w.text(rp, alt)
+	}
+
+	var data []byte
+	if strings.HasPrefix(dest, "data:") {
+		meta, payload, ok := strings.Cut(dest, ",")
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		if !ok || !strings.HasSuffix(meta, ";base64") || err != nil {
+			log.Warn().Str("src", meta).Msg("Data URI image is not base64 encoded, leaving it out of DOCX output")
+			fallback()
+			return nil
+		}
+		data = decoded
+	} else {
+		u, err := url.Parse(dest)
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			log.Warn().Str("src", dest).Msg("Remote images are not embedded in DOCX output")
+			fallback()
+			return nil
+		}
+		file := filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(w.dir, file)
+		}
+		if data, err = os.ReadFile(file); err != nil {
+			return fmt.Errorf("failed to embed image: %w", err)
+		}
+	}
+
+	if imageKind(data) == "" {
+		log.Warn().Str("src", dest).Msg("Image format is not supported in DOCX output, use PNG, JPEG, GIF or SVG")
+		fallback()
+		return nil
+	}
+	return w.drawing(Image{Data: data}, alt)
+}
+
+// drawing writes an inline picture. SVG pictures carry a transparent
+// raster fallback, as Word requires one.
+func (w *writer) drawing(img Image, alt string) error {
+	kind := imageKind(img.Data)
+	if kind == "" {
+		return fmt.Errorf("unsupported image format %s", http.DetectContentType(img.Data))
+	}
+	cx, cy := w.extent(img, kind)
+
+	rid := w.rels.add(relImage, w.addMedia(kind, img.Data), false)
+	blip := fmt.Sprintf(`<a:blip r:embed="%s"/>`, rid)
+	if kind == "svg" {
+		fallback := w.rels.add(relImage, w.addMedia("png", placeholderPNG), false)
+		blip = fmt.Sprintf(`<a:blip r:embed="%s"><a:extLst><a:ext uri="%s"><asvg:svgBlip xmlns:asvg="http://schemas.microsoft.com/office/drawing/2016/SVG/main" r:embed="%s"/></a:ext></a:extLst></a:blip>`, fallback, svgBlipExt, rid)
+	}
+
+	w.drawingID++
+	var descr bytes.Buffer
+	_ = xml.EscapeText(&descr, []byte(alt))
+	fmt.Fprintf(w.out, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="Picture %d" descr="%s"/><wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect="1"/></wp:cNvGraphicFramePr>`, cx, cy, w.drawingID, w.drawingID, descr.String())
+	fmt.Fprintf(w.out, `<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic><pic:nvPicPr><pic:cNvPr id="%d" name="Picture %d"/><pic:cNvPicPr/></pic:nvPicPr><pic:blipFill>%s<a:stretch><a:fillRect/></a:stretch></pic:blipFill>`, w.drawingID, w.drawingID, blip)
+	fmt.Fprintf(w.out, `<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr></pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`, cx, cy)
+	return nil
+}
+
+// addMedia stores data in a media part named after its hash and returns
+// the part name relative to the document.
+func (w *writer) addMedia(kind string, data []byte) string {
+	sum := sha256.Sum256(data)
+	name := "media/image-" + hex.EncodeToString(sum[:8]) + "." + kind
+	if _, ok := w.media[name]; !ok {
+		w.media[name] = data
+		w.mediaNames = append(w.mediaNames, name)
+	}
+	return name
+}
+
+// extent returns the size of a picture in EMU at 96 DPI, scaled down to
+// the text width.
+func (w *writer) extent(img Image, kind string) (int64, int64) {
+	var width, height float64
+	if kind == "svg" {
+		width, height = svgSize(img.Data)
+	} else if cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err == nil {
+		width, height = float64(cfg.Width), float64(cfg.Height)
+	}
+	if width <= 0 || height <= 0 {
+		width, height = 400, 300
+	}
+	if img.Scale > 0 {
+		width /= img.Scale
+		height /= img.Scale
+	}
+
+	cx, cy := int64(width*emuPerPixel), int64(height*emuPerPixel)
+	if cx > w.maxWidth {
+		cy = cy * w.maxWidth / cx
+		cx = w.maxWidth
+	}
+	return cx, cy
+}
+
+func imageKind(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpeg"
+	case "image/gif":
+		return "gif"
+	}
+	if bytes.Contains(data[:min(len(data), 1024)], []byte("<svg")) {
+		return "svg"
+	}
+	return ""
+}
+
+// svgSize returns the width and height of an SVG in pixels, from its
+// width and height attributes or else its viewBox.
+func svgSize(data []byte) (float64, float64) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return 0, 0
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if se.Name.Local != "svg" {
+			return 0, 0
+		}
+		var width, height float64
+		var viewBox []string
+		for _, a := range se.Attr {
+			switch a.Name.Local {
+			case "width":
+				width = svgLength(a.Value)
+			case "height":
+				height = svgLength(a.Value)
+			case "viewBox":
+				viewBox = strings.Fields(strings.ReplaceAll(a.Value, ",", " "))
+			}
+		}
+		if width > 0 && height > 0 {
+			return width, height
+		}
+		if len(viewBox) == 4 {
+			vw, _ := strconv.ParseFloat(viewBox[2], 64)
+			vh, _ := strconv.ParseFloat(viewBox[3], 64)
+			return vw, vh
+		}
+		return 0, 0
+	}
+}
+
+// svgLength converts a length in px, pt or user units to pixels.
+// Relative lengths such as percentages are 0.
+func svgLength(s string) float64 {
+	s = strings.TrimSpace(s)
+	factor := 1.0
+	switch {
+	case strings.HasSuffix(s, "px"):
+		s = strings.TrimSuffix(s, "px")
+	case strings.HasSuffix(s, "pt"):
+		s = strings.TrimSuffix(s, "pt")
+		factor = 96.0 / 72
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v * factor
+}
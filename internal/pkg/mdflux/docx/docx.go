@@ -0,0 +1,411 @@
+// Package docx writes parsed markdown documents as Word (WordprocessingML)
+// documents.
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+const (
+	relStyles    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+	relNumbering = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	relFootnotes = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes"
+	relSettings  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings"
+	relTheme     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/theme"
+	relImage     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	relHyperlink = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink"
+
+	// pageMargin is the margin of generated page setups in twips.
+	pageMargin   = 1440
+	twipsPerInch = 1440
+	// a4Width is the width of an A4 page in twips.
+	a4Width = 11906
+	// listLevels is the number of nesting levels Word supports for lists.
+	listLevels = 9
+)
+
+var (
+	styleIDPattern  = regexp.MustCompile(`w:styleId="([^"]+)"`)
+	stylePattern    = regexp.MustCompile(`(?s)<w:style\b[^>]*>.*?</w:style>`)
+	sectPrRefs      = regexp.MustCompile(`<w:(?:header|footer)Reference\b[^>]*/>`)
+	xmlPrefix       = regexp.MustCompile(`[<\s/]([A-Za-z][A-Za-z0-9]*):`)
+	pageWidthAttr   = regexp.MustCompile(`<w:pgSz\b[^>]*\sw:w="(\d+)"`)
+	leftMarginAttr  = regexp.MustCompile(`<w:pgMar\b[^>]*\sw:left="(\d+)"`)
+	rightMarginAttr = regexp.MustCompile(`<w:pgMar\b[^>]*\sw:right="(\d+)"`)
+
+	bullets = []string{"•", "◦", "▪"}
+)
+
+// Metadata describes the document in its core and application properties.
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Subject     string
+	Description string
+	Keywords    []string
+	Lang        string
+	// Generator is the application recorded in the document.
+	Generator string
+	Modified  time.Time
+}
+
+// Chapter is a parsed document written into the Word document, e.g. a
+// chapter of a book.
+type Chapter struct {
+	Doc *converter.Document
+	// Anchor is bookmarked at the start of the chapter so that links to
+	// it resolve, or empty.
+	Anchor string
+	// PageBreak starts the chapter on a new page.
+	PageBreak bool
+}
+
+// Image is a picture to embed, PNG, JPEG, GIF or SVG.
+type Image struct {
+	Data []byte
+	// Scale is the number of image pixels per CSS pixel of screenshots
+	// taken with a device scale factor, or 0 for 1.
+	Scale float64
+}
+
+// Options configures Write.
+type Options struct {
+	Metadata Metadata
+	// Reference is the path of a .docx whose styles, theme and page setup
+	// are used, or empty for the built-in styles.
+	Reference string
+	// BaseDir resolves relative image paths of documents without a path.
+	BaseDir string
+	// Diagrams holds the images of Mermaid, D2 and display math nodes.
+	// Diagrams without an image are written as code, math as TeX.
+	Diagrams map[ast.Node]Image
+	// PageWidth and PageHeight are the page size in inches of documents
+	// without a reference document.
+	PageWidth  float64
+	PageHeight float64
+	// TOCDepth adds a table of contents field listing headings up to this
+	// level in front, or none when 0.
+	TOCDepth int
+}
+
+type relationship struct {
+	ID       string
+	Type     string
+	Target   string
+	External bool
+}
+
+// relationships are the relationships of a part, numbered rId1, rId2 and
+// so on.
+type relationships struct {
+	list []relationship
+	ids  map[string]string
+}
+
+func (r *relationships) add(typ, target string, external bool) string {
+	key := typ + " " + target
+	if id, ok := r.ids[key]; ok {
+		return id
+	}
+	if r.ids == nil {
+		r.ids = map[string]string{}
+	}
+	id := fmt.Sprintf("rId%d", len(r.list)+1)
+	r.list = append(r.list, relationship{ID: id, Type: typ, Target: target, External: external})
+	r.ids[key] = id
+	return id
+}
+
+type mediaType struct {
+	Extension   string
+	ContentType string
+}
+
+type listLevel struct {
+	Level  int
+	Number int
+	Indent int
+	Bullet string
+}
+
+type list struct {
+	ID      int
+	Ordered bool
+	Level   int
+	Start   int
+}
+
+type coreData struct {
+	Metadata
+	Creator     string
+	KeywordList string
+	Timestamp   string
+}
+
+// reference holds the parts taken from a reference document.
+type reference struct {
+	styles []byte
+	theme  []byte
+	sectPr string
+}
+
+// Write writes chapters as one Word document to w.
+func Write(w io.Writer, tmpl *template.Template, chapters []Chapter, opts Options) error {
+	md := opts.Metadata
+	if md.Modified.IsZero() {
+		md.Modified = time.Now()
+	}
+
+	var ref *reference
+	if opts.Reference != "" {
+		var err error
+		if ref, err = readReference(opts.Reference); err != nil {
+			return err
+		}
+	}
+
+	sectPr := pageSetup(opts.PageWidth, opts.PageHeight)
+	if ref != nil && ref.sectPr != "" {
+		sectPr = ref.sectPr
+	}
+
+	bw := newWriter(opts, textWidth(sectPr))
+	bw.docRels.add(relStyles, "styles.xml", false)
+	bw.docRels.add(relNumbering, "numbering.xml", false)
+	bw.docRels.add(relFootnotes, "footnotes.xml", false)
+	bw.docRels.add(relSettings, "settings.xml", false)
+	if ref != nil && ref.theme != nil {
+		bw.docRels.add(relTheme, "theme/theme1.xml", false)
+	}
+	if opts.TOCDepth > 0 {
+		bw.tableOfContents(opts.TOCDepth)
+	}
+	for _, ch := range chapters {
+		if err := bw.chapter(ch); err != nil {
+			return err
+		}
+	}
+	if bw.pending.Len() > 0 || bw.body.Len() == 0 {
+		if err := bw.paragraph(paraProps{}, nil); err != nil {
+			return err
+		}
+	}
+
+	styles, err := execute(tmpl, "docx-styles", md.Lang)
+	if err != nil {
+		return err
+	}
+	if ref != nil {
+		styles = mergeStyles(ref.styles, styles)
+	}
+
+	levels := make([]listLevel, listLevels)
+	for i := range levels {
+		levels[i] = listLevel{Level: i, Number: i + 1, Indent: listIndent * (i + 1), Bullet: bullets[i%len(bullets)]}
+	}
+
+	var media []mediaType
+	seen := map[string]bool{}
+	for _, name := range bw.mediaNames {
+		ext := name[strings.LastIndexByte(name, '.')+1:]
+		if !seen[ext] {
+			seen[ext] = true
+			media = append(media, mediaType{Extension: ext, ContentType: contentTypes[ext]})
+		}
+	}
+
+	parts := []struct {
+		name string
+		tmpl string
+		data any
+	}{
+		{"[Content_Types].xml", "docx-content-types", map[string]any{"Media": media, "Theme": ref != nil && ref.theme != nil}},
+		{"_rels/.rels", "docx-package-rels", nil},
+		{"docProps/core.xml", "docx-core", coreData{
+			Metadata:    md,
+			Creator:     strings.Join(md.Authors, "; "),
+			KeywordList: strings.Join(md.Keywords, ", "),
+			Timestamp:   md.Modified.UTC().Format("2006-01-02T15:04:05Z"),
+		}},
+		{"docProps/app.xml", "docx-app", md},
+		{"word/document.xml", "docx-document", map[string]string{"Body": bw.body.String(), "SectPr": sectPr}},
+		{"word/_rels/document.xml.rels", "docx-rels", bw.docRels.list},
+		{"word/footnotes.xml", "docx-footnotes", bw.notes.String()},
+		{"word/_rels/footnotes.xml.rels", "docx-rels", bw.noteRels.list},
+		{"word/numbering.xml", "docx-numbering", map[string]any{"Levels": levels, "Lists": bw.lists}},
+		{"word/settings.xml", "docx-settings", map[string]bool{"UpdateFields": opts.TOCDepth > 0}},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, p := range parts {
+		data, err := execute(tmpl, p.tmpl, p.data)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(zw, p.name, data, md.Modified); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(zw, "word/styles.xml", styles, md.Modified); err != nil {
+		return err
+	}
+	if ref != nil && ref.theme != nil {
+		if err := writeFile(zw, "word/theme/theme1.xml", ref.theme, md.Modified); err != nil {
+			return err
+		}
+	}
+	for _, name := range bw.mediaNames {
+		if err := writeFile(zw, "word/"+name, bw.media[name], md.Modified); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write DOCX: %w", err)
+	}
+	return nil
+}
+
+func execute(tmpl *template.Template, name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("failed to execute %s template: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("failed to write DOCX: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("failed to write DOCX: %w", err)
+	}
+	return nil
+}
+
+// pageSetup returns the section properties for a page of the given size
+// in inches, A4 when zero.
+func pageSetup(width, height float64) string {
+	if width <= 0 || height <= 0 {
+		width, height = 8.27, 11.69
+	}
+	orient := ""
+	if width > height {
+		orient = ` w:orient="landscape"`
+	}
+	return fmt.Sprintf(`<w:sectPr><w:pgSz w:w="%d" w:h="%d"%s/><w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="720" w:footer="720" w:gutter="0"/></w:sectPr>`,
+		int(width*twipsPerInch+0.5), int(height*twipsPerInch+0.5), orient, pageMargin, pageMargin, pageMargin, pageMargin)
+}
+
+// textWidth returns the width between the margins of a page setup in
+// twips.
+func textWidth(sectPr string) int {
+	attr := func(pattern *regexp.Regexp, def int) int {
+		if m := pattern.FindStringSubmatch(sectPr); m != nil {
+			if v, err := strconv.Atoi(m[1]); err == nil {
+				return v
+			}
+		}
+		return def
+	}
+	width := attr(pageWidthAttr, a4Width) - attr(leftMarginAttr, pageMargin) - attr(rightMarginAttr, pageMargin)
+	return max(width, twipsPerInch)
+}
+
+// readReference reads the styles, theme and page setup of a reference
+// document.
+func readReference(path string) (*reference, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reference document: %w", err)
+	}
+	defer zr.Close()
+
+	ref := &reference{}
+	for _, f := range zr.File {
+		var dst *[]byte
+		var document []byte
+		switch f.Name {
+		case "word/styles.xml":
+			dst = &ref.styles
+		case "word/theme/theme1.xml":
+			dst = &ref.theme
+		case "word/document.xml":
+			dst = &document
+		default:
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reference document: %w", err)
+		}
+		*dst, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reference document: %w", err)
+		}
+		if document != nil {
+			ref.sectPr = sectionProperties(string(document))
+		}
+	}
+	if ref.styles == nil {
+		return nil, fmt.Errorf("reference document %s has no styles", path)
+	}
+	return ref, nil
+}
+
+// sectionProperties returns the page setup at the end of the body of a
+// document. Headers and footers are parts of their own and are dropped,
+// as is a page setup using namespaces the generated document does not
+// declare.
+func sectionProperties(document string) string {
+	start := strings.LastIndex(document, "<w:sectPr")
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(document[start:], "</w:sectPr>")
+	if end < 0 {
+		return ""
+	}
+	sectPr := sectPrRefs.ReplaceAllString(document[start:start+end+len("</w:sectPr>")], "")
+	for _, m := range xmlPrefix.FindAllStringSubmatch(sectPr, -1) {
+		if m[1] != "w" && m[1] != "r" {
+			return ""
+		}
+	}
+	return sectPr
+}
+
+// mergeStyles adds the built-in styles that the reference styles lack, so
+// that a reference document only needs to contain the styles it changes.
+func mergeStyles(ref, builtin []byte) []byte {
+	defined := map[string]bool{}
+	for _, m := range styleIDPattern.FindAllSubmatch(ref, -1) {
+		defined[string(m[1])] = true
+	}
+	var missing []byte
+	for _, style := range stylePattern.FindAll(builtin, -1) {
+		m := styleIDPattern.FindSubmatch(style)
+		if m != nil && !defined[string(m[1])] {
+			missing = append(append(missing, style...), '\n')
+		}
+	}
+	end := bytes.LastIndex(ref, []byte("</w:styles>"))
+	if end < 0 || len(missing) == 0 {
+		return ref
+	}
+	return append(append(append([]byte{}, ref[:end]...), missing...), ref[end:]...)
+}
@@ -0,0 +1,472 @@
+// Package epub packages an XHTML document as an EPUB 3 publication.
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+const (
+	mimetype     = "application/epub+zip"
+	contentDir   = "OEBPS"
+	contentFile  = "content.xhtml"
+	xhtmlType    = "application/xhtml+xml"
+	coverImageID = "cover-image"
+)
+
+var (
+	entityPattern  = regexp.MustCompile(`&[A-Za-z][A-Za-z0-9]*;`)
+	voidPattern    = regexp.MustCompile(`<(br|hr)>`)
+	declPattern    = regexp.MustCompile(`<\?xml[^>]*\?>`)
+	imgSrcPattern  = regexp.MustCompile(`(<img\b[^>]*?\ssrc=")([^"]*)(")`)
+	cssURL         = regexp.MustCompile(`url\(\s*["']?([^"')\s]+)`)
+	dataFontURL    = regexp.MustCompile(`url\(\s*["']?data:((?:font|application)/[A-Za-z0-9.+-]+)(?:;[^,;]*)*;base64,([A-Za-z0-9+/=\s]+)["']?\s*\)`)
+	datePattern    = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?`)
+	headingPattern = regexp.MustCompile(`^h[1-6]$`)
+)
+
+// Metadata describes the publication.
+type Metadata struct {
+	// Identifier is the unique identifier of the publication. A URN
+	// derived from the title and authors is used when empty.
+	Identifier  string
+	Title       string
+	Authors     []string
+	Subjects    []string
+	Description string
+	Date        string
+	Lang        string
+	// Modified is the last modification time, required by EPUB 3.
+	Modified time.Time
+}
+
+// Options configures Build.
+type Options struct {
+	Metadata Metadata
+	// Styles is the stylesheet of the content document. Fonts embedded as
+	// data URIs are moved into separate files.
+	Styles string
+	// BaseDir resolves relative image paths of the content document.
+	BaseDir string
+	// Cover is the path of the cover image, or empty.
+	Cover string
+}
+
+type item struct {
+	ID         string
+	Href       string
+	MediaType  string
+	Properties string
+}
+
+type packageData struct {
+	Metadata
+	Creators   []string
+	Modified   string
+	CoverImage string
+	Items      []item
+	Spine      []string
+}
+
+type navData struct {
+	Title string
+	Lang  string
+	TOC   []*converter.TOCEntry
+}
+
+type coverData struct {
+	Title string
+	Lang  string
+	Href  string
+}
+
+// publication collects the files of the EPUB below contentDir.
+type publication struct {
+	files map[string][]byte
+	items []item
+	// sources maps the resolved path of an image to its href.
+	sources map[string]string
+}
+
+func (p *publication) add(id, href, mediaType, properties string, data []byte) {
+	p.files[href] = data
+	p.items = append(p.items, item{ID: id, Href: href, MediaType: mediaType, Properties: properties})
+}
+
+// Build writes an EPUB 3 container for the XHTML content document to w.
+// The document is expected to be rendered with the epub page templates.
+// Local images are embedded, the navigation document is built from the
+// headings that have an ID.
+func Build(w io.Writer, tmpl *template.Template, content []byte, opts Options) error {
+	content = xmlCompatible(content)
+
+	headings, err := scanContent(content)
+	if err != nil {
+		return fmt.Errorf("content is not well-formed XHTML: %w", err)
+	}
+
+	md := opts.Metadata
+	if md.Title == "" {
+		md.Title = "Document"
+	}
+	if md.Lang == "" {
+		md.Lang = "en"
+	}
+	if md.Identifier == "" {
+		md.Identifier = derivedIdentifier(md.Title, md.Authors)
+	}
+	if md.Date != "" && !datePattern.MatchString(md.Date) {
+		log.Warn().Str("date", md.Date).Msg("EPUB date is not in YYYY-MM-DD format, leaving it out")
+		md.Date = ""
+	}
+	if md.Modified.IsZero() {
+		md.Modified = time.Now()
+	}
+
+	pub := &publication{files: map[string][]byte{}, sources: map[string]string{}}
+	data := packageData{
+		Metadata: md,
+		Creators: md.Authors,
+		Modified: md.Modified.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	if opts.Cover != "" {
+		img, err := os.ReadFile(opts.Cover)
+		if err != nil {
+			return fmt.Errorf("failed to read cover image: %w", err)
+		}
+		mediaType := imageType(opts.Cover, img)
+		href := "images/cover" + path.Ext(filepath.ToSlash(opts.Cover))
+		pub.add(coverImageID, href, mediaType, "cover-image", img)
+		data.CoverImage = coverImageID
+
+		var cover bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&cover, "epub-cover", coverData{Title: md.Title, Lang: md.Lang, Href: href}); err != nil {
+			return fmt.Errorf("failed to execute epub-cover template: %w", err)
+		}
+		pub.add("cover", "cover.xhtml", xhtmlType, "", cover.Bytes())
+		data.Spine = append(data.Spine, "cover")
+	}
+
+	var nav bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&nav, "epub-nav", navData{Title: md.Title, Lang: md.Lang, TOC: converter.BuildTOC(headings)}); err != nil {
+		return fmt.Errorf("failed to execute epub-nav template: %w", err)
+	}
+	pub.add("nav", "nav.xhtml", xhtmlType, "nav", nav.Bytes())
+
+	content, err = pub.embedImages(content, opts.BaseDir)
+	if err != nil {
+		return err
+	}
+
+	var properties []string
+	if bytes.Contains(content, []byte("<svg")) {
+		properties = append(properties, "svg")
+	}
+	if bytes.Contains(content, []byte("<math")) {
+		properties = append(properties, "mathml")
+	}
+	pub.add("content", contentFile, xhtmlType, strings.Join(properties, " "), content)
+	data.Spine = append(data.Spine, "content")
+
+	pub.add("styles", "styles.css", "text/css", "", []byte(pub.extractFonts(opts.Styles)))
+
+	data.Items = pub.items
+	var opf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&opf, "epub-package", data); err != nil {
+		return fmt.Errorf("failed to execute epub-package template: %w", err)
+	}
+	var container bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&container, "epub-container", nil); err != nil {
+		return fmt.Errorf("failed to execute epub-container template: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeMimetype(zw); err != nil {
+		return err
+	}
+	if err := writeFile(zw, "META-INF/container.xml", container.Bytes(), md.Modified); err != nil {
+		return err
+	}
+	if err := writeFile(zw, contentDir+"/content.opf", opf.Bytes(), md.Modified); err != nil {
+		return err
+	}
+	for _, it := range pub.items {
+		if err := writeFile(zw, contentDir+"/"+it.Href, pub.files[it.Href], md.Modified); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	return nil
+}
+
+// writeMimetype writes the uncompressed mimetype entry that must come
+// first in the container, without a data descriptor or extra fields.
+func writeMimetype(zw *zip.Writer) error {
+	data := []byte(mimetype)
+	fw, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	return nil
+}
+
+func writeFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+	return nil
+}
+
+// xmlCompatible fixes HTML serialisations that are not well-formed XML:
+// named entities, which XHTML documents without a DTD cannot use, unclosed
+// line breaks in diagram labels, and the XML declarations of inline SVGs.
+func xmlCompatible(content []byte) []byte {
+	if i := bytes.Index(content, []byte("?>")); bytes.HasPrefix(content, []byte("<?xml")) && i > 0 {
+		prolog := content[:i+2]
+		content = append(append([]byte{}, prolog...), declPattern.ReplaceAll(content[i+2:], nil)...)
+	} else {
+		content = declPattern.ReplaceAll(content, nil)
+	}
+	content = voidPattern.ReplaceAll(content, []byte("<$1/>"))
+	return entityPattern.ReplaceAllFunc(content, func(entity []byte) []byte {
+		switch string(entity) {
+		case "&amp;", "&lt;", "&gt;", "&quot;", "&apos;":
+			return entity
+		}
+		s := html.UnescapeString(string(entity))
+		if s == string(entity) {
+			return entity
+		}
+		return []byte(html.EscapeString(s))
+	})
+}
+
+// scanContent checks that content is well-formed XML and returns the
+// headings that have an ID.
+func scanContent(content []byte) ([]converter.Heading, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.Strict = true
+
+	var headings []converter.Heading
+	var current *converter.Heading
+	var text strings.Builder
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return headings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if current != nil {
+				depth++
+				if t.Name.Local == "math" || hasClass(t, "katex-mathml") {
+					// MathML duplicates the rendered formula.
+					if err := dec.Skip(); err != nil {
+						return nil, err
+					}
+					depth--
+				}
+				continue
+			}
+			if !headingPattern.MatchString(t.Name.Local) {
+				continue
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "id" {
+					current = &converter.Heading{Level: int(t.Name.Local[1] - '0'), ID: attr.Value}
+					text.Reset()
+					depth = 0
+				}
+			}
+		case xml.EndElement:
+			if current == nil {
+				continue
+			}
+			if depth > 0 {
+				depth--
+				continue
+			}
+			current.Text = strings.Join(strings.Fields(text.String()), " ")
+			if current.Text != "" {
+				headings = append(headings, *current)
+			}
+			current = nil
+		case xml.CharData:
+			if current != nil {
+				text.Write(t)
+			}
+		}
+	}
+}
+
+func hasClass(t xml.StartElement, class string) bool {
+	for _, attr := range t.Attr {
+		if attr.Name.Local == "class" {
+			for _, c := range strings.Fields(attr.Value) {
+				if c == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// embedImages adds the local images of content to the publication and
+// points their src at the copies. Remote images cannot be part of an EPUB
+// and are left as they are.
+func (p *publication) embedImages(content []byte, baseDir string) ([]byte, error) {
+	var embedErr error
+	content = imgSrcPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		parts := imgSrcPattern.FindSubmatch(m)
+		src := html.UnescapeString(string(parts[2]))
+		if strings.HasPrefix(src, "data:") {
+			return m
+		}
+		u, err := url.Parse(src)
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			log.Warn().Str("src", src).Msg("Remote images are not embedded in EPUB output")
+			return m
+		}
+		file := filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(baseDir, file)
+		}
+
+		href, ok := p.sources[file]
+		if !ok {
+			img, err := os.ReadFile(file)
+			if err != nil {
+				if embedErr == nil {
+					embedErr = fmt.Errorf("failed to embed image: %w", err)
+				}
+				return m
+			}
+			sum := sha256.Sum256(img)
+			id := "img-" + hex.EncodeToString(sum[:8])
+			href = "images/" + id + strings.ToLower(path.Ext(u.Path))
+			p.sources[file] = href
+			if _, exists := p.files[href]; !exists {
+				p.add(id, href, imageType(file, img), "", img)
+			}
+		}
+		return append(append(append([]byte{}, parts[1]...), html.EscapeString(href)...), parts[3]...)
+	})
+	return content, embedErr
+}
+
+// extractFonts moves fonts embedded in the stylesheet as data URIs into
+// separate files, which e-readers load more reliably. Other local URLs
+// cannot be resolved and are reported.
+func (p *publication) extractFonts(styles string) string {
+	n := 0
+	styles = dataFontURL.ReplaceAllStringFunc(styles, func(m string) string {
+		parts := dataFontURL.FindStringSubmatch(m)
+		font, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(parts[2]), ""))
+		if err != nil {
+			return m
+		}
+		n++
+		ext := fontExtension(parts[1])
+		href := fmt.Sprintf("fonts/font-%d%s", n, ext)
+		p.add(fmt.Sprintf("font-%d", n), href, fontType(ext), "", font)
+		return `url("` + href + `")`
+	})
+
+	for _, m := range cssURL.FindAllStringSubmatch(styles, -1) {
+		u := m[1]
+		if _, ok := p.files[u]; ok || strings.HasPrefix(u, "data:") || strings.Contains(u, "://") || strings.HasPrefix(u, "#") {
+			continue
+		}
+		log.Warn().Str("url", u).Msg("Stylesheet resource is not embedded in EPUB output")
+		break
+	}
+	return styles
+}
+
+func fontExtension(mediaType string) string {
+	switch {
+	case strings.Contains(mediaType, "woff2"):
+		return ".woff2"
+	case strings.Contains(mediaType, "woff"):
+		return ".woff"
+	case strings.Contains(mediaType, "otf"), strings.Contains(mediaType, "opentype"):
+		return ".otf"
+	default:
+		return ".ttf"
+	}
+}
+
+func fontType(ext string) string {
+	switch ext {
+	case ".woff2":
+		return "font/woff2"
+	case ".woff":
+		return "font/woff"
+	case ".otf":
+		return "font/otf"
+	default:
+		return "font/ttf"
+	}
+}
+
+func imageType(name string, data []byte) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// derivedIdentifier returns a name-based UUID URN, so that new builds of a
+// document replace the old one on e-readers.
+func derivedIdentifier(title string, authors []string) string {
+	sum := sha1.Sum([]byte(title + "\x00" + strings.Join(authors, "\x00")))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
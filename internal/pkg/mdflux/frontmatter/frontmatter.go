@@ -0,0 +1,141 @@
+// Package frontmatter extracts YAML front matter from markdown documents.
+package frontmatter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Meta is the document metadata declared in front matter. Well-known keys
+// are decoded into fields; Params holds every key as decoded YAML so that
+// templates can use custom ones.
+type Meta struct {
+	Title       string     `yaml:"title"`
+	Subtitle    string     `yaml:"subtitle"`
+	Author      StringList `yaml:"author"`
+	Subject     string     `yaml:"subject"`
+	Description string     `yaml:"description"`
+	Keywords    StringList `yaml:"keywords"`
+	Date        string     `yaml:"date"`
+	Lang        string     `yaml:"lang"`
+	Status      string     `yaml:"status"`
+
+	Params map[string]interface{} `yaml:"-"`
+}
+
+// StringList decodes either a single string or a list of strings.
+type StringList []string
+
+// String joins the entries with ", " so that lists print naturally in
+// templates.
+func (l StringList) String() string {
+	return strings.Join(l, ", ")
+}
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Value != "" {
+			*l = StringList{value.Value}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		*l = list
+		return nil
+	default:
+		return fmt.Errorf("line %d: expected a string or a list of strings", value.Line)
+	}
+}
+
+// Split separates front matter delimited by --- lines from the start of
+// source. The returned body has the front matter replaced by empty lines
+// so that line numbers in diagnostics stay correct. Sources without front
+// matter are returned unchanged with an empty Meta.
+func Split(source []byte) (*Meta, []byte, error) {
+	meta := &Meta{}
+
+	rest, ok := cutDelimiter(source)
+	if !ok {
+		return meta, source, nil
+	}
+
+	var block []byte
+	end := -1
+	for offset := len(source) - len(rest); offset < len(source); {
+		line := source[offset:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if bytes.Equal(trimmed, []byte("---")) || bytes.Equal(trimmed, []byte("...")) {
+			end = offset + len(line)
+			break
+		}
+		block = append(block, line...)
+		offset += len(line)
+	}
+	if end < 0 {
+		return meta, source, nil
+	}
+
+	if err := yaml.Unmarshal(block, meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	if err := yaml.Unmarshal(block, &meta.Params); err != nil {
+		return nil, nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	body := make([]byte, 0, len(source))
+	body = append(body, bytes.Repeat([]byte("\n"), bytes.Count(source[:end], []byte("\n")))...)
+	body = append(body, source[end:]...)
+	return meta, body, nil
+}
+
+func cutDelimiter(source []byte) ([]byte, bool) {
+	source = bytes.TrimPrefix(source, []byte("\xef\xbb\xbf"))
+	for _, delim := range []string{"---\n", "---\r\n"} {
+		if rest, ok := bytes.CutPrefix(source, []byte(delim)); ok {
+			return rest, true
+		}
+	}
+	return nil, false
+}
+
+// Merge returns m with empty fields filled in from defaults.
+func (m Meta) Merge(defaults Meta) Meta {
+	if m.Title == "" {
+		m.Title = defaults.Title
+	}
+	if m.Subtitle == "" {
+		m.Subtitle = defaults.Subtitle
+	}
+	if len(m.Author) == 0 {
+		m.Author = defaults.Author
+	}
+	if m.Subject == "" {
+		m.Subject = defaults.Subject
+	}
+	if m.Description == "" {
+		m.Description = defaults.Description
+	}
+	if len(m.Keywords) == 0 {
+		m.Keywords = defaults.Keywords
+	}
+	if m.Date == "" {
+		m.Date = defaults.Date
+	}
+	if m.Lang == "" {
+		m.Lang = defaults.Lang
+	}
+	if m.Status == "" {
+		m.Status = defaults.Status
+	}
+	return m
+}
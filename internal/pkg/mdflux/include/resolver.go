@@ -0,0 +1,676 @@
+package include
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mdflux/internal/pkg/mdflux/frontmatter"
+)
+
+const (
+	stdinName       = "<stdin>"
+	maxHeadingLevel = 6
+)
+
+var (
+	shortcodePattern = regexp.MustCompile(`^\s*\{\{<\s*include\s+(.*?)\s*>\}\}\s*$`)
+	bangPattern      = regexp.MustCompile(`^\s*!include\s+(.+?)\s*$`)
+	fencePattern     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})(.*)$")
+	atxPattern       = regexp.MustCompile(`^( {0,3})(#{1,6})([ \t]|$)`)
+	setextPattern    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	regionPattern    = regexp.MustCompile(`#region\s+(\S+)`)
+	endRegionPattern = regexp.MustCompile(`#endregion\b`)
+)
+
+// ErrCycle is returned when a file includes itself, directly or indirectly.
+var ErrCycle = errors.New("include cycle detected")
+
+// ErrOutsideRoot is returned for an include of a file outside the root
+// directory.
+var ErrOutsideRoot = errors.New("included file is outside the root directory")
+
+// Options restricts which files may be included.
+type Options struct {
+	// Root is the directory included files must lie in. It defaults to
+	// the directory of the input, or the working directory for stdin.
+	Root string
+	// AllowOutside permits absolute paths and paths leaving Root.
+	AllowOutside bool
+	// Offset is the number of bytes the input file is longer than source,
+	// e.g. by front matter blanked out with empty lines. It is added to
+	// the offsets of Result.Position in the input file.
+	Offset int
+}
+
+// Error describes a failed include directive and points at the file and
+// line where the directive appears.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Origin identifies the file and 1-based line a line of resolved output
+// was taken from.
+type Origin struct {
+	File string
+	Line int
+}
+
+func (o Origin) String() string {
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
+}
+
+// Position locates a byte in the file it was taken from. Line and Column
+// are 1-based, Column and Offset count bytes. In lines rewritten by an
+// include, such as headings moved by shift, columns count the rewritten
+// line.
+type Position struct {
+	File     string
+	Line     int
+	Column   int
+	Offset   int
+	Included bool // File is an included file rather than the input
+}
+
+// Result holds the fully expanded source together with a line map back to
+// the files the content came from.
+type Result struct {
+	Source      []byte
+	name        string
+	origins     []Origin
+	lineStarts  []int
+	fileOffsets []int
+}
+
+// Origin returns where the byte at offset in Source was included from.
+func (r *Result) Origin(offset int) Origin {
+	if len(r.origins) == 0 {
+		return Origin{}
+	}
+	return r.origins[r.line(offset)]
+}
+
+// Position returns the position of the byte at offset in Source in the
+// file it was taken from.
+func (r *Result) Position(offset int) Position {
+	if len(r.origins) == 0 {
+		return Position{File: r.name, Line: 1, Column: 1}
+	}
+	i := r.line(offset)
+	column := offset - r.lineStarts[i]
+	return Position{
+		File:     r.origins[i].File,
+		Line:     r.origins[i].Line,
+		Column:   column + 1,
+		Offset:   r.fileOffsets[i] + column,
+		Included: r.origins[i].File != r.name,
+	}
+}
+
+// line returns the index of the line of Source holding offset.
+func (r *Result) line(offset int) int {
+	lo, hi := 0, len(r.lineStarts)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if r.lineStarts[mid] <= offset {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// Resolve expands include directives in source before it is handed to the
+// markdown parser. The path is used to resolve relative includes and in
+// diagnostics; an empty path means the source was read from stdin and
+// includes are resolved against the working directory.
+//
+// Supported directives, each on a line of its own outside fenced and
+// indented code:
+//
+//	{{< include "chapters/intro.md" shift=1 >}}
+//	!include chapters/intro.md lines="1-20"
+//
+// and fenced code blocks whose body is taken from a file:
+//
+//	```go include="main.go" lines="10-40"
+//	```
+//
+// Both forms accept lines="10-40,55" and region="name", where a region is
+// delimited by "#region name" and "#endregion" markers in the included file.
+// Front matter of included markdown files is dropped. Unless
+// opts.AllowOutside is set, included files must lie in opts.Root.
+func Resolve(source []byte, path string, opts Options) (*Result, error) {
+	name := stdinName
+	absPath := ""
+	if path != "" {
+		name = path
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		absPath = abs
+	}
+
+	root := opts.Root
+	if root == "" {
+		root = baseDir(absPath)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	r := &resolver{result: &Result{name: name}, root: root, allowOutside: opts.AllowOutside}
+	if absPath != "" {
+		r.stack = append(r.stack, absPath)
+	}
+	if err := r.expand(numberLines(source, opts.Offset), name, baseDir(absPath), 0); err != nil {
+		return nil, err
+	}
+	return r.result, nil
+}
+
+type resolver struct {
+	result       *Result
+	stack        []string
+	root         string
+	allowOutside bool
+}
+
+func baseDir(absPath string) string {
+	if absPath == "" {
+		return "."
+	}
+	return filepath.Dir(absPath)
+}
+
+// emit appends line, taken from the file offset of origin.
+func (r *resolver) emit(line string, origin Origin, offset int) {
+	r.result.lineStarts = append(r.result.lineStarts, len(r.result.Source))
+	r.result.origins = append(r.result.origins, origin)
+	r.result.fileOffsets = append(r.result.fileOffsets, offset)
+	r.result.Source = append(r.result.Source, line...)
+	r.result.Source = append(r.result.Source, '\n')
+}
+
+// expand emits lines, resolving the directives in them. The lines keep
+// their numbers in the file name, also when only some were selected.
+func (r *resolver) expand(lines []sourceLine, name, dir string, shift int) error {
+	var fenceChar byte
+	fenceLen := 0
+	// An indented code block starts after a blank line and runs until a
+	// line with less indentation.
+	blank, indented := true, false
+
+	for i := 0; i < len(lines); i++ {
+		line, offset := lines[i].text, lines[i].offset
+		origin := Origin{File: name, Line: lines[i].line}
+
+		wasBlank := blank
+		blank = strings.TrimSpace(line) == ""
+		if fenceLen == 0 && !blank {
+			indented = indentWidth(line) >= 4 && (indented || wasBlank)
+		}
+		if indented {
+			r.emit(line, origin, offset)
+			continue
+		}
+
+		if fenceLen > 0 {
+			if isClosingFence(line, fenceChar, fenceLen) {
+				fenceLen = 0
+			}
+			r.emit(line, origin, offset)
+			continue
+		}
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			if _, named, err := parseArgs(m[3]); err == nil && named["include"] != "" {
+				end := i + 1
+				for end < len(lines) && !isClosingFence(lines[end].text, m[2][0], len(m[2])) {
+					end++
+				}
+				if end == len(lines) {
+					return &Error{File: name, Line: origin.Line, Err: fmt.Errorf("code block including %s is not closed", named["include"])}
+				}
+				if err := r.expandCode(m, named, name, dir, lines[i]); err != nil {
+					return err
+				}
+				i = end
+				continue
+			}
+			if m[2][0] == '~' || !strings.Contains(m[3], "`") {
+				fenceChar, fenceLen = m[2][0], len(m[2])
+			}
+			r.emit(line, origin, offset)
+			continue
+		}
+
+		if args, ok := matchDirective(line); ok {
+			if err := r.expandMarkdown(args, name, dir, lines[i], shift); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if shift > 0 {
+			if m := atxPattern.FindStringSubmatch(line); m != nil {
+				level := min(len(m[2])+shift, maxHeadingLevel)
+				line = m[1] + strings.Repeat("#", level) + line[len(m[1])+len(m[2]):]
+			} else if i+1 < len(lines) && isSetextText(line) && setextPattern.MatchString(lines[i+1].text) {
+				level := 1
+				if strings.TrimSpace(lines[i+1].text)[0] == '-' {
+					level = 2
+				}
+				level = min(level+shift, maxHeadingLevel)
+				r.emit(strings.Repeat("#", level)+" "+strings.TrimSpace(line), origin, offset)
+				i++
+				continue
+			}
+		}
+
+		r.emit(line, origin, offset)
+	}
+
+	return nil
+}
+
+func (r *resolver) expandMarkdown(args string, name, dir string, directive sourceLine, shift int) error {
+	line := directive.line
+	positional, named, err := parseArgs(args)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: err}
+	}
+	if len(positional) != 1 {
+		return &Error{File: name, Line: line, Err: fmt.Errorf("include expects exactly one path, got %d", len(positional))}
+	}
+
+	if v, ok := named["shift"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &Error{File: name, Line: line, Err: fmt.Errorf("invalid shift %q", v)}
+		}
+		shift += n
+	}
+
+	content, target, err := r.load(positional[0], dir)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: err}
+	}
+	if err := r.checkCycle(target); err != nil {
+		return &Error{File: name, Line: line, Err: err}
+	}
+
+	// Front matter is replaced by empty lines, so line numbers still
+	// refer to the file.
+	_, body, err := frontmatter.Split(content)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: fmt.Errorf("%s: %w", positional[0], err)}
+	}
+
+	selected, err := selectLines(body, len(content)-len(body), named)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: fmt.Errorf("%s: %w", positional[0], err)}
+	}
+
+	r.stack = append(r.stack, target)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	return r.expand(selected, displayName(name, positional[0]), filepath.Dir(target), shift)
+}
+
+func (r *resolver) expandCode(fence []string, named map[string]string, name, dir string, directive sourceLine) error {
+	file := named["include"]
+	line := directive.line
+
+	content, _, err := r.load(file, dir)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: err}
+	}
+
+	selected, err := selectLines(content, 0, named)
+	if err != nil {
+		return &Error{File: name, Line: line, Err: fmt.Errorf("%s: %w", file, err)}
+	}
+
+	marker := fence[2]
+	for _, s := range selected {
+		if m := fencePattern.FindStringSubmatch(s.text); m != nil && m[2][0] == marker[0] && len(m[2]) >= len(marker) {
+			marker = strings.Repeat(marker[:1], len(m[2])+1)
+		}
+	}
+
+	included := displayName(name, file)
+	r.emit(fence[1]+marker+stripIncludeArgs(fence[3]), Origin{File: name, Line: line}, directive.offset)
+	for _, s := range selected {
+		r.emit(s.text, Origin{File: included, Line: s.line}, s.offset)
+	}
+	r.emit(fence[1]+marker, Origin{File: name, Line: line}, directive.offset)
+
+	return nil
+}
+
+func (r *resolver) load(file, dir string) ([]byte, string, error) {
+	target := file
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	target = filepath.Clean(target)
+
+	if !r.allowOutside {
+		if filepath.IsAbs(file) {
+			return nil, "", fmt.Errorf("%w: %s is an absolute path", ErrOutsideRoot, file)
+		}
+		if rel, err := filepath.Rel(r.root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, "", fmt.Errorf("%w: %s is outside %s", ErrOutsideRoot, file, r.root)
+		}
+	}
+
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read included file: %w", err)
+	}
+	return content, target, nil
+}
+
+func (r *resolver) checkCycle(target string) error {
+	for _, p := range r.stack {
+		if p == target {
+			chain := append(append([]string{}, r.stack...), target)
+			return fmt.Errorf("%w: %s", ErrCycle, strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
+
+func displayName(parent, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	if parent == stdinName {
+		return filepath.Clean(file)
+	}
+	return filepath.Join(filepath.Dir(parent), file)
+}
+
+// IsDirective reports whether line is an include directive.
+func IsDirective(line string) bool {
+	_, ok := matchDirective(line)
+	return ok
+}
+
+func matchDirective(line string) (string, bool) {
+	if m := shortcodePattern.FindStringSubmatch(line); m != nil {
+		return m[1], true
+	}
+	if m := bangPattern.FindStringSubmatch(line); m != nil {
+		return m[1], true
+	}
+	return "", false
+}
+
+func isClosingFence(line string, char byte, length int) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == char {
+		n++
+	}
+	return n >= length && strings.TrimSpace(trimmed[n:]) == ""
+}
+
+// indentWidth returns the columns of leading whitespace, with tabs
+// advancing to the next multiple of 4.
+func indentWidth(line string) int {
+	width := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+func isSetextText(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || len(line)-len(strings.TrimLeft(line, " ")) > 3 {
+		return false
+	}
+	switch trimmed[0] {
+	case '#', '>', '-', '*', '+', '|', '<':
+		return false
+	}
+	return true
+}
+
+func stripIncludeArgs(info string) string {
+	tokens := tokenize(info)
+	kept := tokens[:0]
+	for _, t := range tokens {
+		key, _, found := strings.Cut(t, "=")
+		if found && (key == "include" || key == "lines" || key == "region") {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return strings.Join(kept, " ")
+}
+
+type sourceLine struct {
+	text   string
+	line   int
+	offset int // of the line start in the file
+}
+
+func splitLines(source []byte) []string {
+	source = bytes.TrimSuffix(source, []byte("\n"))
+	if len(source) == 0 {
+		return nil
+	}
+	lines := strings.Split(string(source), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
+
+// numberLines splits source into lines numbered from 1. base is the file
+// offset of the start of source.
+func numberLines(source []byte, base int) []sourceLine {
+	lines := splitLines(source)
+	numbered := make([]sourceLine, len(lines))
+	start := 0
+	for i, l := range lines {
+		numbered[i] = sourceLine{text: l, line: i + 1, offset: base + start}
+		if end := bytes.IndexByte(source[start:], '\n'); end >= 0 {
+			start += end + 1
+		}
+	}
+	return numbered
+}
+
+// selectLines applies the lines and region arguments to content, which
+// starts at the file offset base. Line numbers and offsets always refer to
+// the original file, so diagnostics stay accurate.
+func selectLines(content []byte, base int, named map[string]string) ([]sourceLine, error) {
+	all := numberLines(content, base)
+	total := len(all)
+
+	if region, ok := named["region"]; ok {
+		var err error
+		all, err = selectRegion(all, region)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if spec, ok := named["lines"]; ok {
+		ranges, err := parseRanges(spec, total)
+		if err != nil {
+			return nil, err
+		}
+		var picked []sourceLine
+		for _, s := range all {
+			for _, rg := range ranges {
+				if s.line >= rg[0] && s.line <= rg[1] {
+					picked = append(picked, s)
+					break
+				}
+			}
+		}
+		all = picked
+	}
+
+	return all, nil
+}
+
+func selectRegion(lines []sourceLine, region string) ([]sourceLine, error) {
+	var picked []sourceLine
+	depth := 0
+	found := false
+	for _, s := range lines {
+		if m := regionPattern.FindStringSubmatch(s.text); m != nil {
+			if depth > 0 {
+				depth++
+			} else if m[1] == region {
+				depth = 1
+				found = true
+			}
+			continue
+		}
+		if endRegionPattern.MatchString(s.text) {
+			if depth > 0 {
+				depth--
+			}
+			continue
+		}
+		if depth > 0 {
+			picked = append(picked, s)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("region %q not found", region)
+	}
+	return picked, nil
+}
+
+func parseRanges(spec string, total int) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, end := 1, total
+		var err error
+		if lo != "" {
+			if start, err = strconv.Atoi(lo); err != nil {
+				return nil, fmt.Errorf("invalid line range %q", part)
+			}
+		}
+		if !isRange {
+			end = start
+		} else if hi != "" {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("invalid line range %q", part)
+			}
+		}
+		if start < 1 || end < start || start > total {
+			return nil, fmt.Errorf("line range %q out of bounds (file has %d lines)", part, total)
+		}
+		ranges = append(ranges, [2]int{start, min(end, total)})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("empty line range")
+	}
+	return ranges, nil
+}
+
+// parseArgs splits directive arguments into positional values and
+// key=value pairs. Values may be double-quoted.
+func parseArgs(s string) ([]string, map[string]string, error) {
+	var positional []string
+	named := map[string]string{}
+	for _, t := range tokenize(s) {
+		key, value, found := strings.Cut(t, "=")
+		if found && !strings.HasPrefix(t, `"`) {
+			v, err := unquote(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			named[key] = v
+			continue
+		}
+		v, err := unquote(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		positional = append(positional, v)
+	}
+	return positional, named, nil
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(s):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			inQuote = !inQuote
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+func unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("malformed quoted value %s", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,243 @@
+package include
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files, keyed by slash separated paths, below a new
+// temporary directory and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolve(t *testing.T) {
+	files := map[string]string{
+		"parts/intro.md":   "# Intro\n\nText.\n\nSub\n---\n",
+		"parts/nested.md":  "Nested:\n\n!include intro.md\n",
+		"parts/front.md":   "---\ntitle: Included\n---\nBody.\n",
+		"parts/numbers.md": "one\ntwo\nthree\nfour\nfive\n",
+		"main.go":          "package main\n\n// #region main\nfunc main() {}\n// #endregion\n",
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "shortcode",
+			source: "Before\n\n{{< include \"parts/numbers.md\" >}}\n\nAfter\n",
+			want:   "Before\n\none\ntwo\nthree\nfour\nfive\n\nAfter\n",
+		},
+		{
+			name:   "nested relative to the included file",
+			source: "!include parts/nested.md\n",
+			want:   "Nested:\n\n# Intro\n\nText.\n\nSub\n---\n",
+		},
+		{
+			name:   "shift",
+			source: "{{< include \"parts/intro.md\" shift=1 >}}\n",
+			want:   "## Intro\n\nText.\n\n### Sub\n",
+		},
+		{
+			name:   "shift accumulates across nested includes",
+			source: "{{< include \"parts/nested.md\" shift=5 >}}\n",
+			want:   "Nested:\n\n###### Intro\n\nText.\n\n###### Sub\n",
+		},
+		{
+			name:   "lines",
+			source: "!include parts/numbers.md lines=\"2-3,5\"\n",
+			want:   "two\nthree\nfive\n",
+		},
+		{
+			name:   "open line range",
+			source: "!include parts/numbers.md lines=\"4-\"\n",
+			want:   "four\nfive\n",
+		},
+		{
+			name:   "front matter is dropped",
+			source: "!include parts/front.md\n",
+			want:   "\n\n\nBody.\n",
+		},
+		{
+			name:   "code region",
+			source: "```go include=\"main.go\" region=\"main\"\n```\n",
+			want:   "```go\nfunc main() {}\n```\n",
+		},
+		{
+			name:   "code lines",
+			source: "```go {.numberLines} include=\"main.go\" lines=\"1\"\n```\n",
+			want:   "```go {.numberLines}\npackage main\n```\n",
+		},
+		{
+			name:   "directive in code is kept",
+			source: "```\n!include parts/numbers.md\n```\n",
+			want:   "```\n!include parts/numbers.md\n```\n",
+		},
+	}
+
+	dir := writeTree(t, files)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Resolve([]byte(tt.source), filepath.Join(dir, "doc.md"), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(res.Source); got != tt.want {
+				t.Errorf("source = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"book/a.md":       "A\n\n!include b.md\n",
+		"book/b.md":       "B\n\n!include a.md\n",
+		"book/self.md":    "!include self.md\n",
+		"book/numbers.md": "one\ntwo\n",
+		"secret.md":       "secret\n",
+	})
+	tests := []struct {
+		name   string
+		source string
+		target error
+		want   string
+	}{
+		{
+			name:   "cycle",
+			source: "!include a.md\n",
+			target: ErrCycle,
+			want:   "b.md:3: include cycle detected",
+		},
+		{
+			name:   "self include",
+			source: "\n!include self.md\n",
+			target: ErrCycle,
+			want:   "self.md:1: include cycle detected",
+		},
+		{
+			name:   "parent directory",
+			source: "!include ../secret.md\n",
+			target: ErrOutsideRoot,
+			want:   "doc.md:1: included file is outside the root directory",
+		},
+		{
+			name:   "parent directory in code",
+			source: "```\n```\n\n```md include=\"../secret.md\"\n```\n",
+			target: ErrOutsideRoot,
+			want:   "doc.md:4: included file is outside the root directory",
+		},
+		{
+			name:   "absolute path",
+			source: "!include " + filepath.Join(dir, "secret.md") + "\n",
+			target: ErrOutsideRoot,
+			want:   "is an absolute path",
+		},
+		{
+			name:   "lines out of bounds",
+			source: "!include numbers.md lines=\"3-4\"\n",
+			want:   "doc.md:1: numbers.md: line range \"3-4\" out of bounds (file has 2 lines)",
+		},
+		{
+			name:   "malformed lines",
+			source: "!include numbers.md lines=\"x\"\n",
+			want:   "invalid line range \"x\"",
+		},
+		{
+			name:   "missing region",
+			source: "```\n```\n```txt include=\"numbers.md\" region=\"nope\"\n```\n",
+			want:   "doc.md:3: numbers.md: region \"nope\" not found",
+		},
+		{
+			name:   "negative shift",
+			source: "!include numbers.md shift=-1\n",
+			want:   "invalid shift \"-1\"",
+		},
+		{
+			name:   "missing file",
+			source: "!include missing.md\n",
+			target: os.ErrNotExist,
+			want:   "doc.md:1: failed to read included file",
+		},
+		{
+			name:   "unclosed code block",
+			source: "```go include=\"numbers.md\"\n",
+			want:   "doc.md:1: code block including numbers.md is not closed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Resolve([]byte(tt.source), filepath.Join(dir, "book", "doc.md"), Options{})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			var ie *Error
+			if !errors.As(err, &ie) {
+				t.Errorf("error %v is not an *Error", err)
+			}
+			if tt.target != nil && !errors.Is(err, tt.target) {
+				t.Errorf("error %v is not %v", err, tt.target)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAllowOutside(t *testing.T) {
+	dir := writeTree(t, map[string]string{"secret.md": "secret\n"})
+	path := filepath.Join(dir, "book", "doc.md")
+	source := []byte("!include ../secret.md\n")
+
+	res, err := Resolve(source, path, Options{AllowOutside: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.Source); got != "secret\n" {
+		t.Errorf("source = %q, want %q", got, "secret\n")
+	}
+
+	// A root that contains the file permits it as well.
+	if _, err := Resolve(source, path, Options{Root: dir}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResultOrigin(t *testing.T) {
+	dir := writeTree(t, map[string]string{"parts/numbers.md": "one\ntwo\nthree\n"})
+	path := filepath.Join(dir, "doc.md")
+	res, err := Resolve([]byte("Start\n!include parts/numbers.md lines=\"2-3\"\nEnd\n"), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	included := filepath.Join(dir, "parts", "numbers.md")
+	want := map[string]Origin{
+		"Start": {File: path, Line: 1},
+		"two":   {File: included, Line: 2},
+		"three": {File: included, Line: 3},
+		"End":   {File: path, Line: 3},
+	}
+	for text, origin := range want {
+		offset := strings.Index(string(res.Source), text)
+		if got := res.Origin(offset); got != origin {
+			t.Errorf("Origin(%q) = %v, want %v", text, got, origin)
+		}
+	}
+}
@@ -24,7 +24,7 @@ func (e *Extender) Extend(m goldmark.Markdown) {
 	)
 	m.Renderer().AddOptions(
 		renderer.WithNodeRenderers(
-			util.Prioritized(&htmlRenderer{renderer: e.Renderer}, 100),
+			util.Prioritized(&HTMLRenderer{Renderer: e.Renderer}, 100),
 		),
 	)
 }
@@ -85,35 +85,39 @@ func (t *transformer) Transform(node *ast.Document, reader text.Reader, pc parse
 		mermaidBlock := &CodeBlock{
 			Code: code.Bytes(),
 		}
+		// The lines keep the position of the block in the source.
+		mermaidBlock.SetLines(lines)
 
 		parent := fcb.Parent()
 		parent.ReplaceChild(parent, fcb, mermaidBlock)
 	}
 }
 
-type htmlRenderer struct {
-	renderer *Renderer
+// HTMLRenderer renders mermaid blocks as inline SVG, or as a div for
+// client-side rendering when Renderer is nil.
+type HTMLRenderer struct {
+	Renderer *Renderer
 }
 
-func (r *htmlRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
-	reg.Register(KindMermaidBlock, r.renderMermaidBlock)
+func (r *HTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMermaidBlock, r.Render)
 }
 
-func (r *htmlRenderer) renderMermaidBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+func (r *HTMLRenderer) Render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
 		return ast.WalkContinue, nil
 	}
 
 	n := node.(*CodeBlock)
 
-	if r.renderer == nil {
+	if r.Renderer == nil {
 		_, _ = w.WriteString(`<div class="mermaid">`)
 		_, _ = w.Write(n.Code)
 		_, _ = w.WriteString("</div>\n")
 		return ast.WalkContinue, nil
 	}
 
-	svg, err := r.renderer.Render(string(n.Code))
+	svg, err := r.Renderer.Render(string(n.Code))
 	if err != nil {
 		_, _ = w.WriteString("<!-- mermaid render error: ")
 		_, _ = w.WriteString(err.Error())
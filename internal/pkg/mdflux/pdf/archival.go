@@ -0,0 +1,581 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// ArchivalPDFA2B selects PDF/A-2b (ISO 19005-2, basic conformance).
+const ArchivalPDFA2B = "pdf/a-2b"
+
+const pdfaidNS = "http://www.aiim.org/pdfa/ns/id/"
+
+// ParseArchival normalizes an archival level. The empty string disables
+// archival post-processing.
+func ParseArchival(level string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "":
+		return "", nil
+	case "pdf/a-2b", "pdfa-2b", "pdf/a2b":
+		return ArchivalPDFA2B, nil
+	default:
+		return "", fmt.Errorf("unsupported archival level %q (use pdf/a-2b)", level)
+	}
+}
+
+// ArchivalReport lists the changes made to a document to reach conformance
+// and the violations that remain.
+type ArchivalReport struct {
+	Fixed  []string
+	Issues []string
+}
+
+// OK reports whether no violations remain.
+func (r *ArchivalReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *ArchivalReport) String() string {
+	var b strings.Builder
+	for _, f := range r.Fixed {
+		b.WriteString("fixed: " + f + "\n")
+	}
+	for _, i := range r.Issues {
+		b.WriteString("issue: " + i + "\n")
+	}
+	return b.String()
+}
+
+// ArchivalError is returned when a written PDF does not conform to the
+// requested archival level. The document is still written so that it can
+// be inspected.
+type ArchivalError struct {
+	Level  string
+	Report *ArchivalReport
+}
+
+func (e *ArchivalError) Error() string {
+	return fmt.Sprintf("output is not %s conformant: %s", e.Level, strings.Join(e.Report.Issues, "; "))
+}
+
+func (r *ArchivalReport) issuef(format string, args ...interface{}) {
+	r.Issues = append(r.Issues, fmt.Sprintf(format, args...))
+}
+
+// ConvertPDFA2B turns a PDF into PDF/A-2b: it embeds an sRGB output intent,
+// writes md together with the PDF/A identification as XMP, makes
+// annotations printable and drops image interpolation. The written document
+// is validated with ValidatePDFA2B; violations that cannot be repaired
+// automatically, such as fonts that are not embedded, are returned in the
+// report rather than as an error.
+func ConvertPDFA2B(pdf []byte, md Metadata) ([]byte, *ArchivalReport, error) {
+	// PDF/A requires a single author entry that matches the information
+	// dictionary.
+	if len(md.Author) > 1 {
+		md.Author = []string{strings.Join(md.Author, ", ")}
+	}
+	extra := "<pdfaid:part xmlns:pdfaid=\"" + pdfaidNS + "\">2</pdfaid:part>\n" +
+		"<pdfaid:conformance xmlns:pdfaid=\"" + pdfaidNS + "\">B</pdfaid:conformance>\n"
+
+	ctx, err := readContext(pdf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fixed, err := repairPDFA(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := addOutputIntent(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// The information dictionary dates must equal the XMP dates, which
+	// have a resolution of seconds.
+	modified := time.Now().Truncate(time.Second)
+	if err := setMetadata(ctx, md, modified, extra); err != nil {
+		return nil, nil, err
+	}
+	out, err := writeMetadataContext(ctx, md.creationDate(modified), modified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	written, err := readContext(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := validatePDFA2B(written)
+	report.Fixed = fixed
+	return out, report, nil
+}
+
+// ValidatePDFA2B checks pdf against the PDF/A-2b requirements that apply to
+// documents produced by a browser. It runs offline and does not replace a
+// full validator such as veraPDF, but catches the usual blockers.
+func ValidatePDFA2B(pdf []byte) (*ArchivalReport, error) {
+	ctx, err := readContext(pdf)
+	if err != nil {
+		return nil, err
+	}
+	return validatePDFA2B(ctx), nil
+}
+
+// repairPDFA fixes violations that can be corrected without changing the
+// rendered pages.
+func repairPDFA(ctx *model.Context) ([]string, error) {
+	var fixed []string
+
+	annots, err := annotations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, a := range annots {
+		if a.Subtype() != nil && *a.Subtype() == "Popup" {
+			continue
+		}
+		flags := 0
+		if f := a.IntEntry("F"); f != nil {
+			flags = *f
+		}
+		// Set Print, clear Invisible, Hidden and NoView.
+		if want := (flags | 4) &^ (1 | 2 | 32); want != flags {
+			a.Update("F", types.Integer(want))
+			count++
+		}
+	}
+	if count > 0 {
+		fixed = append(fixed, fmt.Sprintf("set the print flag on %d annotations", count))
+	}
+
+	count = 0
+	forEachDict(ctx, func(d types.Dict) {
+		if b := d.BooleanEntry("Interpolate"); b != nil {
+			d.Delete("Interpolate")
+			if *b {
+				count++
+			}
+		}
+	})
+	if count > 0 {
+		fixed = append(fixed, fmt.Sprintf("disabled interpolation on %d images", count))
+	}
+
+	return fixed, nil
+}
+
+// addOutputIntent replaces the output intents with the embedded sRGB
+// profile.
+func addOutputIntent(ctx *model.Context) error {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(sRGBProfile())
+	if err != nil {
+		return fmt.Errorf("failed to create ICC profile: %w", err)
+	}
+	sd.InsertInt("N", 3)
+	if err := sd.Encode(); err != nil {
+		return fmt.Errorf("failed to encode ICC profile: %w", err)
+	}
+	profile, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return fmt.Errorf("failed to add ICC profile: %w", err)
+	}
+
+	intent := types.NewDict()
+	intent.InsertName("Type", "OutputIntent")
+	intent.InsertName("S", "GTS_PDFA1")
+	intent.Insert("OutputConditionIdentifier", types.StringLiteral(sRGBProfileName))
+	intent.Insert("Info", types.StringLiteral(sRGBProfileName))
+	intent.Insert("RegistryName", types.StringLiteral("http://www.color.org"))
+	intent.Insert("DestOutputProfile", *profile)
+	catalog.Update("OutputIntents", types.Array{intent})
+	return nil
+}
+
+// forbiddenActions may not appear in PDF/A-2 documents.
+var forbiddenActions = map[string]bool{
+	"Launch": true, "Sound": true, "Movie": true, "ResetForm": true,
+	"ImportData": true, "JavaScript": true, "Hide": true,
+	"SetOCGState": true, "Rendition": true, "Trans": true, "GoTo3DView": true,
+}
+
+// forbiddenAnnotations may not appear in PDF/A-2 documents.
+var forbiddenAnnotations = map[string]bool{
+	"Sound": true, "Movie": true, "Screen": true, "3D": true, "RichMedia": true,
+}
+
+func validatePDFA2B(ctx *model.Context) *ArchivalReport {
+	r := &ArchivalReport{}
+
+	if ctx.Encrypt != nil {
+		r.issuef("document is encrypted")
+	}
+	if len(ctx.ID) != 2 {
+		r.issuef("trailer has no file identifier")
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		r.issuef("catalog is unreadable: %v", err)
+		return r
+	}
+	checkOutputIntent(ctx, catalog, r)
+	checkXMP(ctx, catalog, r)
+
+	if names, _ := ctx.DereferenceDict(catalog["Names"]); names != nil {
+		if _, ok := names.Find("JavaScript"); ok {
+			r.issuef("document contains JavaScript")
+		}
+		if _, ok := names.Find("EmbeddedFiles"); ok {
+			r.issuef("document has attachments, which must themselves be PDF/A")
+		}
+	}
+	if af, _ := ctx.DereferenceDict(catalog["AcroForm"]); af != nil {
+		if b := af.BooleanEntry("NeedAppearances"); b != nil && *b {
+			r.issuef("form fields have no appearance streams (NeedAppearances)")
+		}
+	}
+
+	annots, err := annotations(ctx)
+	if err != nil {
+		r.issuef("annotations are unreadable: %v", err)
+	}
+	for _, a := range annots {
+		subtype := ""
+		if s := a.Subtype(); s != nil {
+			subtype = *s
+		}
+		if forbiddenAnnotations[subtype] {
+			r.issuef("forbidden %s annotation", subtype)
+			continue
+		}
+		if subtype == "Popup" {
+			continue
+		}
+		flags := 0
+		if f := a.IntEntry("F"); f != nil {
+			flags = *f
+		}
+		if flags&4 == 0 || flags&(1|2|32) != 0 {
+			r.issuef("%s annotation is not printable", subtype)
+		}
+	}
+
+	var unembedded []string
+	seen := map[string]bool{}
+	problems := map[string]bool{}
+	forEachDict(ctx, func(d types.Dict) {
+		if t := d.Type(); t != nil && *t == "Font" {
+			if name, ok := fontNotEmbedded(ctx, d); ok && !seen[name] {
+				seen[name] = true
+				unembedded = append(unembedded, name)
+			}
+		}
+		if s := d.NameEntry("S"); s != nil && forbiddenActions[*s] {
+			problems[fmt.Sprintf("forbidden %s action", *s)] = true
+		}
+		if b := d.BooleanEntry("Interpolate"); b != nil && *b {
+			problems["image interpolation is enabled"] = true
+		}
+		if _, ok := d.Find("OPI"); ok {
+			problems["OPI references to external images"] = true
+		}
+		if _, ok := d.Find("Alternates"); ok {
+			problems["alternate images"] = true
+		}
+		if _, ok := d.Find("FFilter"); ok {
+			problems["stream data in external files"] = true
+		}
+		if f := d.NameEntry("Filter"); f != nil && *f == "LZWDecode" {
+			problems["LZW compressed streams"] = true
+		}
+	})
+	if len(unembedded) > 0 {
+		sort.Strings(unembedded)
+		r.issuef("fonts not embedded: %s", strings.Join(unembedded, ", "))
+	}
+	var sorted []string
+	for p := range problems {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, p := range sorted {
+		r.issuef("%s", p)
+	}
+
+	return r
+}
+
+func checkOutputIntent(ctx *model.Context, catalog types.Dict, r *ArchivalReport) {
+	intents, err := ctx.DereferenceArray(catalog["OutputIntents"])
+	if err != nil || len(intents) == 0 {
+		r.issuef("no output intent; device colors and transparency require one")
+		return
+	}
+	for _, o := range intents {
+		intent, err := ctx.DereferenceDict(o)
+		if err != nil || intent == nil {
+			continue
+		}
+		if s := intent.NameEntry("S"); s == nil || *s != "GTS_PDFA1" {
+			continue
+		}
+		sd, _, err := ctx.DereferenceStreamDict(intent["DestOutputProfile"])
+		if err != nil || sd == nil {
+			r.issuef("PDF/A output intent has no ICC profile")
+			return
+		}
+		if err := sd.Decode(); err != nil || len(sd.Content) < 128 {
+			r.issuef("PDF/A output intent ICC profile is unreadable")
+			return
+		}
+		n := sd.IntEntry("N")
+		components := map[string]int{"GRAY": 1, "RGB ": 3, "CMYK": 4}[string(sd.Content[16:20])]
+		if n == nil || *n != components {
+			r.issuef("PDF/A output intent ICC profile does not match its N entry")
+		}
+		return
+	}
+	r.issuef("no GTS_PDFA1 output intent")
+}
+
+func checkXMP(ctx *model.Context, catalog types.Dict, r *ArchivalReport) {
+	sd, _, err := ctx.DereferenceStreamDict(catalog["Metadata"])
+	if err != nil || sd == nil {
+		r.issuef("no XMP metadata stream")
+		return
+	}
+	if _, ok := sd.Find("Filter"); ok {
+		r.issuef("XMP metadata stream is compressed")
+	}
+	if err := sd.Decode(); err != nil {
+		r.issuef("XMP metadata stream is unreadable: %v", err)
+		return
+	}
+	props, err := parseXMP(sd.Content)
+	if err != nil {
+		r.issuef("XMP metadata is not well-formed: %v", err)
+		return
+	}
+
+	if props[pdfaidNS+" part"] != "2" || !strings.EqualFold(props[pdfaidNS+" conformance"], "B") {
+		r.issuef("XMP does not identify the document as PDF/A-2b")
+	}
+
+	const (
+		dc  = "http://purl.org/dc/elements/1.1/ "
+		xmp = "http://ns.adobe.com/xap/1.0/ "
+		pdf = "http://ns.adobe.com/pdf/1.3/ "
+	)
+	for _, e := range []struct{ info, prop string }{
+		{"Title", dc + "title"},
+		{"Author", dc + "creator"},
+		{"Subject", dc + "description"},
+		{"Keywords", pdf + "Keywords"},
+		{"Creator", xmp + "CreatorTool"},
+		{"Producer", pdf + "Producer"},
+	} {
+		if got, want := props[e.prop], infoText(ctx, e.info); got != want {
+			r.issuef("information dictionary %s %q does not match XMP %q", e.info, want, got)
+		}
+	}
+	for _, e := range []struct{ info, prop string }{
+		{"CreationDate", xmp + "CreateDate"},
+		{"ModDate", xmp + "ModifyDate"},
+	} {
+		info := infoText(ctx, e.info)
+		if info == "" {
+			continue
+		}
+		it, ok := types.DateTime(info, true)
+		xt, err := time.Parse(time.RFC3339, props[e.prop])
+		if !ok || err != nil || !it.Equal(xt) {
+			r.issuef("information dictionary %s does not match XMP", e.info)
+		}
+	}
+}
+
+// parseXMP collects the properties of an XMP packet keyed by namespace and
+// name separated by a space. Array values are joined with ", ". Properties
+// in attribute form are included.
+func parseXMP(b []byte) (map[string]string, error) {
+	props := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	const rdf = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+	var stack []xml.Name
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return props, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == rdf && t.Name.Local == "Description" {
+				for _, a := range t.Attr {
+					if a.Name.Space != rdf && a.Name.Space != "xmlns" && a.Name.Space != "" {
+						props[a.Name.Space+" "+a.Name.Local] = a.Value
+					}
+				}
+			}
+			stack = append(stack, t.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			prop := t.Name
+			if t.Name.Space == rdf {
+				if t.Name.Local != "li" {
+					continue
+				}
+				// Property element enclosing rdf:Seq/Bag/Alt.
+				if len(stack) < 2 {
+					continue
+				}
+				prop = stack[len(stack)-2]
+			} else if len(stack) == 0 || stack[len(stack)-1].Local != "Description" {
+				continue
+			}
+			key := prop.Space + " " + prop.Local
+			if value == "" {
+				continue
+			}
+			if props[key] != "" && t.Name.Space == rdf {
+				props[key] += ", " + value
+			} else {
+				props[key] = value
+			}
+		}
+	}
+}
+
+// infoText returns a decoded information dictionary entry.
+func infoText(ctx *model.Context, key string) string {
+	if ctx.Info == nil {
+		return ""
+	}
+	info, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || info == nil {
+		return ""
+	}
+	o, ok := info.Find(key)
+	if !ok {
+		return ""
+	}
+	s, err := ctx.DereferenceText(o)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// fontNotEmbedded reports the base font name of d if neither it nor its
+// descendant font has an embedded font program. Type 3 fonts are defined
+// by content streams and always count as embedded.
+func fontNotEmbedded(ctx *model.Context, d types.Dict) (string, bool) {
+	name := "unnamed"
+	if n := d.NameEntry("BaseFont"); n != nil {
+		name = *n
+	}
+	subtype := d.Subtype()
+	if subtype != nil && *subtype == "Type3" {
+		return "", false
+	}
+	if subtype != nil && *subtype == "Type0" {
+		descendants, err := ctx.DereferenceArray(d["DescendantFonts"])
+		if err != nil || len(descendants) == 0 {
+			return name, true
+		}
+		if d, err = ctx.DereferenceDict(descendants[0]); err != nil || d == nil {
+			return name, true
+		}
+	}
+	fd, err := ctx.DereferenceDict(d["FontDescriptor"])
+	if err != nil || fd == nil {
+		return name, true
+	}
+	for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+		if _, ok := fd.Find(key); ok {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// annotations returns the annotation dictionaries of all pages.
+func annotations(ctx *model.Context) ([]types.Dict, error) {
+	var annots []types.Dict
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, _, err := ctx.PageDict(i, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", i, err)
+		}
+		if page == nil {
+			continue
+		}
+		arr, err := ctx.DereferenceArray(page["Annots"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read annotations of page %d: %w", i, err)
+		}
+		for _, o := range arr {
+			a, err := ctx.DereferenceDict(o)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read annotation on page %d: %w", i, err)
+			}
+			if a != nil {
+				annots = append(annots, a)
+			}
+		}
+	}
+	return annots, nil
+}
+
+// forEachDict calls fn for every dictionary and stream dictionary in the
+// document, including nested direct dictionaries.
+func forEachDict(ctx *model.Context, fn func(types.Dict)) {
+	var walk func(o types.Object)
+	walk = func(o types.Object) {
+		switch o := o.(type) {
+		case types.Dict:
+			fn(o)
+			for _, v := range o {
+				walk(v)
+			}
+		case types.StreamDict:
+			fn(o.Dict)
+			for _, v := range o.Dict {
+				walk(v)
+			}
+		case types.Array:
+			for _, v := range o {
+				walk(v)
+			}
+		}
+	}
+	for _, e := range ctx.Table {
+		if e == nil || e.Free || e.Object == nil {
+			continue
+		}
+		walk(e.Object)
+	}
+}
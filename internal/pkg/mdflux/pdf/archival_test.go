@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testdata/transparency.pdf has a page with a half transparent fill, an
+// interpolated image with a soft mask and text in a standard Helvetica
+// font that is not embedded.
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	pdf, err := os.ReadFile("testdata/transparency.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pdf
+}
+
+func TestValidatePDFA2B(t *testing.T) {
+	report, err := ValidatePDFA2B(readFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"no output intent; device colors and transparency require one",
+		"no XMP metadata stream",
+		"fonts not embedded: Helvetica",
+		"image interpolation is enabled",
+	}
+	if !reflect.DeepEqual(report.Issues, want) {
+		t.Errorf("issues = %q, want %q", report.Issues, want)
+	}
+	if report.OK() {
+		t.Error("OK() = true for a document with issues")
+	}
+}
+
+func TestConvertPDFA2B(t *testing.T) {
+	out, report, err := ConvertPDFA2B(readFixture(t), Metadata{Title: "Fixture", Author: []string{"A", "B"}, Lang: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"disabled interpolation on 1 images"}; !reflect.DeepEqual(report.Fixed, want) {
+		t.Errorf("fixed = %q, want %q", report.Fixed, want)
+	}
+	// Transparency is allowed once there is an output intent, but fonts
+	// cannot be embedded afterwards.
+	want := []string{"fonts not embedded: Helvetica"}
+	if !reflect.DeepEqual(report.Issues, want) {
+		t.Errorf("issues = %q, want %q", report.Issues, want)
+	}
+
+	again, err := ValidatePDFA2B(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Issues, want) {
+		t.Errorf("issues after conversion = %q, want %q", again.Issues, want)
+	}
+}
@@ -0,0 +1,53 @@
+package pdf
+
+import (
+	"errors"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Encryption protects a PDF with AES-256. The user password is needed to
+// open the document and may be empty; the owner password lifts the
+// permission restrictions and is required.
+type Encryption struct {
+	UserPassword  string
+	OwnerPassword string
+	AllowPrint    bool
+	AllowCopy     bool
+	AllowModify   bool
+	AllowAnnotate bool
+}
+
+// permissions returns the access permission flags of e.
+func (e Encryption) permissions() model.PermissionFlags {
+	p := model.PermissionsNone
+	if e.AllowPrint {
+		p |= model.PermissionPrintRev2 | model.PermissionPrintRev3
+	}
+	if e.AllowCopy {
+		p |= model.PermissionExtract | model.PermissionExtractRev3
+	}
+	if e.AllowModify {
+		p |= model.PermissionModify | model.PermissionAssembleRev3
+	}
+	if e.AllowAnnotate {
+		p |= model.PermissionModAnnFillForm | model.PermissionFillRev3
+	}
+	return p
+}
+
+// apply sets up ctx to be encrypted with AES-256 and the permissions of e
+// when it is written.
+func (e Encryption) apply(ctx *model.Context) error {
+	if e.OwnerPassword == "" {
+		return errors.New("an owner password is required")
+	}
+
+	ctx.Cmd = model.ENCRYPT
+	ctx.UserPW = e.UserPassword
+	ctx.OwnerPW = e.OwnerPassword
+	ctx.EncryptUsingAES = true
+	ctx.EncryptKeyLength = 256
+	ctx.Permissions = e.permissions()
+	return nil
+}
@@ -0,0 +1,120 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// sRGBProfileName identifies the output condition of the embedded profile.
+const sRGBProfileName = "sRGB IEC61966-2.1"
+
+// sRGBProfile builds a compact ICC v2 display profile for sRGB: D50-adapted
+// primaries and a sampled sRGB tone curve. It is used as the PDF/A output
+// intent, which only needs a valid RGB profile to anchor device colors.
+func sRGBProfile() []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+
+	trc := curvTag(256, func(v float64) float64 {
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	})
+	tags := []tag{
+		{"desc", descTag(sRGBProfileName)},
+		{"cprt", textTag("No copyright, use freely")},
+		{"wtpt", xyzTag(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyzTag(0.4360747, 0.2225045, 0.0139322)},
+		{"gXYZ", xyzTag(0.3850649, 0.7168786, 0.0971045)},
+		{"bXYZ", xyzTag(0.1430804, 0.0606169, 0.7141733)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	var table, data bytes.Buffer
+	be := binary.BigEndian
+	offset := 128 + 4 + 12*len(tags)
+	offsets := map[string]int{}
+	_ = binary.Write(&table, be, uint32(len(tags)))
+	for _, t := range tags {
+		// Tags with identical data share one element.
+		key := string(t.data)
+		off, ok := offsets[key]
+		if !ok {
+			off = offset + data.Len()
+			offsets[key] = off
+			data.Write(t.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(t.sig)
+		_ = binary.Write(&table, be, uint32(off))
+		_ = binary.Write(&table, be, uint32(len(t.data)))
+	}
+
+	header := make([]byte, 128)
+	size := len(header) + table.Len() + data.Len()
+	be.PutUint32(header[0:], uint32(size))
+	be.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	for i, v := range []uint16{2000, 1, 1, 0, 0, 0} {
+		be.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	for i, v := range []float64{0.9642, 1.0, 0.8249} {
+		be.PutUint32(header[68+4*i:], s15Fixed16(v))
+	}
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header...)
+	profile = append(profile, table.Bytes()...)
+	return append(profile, data.Bytes()...)
+}
+
+func s15Fixed16(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+func xyzTag(x, y, z float64) []byte {
+	b := make([]byte, 20)
+	copy(b, "XYZ ")
+	for i, v := range []float64{x, y, z} {
+		binary.BigEndian.PutUint32(b[8+4*i:], s15Fixed16(v))
+	}
+	return b
+}
+
+func textTag(s string) []byte {
+	b := make([]byte, 8, 8+len(s)+1)
+	copy(b, "text")
+	return append(append(b, s...), 0)
+}
+
+// descTag encodes a v2 textDescriptionType with an ASCII description and
+// empty Unicode and ScriptCode descriptions.
+func descTag(s string) []byte {
+	b := make([]byte, 12, 12+len(s)+1+8+3+67)
+	copy(b, "desc")
+	binary.BigEndian.PutUint32(b[8:], uint32(len(s)+1))
+	b = append(append(b, s...), 0)
+	return append(b, make([]byte, 8+3+67)...)
+}
+
+func curvTag(n int, f func(float64) float64) []byte {
+	b := make([]byte, 12+2*n)
+	copy(b, "curv")
+	binary.BigEndian.PutUint32(b[8:], uint32(n))
+	for i := 0; i < n; i++ {
+		v := f(float64(i) / float64(n-1))
+		binary.BigEndian.PutUint16(b[12+2*i:], uint16(math.Round(v*65535)))
+	}
+	return b
+}
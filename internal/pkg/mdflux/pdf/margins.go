@@ -0,0 +1,100 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultMargin = 0.5
+
+// marginPresets holds the top, right, bottom and left margins in inches of
+// the named presets.
+var marginPresets = map[string][4]float64{
+	"narrow": {0.25, 0.25, 0.25, 0.25},
+	"normal": {1, 1, 1, 1},
+	"wide":   {1, 2, 1, 2},
+}
+
+// Margins describes page margins as configured. Each side is a length with
+// a unit ("20mm", "1.5cm", "0.75in", "36pt") or a bare number of inches.
+// Empty sides fall back to the preset, and without a preset to 0.5in.
+// Setting Inner or Outer mirrors the margins for double-sided printing:
+// Inner is the binding edge, left on right-hand pages and right on
+// left-hand pages.
+type Margins struct {
+	Preset string
+	Top    string
+	Bottom string
+	Left   string
+	Right  string
+	Inner  string
+	Outer  string
+}
+
+// SetMargins normalizes m to inches and stores it in o.
+func (o *Options) SetMargins(m Margins) error {
+	sides := [4]float64{defaultMargin, defaultMargin, defaultMargin, defaultMargin}
+	if m.Preset != "" {
+		preset, ok := marginPresets[strings.ToLower(m.Preset)]
+		if !ok {
+			return fmt.Errorf("unknown margin preset %q (use narrow, normal or wide)", m.Preset)
+		}
+		sides = preset
+	}
+
+	inner, outer := m.Left, m.Right
+	o.MirrorMargins = m.Inner != "" || m.Outer != ""
+	if o.MirrorMargins {
+		inner, outer = m.Inner, m.Outer
+	}
+
+	for i, v := range []string{m.Top, outer, m.Bottom, inner} {
+		if v == "" {
+			continue
+		}
+		length, err := ParseMargin(v)
+		if err != nil {
+			return err
+		}
+		sides[i] = length
+	}
+
+	o.MarginTop, o.MarginRight, o.MarginBottom, o.MarginLeft = sides[0], sides[1], sides[2], sides[3]
+	return nil
+}
+
+// ParseMargin converts a margin to inches. Bare numbers are inches.
+func ParseMargin(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		if v < 0 {
+			return 0, fmt.Errorf("invalid margin %q: must not be negative", s)
+		}
+		return v, nil
+	}
+	v, err := ParseLength(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid margin: %w", err)
+	}
+	return v, nil
+}
+
+// marginCSS returns @page rules that swap the left and right margins on
+// left-hand pages when the margins are mirrored. MarginLeft holds the inner
+// margin and the first page is a right-hand page.
+func (o Options) marginCSS() string {
+	if !o.MirrorMargins {
+		return ""
+	}
+	inner := strconv.FormatFloat(o.MarginLeft, 'f', 4, 64) + "in"
+	outer := strconv.FormatFloat(o.MarginRight, 'f', 4, 64) + "in"
+	return "\n@page :right { margin-left: " + inner + "; margin-right: " + outer + "; }\n" +
+		"@page :left { margin-left: " + outer + "; margin-right: " + inner + "; }\n"
+}
+
+// PrintCSS returns the print stylesheet rules derived from o: pagination
+// and mirrored margins.
+func (o Options) PrintCSS() string {
+	return o.Pagination.CSS() + o.marginCSS()
+}
@@ -0,0 +1,104 @@
+package pdf
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSetMargins(t *testing.T) {
+	tests := []struct {
+		name    string
+		margins Margins
+		// top, right, bottom, left in inches
+		want   [4]float64
+		mirror bool
+	}{
+		{name: "default", want: [4]float64{0.5, 0.5, 0.5, 0.5}},
+		{name: "narrow", margins: Margins{Preset: "narrow"}, want: [4]float64{0.25, 0.25, 0.25, 0.25}},
+		{name: "normal", margins: Margins{Preset: "Normal"}, want: [4]float64{1, 1, 1, 1}},
+		{name: "wide", margins: Margins{Preset: "wide"}, want: [4]float64{1, 2, 1, 2}},
+		{
+			name:    "sides override the preset",
+			margins: Margins{Preset: "wide", Top: "20mm", Left: "36pt"},
+			want:    [4]float64{20 / 25.4, 2, 1, 0.5},
+		},
+		{
+			name:    "bare numbers are inches",
+			margins: Margins{Top: "1.5", Bottom: "0"},
+			want:    [4]float64{1.5, 0.5, 0, 0.5},
+		},
+		{
+			name:    "inner and outer",
+			margins: Margins{Left: "3in", Right: "3in", Inner: "2cm", Outer: "1cm"},
+			want:    [4]float64{0.5, 1 / 2.54, 0.5, 2 / 2.54},
+			mirror:  true,
+		},
+		{
+			name:    "inner alone keeps the preset outer margin",
+			margins: Margins{Preset: "wide", Inner: "1in"},
+			want:    [4]float64{1, 2, 1, 1},
+			mirror:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultOptions()
+			if err := o.SetMargins(tt.margins); err != nil {
+				t.Fatal(err)
+			}
+			got := [4]float64{o.MarginTop, o.MarginRight, o.MarginBottom, o.MarginLeft}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("margins = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			if o.MirrorMargins != tt.mirror {
+				t.Errorf("MirrorMargins = %v, want %v", o.MirrorMargins, tt.mirror)
+			}
+		})
+	}
+}
+
+func TestSetMarginsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		margins Margins
+		want    string
+	}{
+		{name: "unknown preset", margins: Margins{Preset: "tiny"}, want: `unknown margin preset "tiny"`},
+		{name: "negative", margins: Margins{Top: "-1"}, want: "must not be negative"},
+		{name: "unknown unit", margins: Margins{Left: "2px"}, want: `invalid length "2px"`},
+		{name: "invalid inner", margins: Margins{Inner: "wide"}, want: `invalid length "wide"`},
+		{name: "negative outer", margins: Margins{Inner: "1in", Outer: "-1"}, want: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultOptions()
+			err := o.SetMargins(tt.margins)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarginCSS(t *testing.T) {
+	o := DefaultOptions()
+	if css := o.marginCSS(); css != "" {
+		t.Errorf("marginCSS() = %q without mirrored margins", css)
+	}
+	if err := o.SetMargins(Margins{Inner: "1in", Outer: "0.5in"}); err != nil {
+		t.Fatal(err)
+	}
+	css := o.marginCSS()
+	for _, want := range []string{
+		"@page :right { margin-left: 1.0000in; margin-right: 0.5000in; }",
+		"@page :left { margin-left: 0.5000in; margin-right: 1.0000in; }",
+	} {
+		if !strings.Contains(css, want) {
+			t.Errorf("marginCSS() = %q, missing %q", css, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Part is one document of a merged PDF.
+type Part struct {
+	// Title is the bookmark that holds the outline of the part. Without a
+	// title the outline of the part is kept at the top level.
+	Title string
+	// Front marks front matter such as a cover page. Leading front parts
+	// are numbered with lower-case roman numerals, the page numbers of the
+	// remaining parts start at 1 and run on across parts.
+	Front bool
+	PDF   []byte
+}
+
+// section records where a part ended up in the merged document.
+type section struct {
+	title    string
+	outlines types.Object
+	first    int
+}
+
+// Merge concatenates parts into one PDF. The outlines of the parts keep
+// their destinations and are combined into one outline, and page labels
+// number the pages consistently across parts.
+func Merge(parts []Part) ([]byte, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("nothing to merge")
+	}
+
+	var dest *model.Context
+	var sections []section
+	frontPages := 0
+	front := true
+	for i, part := range parts {
+		ctx, err := readContext(part.PDF)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", i+1, err)
+		}
+		// The catalog is patched in place while merging, so its entries
+		// refer to the merged objects afterwards.
+		catalog, err := ctx.Catalog()
+		if err != nil {
+			return nil, fmt.Errorf("part %d: failed to read catalog: %w", i+1, err)
+		}
+
+		first := 1
+		if dest == nil {
+			dest = ctx
+			dest.Configuration.Cmd = model.MERGECREATE
+			dest.Configuration.CreateBookmarks = false
+			dest.EnsureVersionForWriting()
+		} else {
+			if dest.XRefTable.Version() < model.V20 && ctx.XRefTable.Version() == model.V20 {
+				return nil, fmt.Errorf("part %d: PDF 2.0 cannot be merged into an older document", i+1)
+			}
+			first = dest.PageCount + 1
+			if err := pdfcpu.MergeXRefTables(part.Title, ctx, dest, false, false); err != nil {
+				return nil, fmt.Errorf("part %d: failed to merge: %w", i+1, err)
+			}
+		}
+
+		sections = append(sections, section{title: part.Title, outlines: catalog["Outlines"], first: first})
+		front = front && part.Front
+		if front {
+			frontPages = dest.PageCount
+		}
+	}
+
+	if err := mergeOutlines(dest, sections); err != nil {
+		return nil, err
+	}
+	if err := setPageLabels(dest, frontPages); err != nil {
+		return nil, err
+	}
+
+	return writeContext(dest)
+}
+
+// mergeOutlines replaces the outline of ctx with the outlines of sections.
+// Items of titled sections are nested under a closed bookmark pointing at
+// the first page of the section.
+func mergeOutlines(ctx *model.Context, sections []section) error {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	root := types.Dict{"Type": types.Name("Outlines")}
+	rootRef, err := ctx.IndRefForNewObject(root)
+	if err != nil {
+		return fmt.Errorf("failed to add outline: %w", err)
+	}
+
+	var top []types.IndirectRef
+	visible := 0
+	for _, s := range sections {
+		items, err := outlineItems(ctx, s.outlines)
+		if err != nil {
+			return err
+		}
+
+		if s.title == "" {
+			for _, ref := range items {
+				top = append(top, ref)
+				visible++
+				if d, _ := ctx.DereferenceDict(ref); d != nil {
+					if c := d.IntEntry("Count"); c != nil && *c > 0 {
+						visible += *c
+					}
+				}
+			}
+			continue
+		}
+
+		page, err := ctx.PageDictIndRef(s.first)
+		if err != nil || page == nil {
+			return fmt.Errorf("failed to locate page %d: %w", s.first, err)
+		}
+		title, err := types.EscapedUTF16String(s.title)
+		if err != nil {
+			return fmt.Errorf("failed to encode bookmark %q: %w", s.title, err)
+		}
+		item := types.Dict{
+			"Title": types.StringLiteral(*title),
+			"Dest":  types.Array{*page, types.Name("Fit")},
+		}
+		ref, err := ctx.IndRefForNewObject(item)
+		if err != nil {
+			return fmt.Errorf("failed to add bookmark %q: %w", s.title, err)
+		}
+		if len(items) > 0 {
+			if err := linkOutlineItems(ctx, *ref, items); err != nil {
+				return err
+			}
+			item["First"] = items[0]
+			item["Last"] = items[len(items)-1]
+			item["Count"] = types.Integer(-len(items))
+		}
+		top = append(top, *ref)
+		visible++
+	}
+
+	if len(top) == 0 {
+		delete(catalog, "Outlines")
+		return nil
+	}
+	if err := linkOutlineItems(ctx, *rootRef, top); err != nil {
+		return err
+	}
+	root["First"] = top[0]
+	root["Last"] = top[len(top)-1]
+	root["Count"] = types.Integer(visible)
+	catalog["Outlines"] = *rootRef
+	return nil
+}
+
+// outlineItems returns the top-level items of an outline dictionary.
+func outlineItems(ctx *model.Context, outlines types.Object) ([]types.IndirectRef, error) {
+	if outlines == nil {
+		return nil, nil
+	}
+	d, err := ctx.DereferenceDict(outlines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outline: %w", err)
+	}
+	if d == nil {
+		return nil, nil
+	}
+
+	var items []types.IndirectRef
+	seen := map[int]bool{}
+	for ref := d.IndirectRefEntry("First"); ref != nil; ref = d.IndirectRefEntry("Next") {
+		if seen[ref.ObjectNumber.Value()] {
+			return nil, errors.New("outline items form a cycle")
+		}
+		seen[ref.ObjectNumber.Value()] = true
+		items = append(items, *ref)
+		if d, err = ctx.DereferenceDict(*ref); err != nil || d == nil {
+			return nil, fmt.Errorf("failed to read outline item: %w", err)
+		}
+	}
+	return items, nil
+}
+
+// linkOutlineItems makes items the children of parent, in order.
+func linkOutlineItems(ctx *model.Context, parent types.IndirectRef, items []types.IndirectRef) error {
+	for i, ref := range items {
+		d, err := ctx.DereferenceDict(ref)
+		if err != nil || d == nil {
+			return fmt.Errorf("failed to read outline item: %w", err)
+		}
+		d["Parent"] = parent
+		delete(d, "Prev")
+		delete(d, "Next")
+		if i > 0 {
+			d["Prev"] = items[i-1]
+		}
+		if i < len(items)-1 {
+			d["Next"] = items[i+1]
+		}
+	}
+	return nil
+}
+
+// setPageLabels numbers the first frontPages pages i, ii, ... and the
+// remaining pages from 1.
+func setPageLabels(ctx *model.Context, frontPages int) error {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var nums types.Array
+	if frontPages > 0 {
+		nums = append(nums, types.Integer(0), types.Dict{"S": types.Name("r")})
+	}
+	if frontPages < ctx.PageCount {
+		nums = append(nums, types.Integer(frontPages), types.Dict{"S": types.Name("D")})
+	}
+	ref, err := ctx.IndRefForNewObject(types.Dict{"Nums": nums})
+	if err != nil {
+		return fmt.Errorf("failed to add page labels: %w", err)
+	}
+	catalog["PageLabels"] = *ref
+	return nil
+}
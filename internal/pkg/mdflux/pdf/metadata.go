@@ -0,0 +1,271 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Metadata is written to the document information dictionary and as an
+// XMP metadata stream.
+type Metadata struct {
+	Title        string
+	Author       []string
+	Subject      string
+	Keywords     []string
+	Lang         string
+	Creator      string
+	CreationDate time.Time
+}
+
+var disableConfigDir sync.Once
+
+// pdfcpuConfig returns a relaxed pdfcpu configuration that does not touch
+// the user's pdfcpu config directory.
+func pdfcpuConfig() *model.Configuration {
+	disableConfigDir.Do(api.DisableConfigDir)
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// readContext parses a PDF for post-processing.
+func readContext(pdf []byte) (*model.Context, error) {
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), pdfcpuConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	return ctx, nil
+}
+
+// writeContext serializes a post-processed PDF.
+func writeContext(ctx *model.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := api.WriteContext(ctx, &buf); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteMetadata sets the information dictionary entries and the XMP
+// metadata stream of pdf and returns the updated document, encrypted with
+// enc unless it is nil.
+func WriteMetadata(pdf []byte, md Metadata, enc *Encryption) ([]byte, error) {
+	ctx, err := readContext(pdf)
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		if err := enc.apply(ctx); err != nil {
+			return nil, fmt.Errorf("failed to encrypt PDF: %w", err)
+		}
+	}
+	modified := time.Now()
+	if err := setMetadata(ctx, md, modified, ""); err != nil {
+		return nil, err
+	}
+	return writeMetadataContext(ctx, md.creationDate(modified), modified)
+}
+
+// creationDate returns the creation date of md, or modified when it has
+// none.
+func (md Metadata) creationDate(modified time.Time) time.Time {
+	if md.CreationDate.IsZero() {
+		return modified
+	}
+	return md.CreationDate
+}
+
+// setMetadata updates ctx in place. extraXMP is inserted into the XMP
+// description, e.g. a PDF/A identification schema. The information
+// dictionary dates are set by writeMetadataContext, which must be given
+// the same modified time.
+func setMetadata(ctx *model.Context, md Metadata, modified time.Time, extraXMP string) error {
+	md.CreationDate = md.creationDate(modified)
+
+	info := types.NewDict()
+	if ctx.Info != nil {
+		d, err := ctx.DereferenceDict(*ctx.Info)
+		if err != nil {
+			return fmt.Errorf("failed to read info dictionary: %w", err)
+		}
+		if d != nil {
+			info = d
+		}
+	}
+
+	entries := map[string]string{
+		"Title":    md.Title,
+		"Author":   strings.Join(md.Author, ", "),
+		"Subject":  md.Subject,
+		"Keywords": strings.Join(md.Keywords, ", "),
+		"Creator":  md.Creator,
+	}
+	for key, value := range entries {
+		if value == "" {
+			continue
+		}
+		s, err := types.EscapedUTF16String(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		info.Update(key, types.StringLiteral(*s))
+	}
+
+	if ctx.Info == nil {
+		ir, err := ctx.IndRefForNewObject(info)
+		if err != nil {
+			return fmt.Errorf("failed to add info dictionary: %w", err)
+		}
+		ctx.Info = ir
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	sd := types.StreamDict{Dict: types.NewDict(), Content: []byte(xmpPacket(md, modified, extraXMP))}
+	sd.InsertName("Type", "Metadata")
+	sd.InsertName("Subtype", "XML")
+	if err := sd.Encode(); err != nil {
+		return fmt.Errorf("failed to encode XMP metadata: %w", err)
+	}
+	ir, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		return fmt.Errorf("failed to add XMP metadata: %w", err)
+	}
+	catalog.Update("Metadata", *ir)
+
+	if md.Lang != "" {
+		s, err := types.EscapedUTF16String(md.Lang)
+		if err != nil {
+			return fmt.Errorf("failed to encode Lang: %w", err)
+		}
+		catalog.Update("Lang", types.StringLiteral(*s))
+	}
+
+	return nil
+}
+
+// writeMetadataContext serializes ctx like writeContext, with the
+// information dictionary dates set to created and modified. pdfcpu stamps
+// CreationDate and ModDate with the current time when it prepares the
+// document for writing, before it writes the header; the dates are set on
+// the first write to the output, after that update and before the
+// dictionary is serialized and, if requested, encrypted.
+func writeMetadataContext(ctx *model.Context, created, modified time.Time) ([]byte, error) {
+	if ctx.Info == nil {
+		return nil, errors.New("failed to write PDF: no information dictionary")
+	}
+	var buf bytes.Buffer
+	w := &firstWriteHook{w: &buf, hook: func() error {
+		info, err := ctx.DereferenceDict(*ctx.Info)
+		if err != nil || info == nil {
+			return fmt.Errorf("failed to read info dictionary: %w", err)
+		}
+		info.Update("CreationDate", types.StringLiteral(types.DateString(created)))
+		info.Update("ModDate", types.StringLiteral(types.DateString(modified)))
+		return nil
+	}}
+	// Without buffering the header reaches w before any object is
+	// serialized.
+	ctx.Write.Writer = bufio.NewWriterSize(w, 1)
+	if err := pdfcpu.WriteContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+	if err := ctx.Write.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+	if !w.done {
+		return nil, errors.New("failed to write PDF: no output")
+	}
+	return buf.Bytes(), nil
+}
+
+// firstWriteHook calls hook before the first write to w.
+type firstWriteHook struct {
+	w    io.Writer
+	hook func() error
+	done bool
+}
+
+func (h *firstWriteHook) Write(p []byte) (int, error) {
+	if !h.done {
+		h.done = true
+		if err := h.hook(); err != nil {
+			return 0, err
+		}
+	}
+	return h.w.Write(p)
+}
+
+// xmpPacket renders md as an XMP packet. The producer matches the one the
+// PDF writer puts in the information dictionary so that both agree.
+func xmpPacket(md Metadata, modified time.Time, extra string) string {
+	esc := func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	created := md.CreationDate.Format(time.RFC3339)
+	date := modified.Format(time.RFC3339)
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString("<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("<rdf:Description rdf:about=\"\"\n")
+	b.WriteString("  xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("  xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("  xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\">\n")
+	b.WriteString("<dc:format>application/pdf</dc:format>\n")
+	if md.Title != "" {
+		b.WriteString("<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">" + esc(md.Title) + "</rdf:li></rdf:Alt></dc:title>\n")
+	}
+	if len(md.Author) > 0 {
+		b.WriteString("<dc:creator><rdf:Seq>")
+		for _, a := range md.Author {
+			b.WriteString("<rdf:li>" + esc(a) + "</rdf:li>")
+		}
+		b.WriteString("</rdf:Seq></dc:creator>\n")
+	}
+	if md.Subject != "" {
+		b.WriteString("<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">" + esc(md.Subject) + "</rdf:li></rdf:Alt></dc:description>\n")
+	}
+	if len(md.Keywords) > 0 {
+		b.WriteString("<dc:subject><rdf:Bag>")
+		for _, k := range md.Keywords {
+			b.WriteString("<rdf:li>" + esc(k) + "</rdf:li>")
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+		b.WriteString("<pdf:Keywords>" + esc(strings.Join(md.Keywords, ", ")) + "</pdf:Keywords>\n")
+	}
+	if md.Lang != "" {
+		b.WriteString("<dc:language><rdf:Bag><rdf:li>" + esc(md.Lang) + "</rdf:li></rdf:Bag></dc:language>\n")
+	}
+	b.WriteString("<pdf:Producer>" + esc("pdfcpu "+model.VersionStr) + "</pdf:Producer>\n")
+	if md.Creator != "" {
+		b.WriteString("<xmp:CreatorTool>" + esc(md.Creator) + "</xmp:CreatorTool>\n")
+	}
+	b.WriteString("<xmp:CreateDate>" + created + "</xmp:CreateDate>\n")
+	b.WriteString("<xmp:ModifyDate>" + date + "</xmp:ModifyDate>\n")
+	b.WriteString("<xmp:MetadataDate>" + date + "</xmp:MetadataDate>\n")
+	b.WriteString(extra)
+	b.WriteString("</rdf:Description>\n")
+	b.WriteString("</rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.String()
+}
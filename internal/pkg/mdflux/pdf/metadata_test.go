@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestWriteMetadataCreationDate(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	out, err := WriteMetadata(readFixture(t), Metadata{Title: "Fixture", CreationDate: created}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readContext(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := infoText(ctx, "CreationDate"), types.DateString(created); got != want {
+		t.Errorf("CreationDate = %q, want %q", got, want)
+	}
+	if got := infoText(ctx, "ModDate"); got == types.DateString(created) {
+		t.Errorf("ModDate = %q, want the time of writing", got)
+	}
+	if got, want := infoText(ctx, "Title"), "Fixture"; got != want {
+		t.Errorf("Title = %q, want %q", got, want)
+	}
+}
+
+func TestWriteMetadataEncrypted(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	enc := &Encryption{UserPassword: "user", OwnerPassword: "owner", AllowPrint: true}
+	out, err := WriteMetadata(readFixture(t), Metadata{Title: "Fixture", CreationDate: created}, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readContext(out); err == nil {
+		t.Fatal("read the encrypted PDF without a password")
+	}
+
+	conf := pdfcpuConfig()
+	conf.UserPW = "user"
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(out), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := infoText(ctx, "CreationDate"), types.DateString(created); got != want {
+		t.Errorf("CreationDate = %q, want %q", got, want)
+	}
+	if got, want := infoText(ctx, "Title"), "Fixture"; got != want {
+		t.Errorf("Title = %q, want %q", got, want)
+	}
+}
+
+func TestWriteMetadataEncryptionWithoutOwnerPassword(t *testing.T) {
+	if _, err := WriteMetadata(readFixture(t), Metadata{}, &Encryption{UserPassword: "user"}); err == nil {
+		t.Error("encrypted without an owner password")
+	}
+}
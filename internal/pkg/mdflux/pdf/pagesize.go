@@ -0,0 +1,74 @@
+package pdf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pageSizes maps the named paper sizes to their portrait width and height
+// in inches.
+var pageSizes = map[string][2]float64{
+	"a3":        {11.69, 16.54},
+	"a4":        {8.27, 11.69},
+	"a5":        {5.83, 8.27},
+	"b5":        {6.93, 9.84},
+	"letter":    {8.5, 11.0},
+	"legal":     {8.5, 14.0},
+	"tabloid":   {11.0, 17.0},
+	"executive": {7.25, 10.5},
+}
+
+var (
+	customPageSize = regexp.MustCompile(`^\s*(\S+)\s*[xX×]\s*(\S+)\s*$`)
+	lengthPattern  = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*(mm|cm|in|pt)$`)
+)
+
+var unitsPerInch = map[string]float64{
+	"mm": 25.4,
+	"cm": 2.54,
+	"in": 1,
+	"pt": 72,
+}
+
+// ParsePageSize returns the width and height in inches of a named paper
+// size such as "A4" or "Letter", or of an explicit size such as
+// "210mm x 297mm" or "6in x 9in". Names are case-insensitive and an empty
+// size means A4.
+func ParsePageSize(size string) (width, height float64, err error) {
+	if size == "" {
+		size = "A4"
+	}
+	if s, ok := pageSizes[strings.ToLower(strings.TrimSpace(size))]; ok {
+		return s[0], s[1], nil
+	}
+
+	m := customPageSize.FindStringSubmatch(size)
+	if m == nil {
+		return 0, 0, fmt.Errorf("unknown page size %q (use A3, A4, A5, B5, Letter, Legal, Tabloid, Executive or \"width x height\" such as \"210mm x 297mm\")", size)
+	}
+	if width, err = ParseLength(m[1]); err != nil {
+		return 0, 0, fmt.Errorf("page size %q: %w", size, err)
+	}
+	if height, err = ParseLength(m[2]); err != nil {
+		return 0, 0, fmt.Errorf("page size %q: %w", size, err)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("page size %q must be positive", size)
+	}
+	return width, height, nil
+}
+
+// ParseLength converts a length with a unit (mm, cm, in or pt) to inches.
+func ParseLength(s string) (float64, error) {
+	m := lengthPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid length %q (expected a number followed by mm, cm, in or pt)", s)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length %q: %w", s, err)
+	}
+	return v / unitsPerInch[m[2]], nil
+}
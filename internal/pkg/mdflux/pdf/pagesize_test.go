@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParsePageSize(t *testing.T) {
+	tests := []struct {
+		size          string
+		width, height float64
+	}{
+		{"", 8.27, 11.69},
+		{"A4", 8.27, 11.69},
+		{" letter ", 8.5, 11},
+		{"Legal", 8.5, 14},
+		{"TABLOID", 11, 17},
+		{"210mm x 297mm", 210 / 25.4, 297 / 25.4},
+		{"14.8cm X 21cm", 14.8 / 2.54, 21 / 2.54},
+		{"6in x 9in", 6, 9},
+		{"432pt×648pt", 6, 9},
+		{"5.5in x 215.9mm", 5.5, 8.5},
+		{".5in x 1in", 0.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			width, height, err := ParsePageSize(tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(width-tt.width) > 1e-9 || math.Abs(height-tt.height) > 1e-9 {
+				t.Errorf("ParsePageSize(%q) = %v x %v, want %v x %v", tt.size, width, height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestParsePageSizeErrors(t *testing.T) {
+	tests := []struct {
+		size string
+		want string
+	}{
+		{"A6", `unknown page size "A6"`},
+		{"210mm", `unknown page size "210mm"`},
+		{"210mm x 297mm x 10mm", `unknown page size`},
+		{"210 x 297", `invalid length "210"`},
+		{"210px x 297px", `invalid length "210px"`},
+		{"-210mm x 297mm", `invalid length "-210mm"`},
+		{"0mm x 297mm", "must be positive"},
+		{"210mm x 0in", "must be positive"},
+		{"1.2.3in x 9in", `invalid length "1.2.3in"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			_, _, err := ParsePageSize(tt.size)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParsePageSize(%q) error = %v, want %q", tt.size, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package pdf
+
+import "strings"
+
+// Pagination controls where the browser may break pages when printing.
+type Pagination struct {
+	// KeepHeadingsWithNext avoids a page break between a heading and the
+	// block that follows it.
+	KeepHeadingsWithNext bool
+	// AvoidBreakInside keeps table rows, diagrams, figures, display math
+	// and code blocks on one page where they fit.
+	AvoidBreakInside bool
+	// H1NewPage starts every top-level heading on a new page.
+	H1NewPage bool
+}
+
+// CSS returns print rules implementing p. The rules only apply to print
+// media and leave the screen rendering unchanged.
+func (p Pagination) CSS() string {
+	if !p.KeepHeadingsWithNext && !p.AvoidBreakInside && !p.H1NewPage {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n@media print {\n")
+	if p.KeepHeadingsWithNext {
+		b.WriteString("  h1, h2, h3, h4, h5, h6 { break-after: avoid; }\n")
+		b.WriteString("  h1 + *, h2 + *, h3 + *, h4 + *, h5 + *, h6 + * { break-before: avoid; }\n")
+	}
+	if p.AvoidBreakInside {
+		b.WriteString("  tr, pre, figure, .mermaid, .d2, .katex-display, .equation { break-inside: avoid; }\n")
+	}
+	if p.H1NewPage {
+		b.WriteString("  h1 { break-before: page; }\n")
+		b.WriteString("  body > h1:first-child, .chapter > h1:first-child { break-before: auto; }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
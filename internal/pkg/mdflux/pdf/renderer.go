@@ -4,21 +4,34 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"github.com/rs/zerolog/log"
 )
 
 type Options struct {
-	PageSize     string
-	Landscape    bool
-	Scale        float64
-	MarginTop    float64
-	MarginBottom float64
-	MarginLeft   float64
-	MarginRight  float64
-	ChromeMode   string
-	ChromePath   string
+	PageSize          string
+	Landscape         bool
+	Scale             float64
+	MarginTop         float64
+	MarginBottom      float64
+	MarginLeft        float64
+	MarginRight       float64
+	MirrorMargins     bool
+	Outline           bool
+	PreferCSSPageSize bool
+	Pagination        Pagination
+	Metadata          Metadata
+	Archival          string
+	CoverHTMLPath     string
+	Prepend           []string
+	Append            []string
+	Encryption        *Encryption
+	ChromeMode        string
+	ChromePath        string
 }
 
 func DefaultOptions() Options {
@@ -30,12 +43,83 @@ func DefaultOptions() Options {
 		MarginBottom: 0.5,
 		MarginLeft:   0.5,
 		MarginRight:  0.5,
-		ChromeMode:   "auto",
-		ChromePath:   "",
+		Outline:      true,
+		Pagination: Pagination{
+			KeepHeadingsWithNext: true,
+			AvoidBreakInside:     true,
+		},
+		ChromeMode: "auto",
+		ChromePath: "",
 	}
 }
 
 func RenderHTMLToPDF(htmlFilePath, pdfFilePath string, opts Options) error {
+	ctx, cancel := newBrowser(opts.ChromeMode, opts.ChromePath)
+	defer cancel()
+
+	buf, err := printHTML(ctx, htmlFilePath, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.CoverHTMLPath != "" || len(opts.Prepend) > 0 || len(opts.Append) > 0 {
+		var parts []Part
+		if opts.CoverHTMLPath != "" {
+			coverOpts := opts
+			coverOpts.Outline = false
+			cover, err := printHTML(ctx, opts.CoverHTMLPath, coverOpts)
+			if err != nil {
+				return fmt.Errorf("failed to render cover: %w", err)
+			}
+			parts = append(parts, Part{Title: "Cover", Front: true, PDF: cover})
+		}
+		prepend, err := readParts(opts.Prepend, true)
+		if err != nil {
+			return err
+		}
+		appended, err := readParts(opts.Append, false)
+		if err != nil {
+			return err
+		}
+		parts = append(append(append(parts, prepend...), Part{PDF: buf}), appended...)
+
+		if buf, err = Merge(parts); err != nil {
+			return fmt.Errorf("failed to merge PDFs: %w", err)
+		}
+	}
+
+	var report *ArchivalReport
+	if opts.Archival == ArchivalPDFA2B {
+		buf, report, err = ConvertPDFA2B(buf, opts.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to convert to PDF/A-2b: %w", err)
+		}
+	} else {
+		buf, err = WriteMetadata(buf, opts.Metadata, opts.Encryption)
+		if err != nil {
+			return fmt.Errorf("failed to write PDF metadata: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(pdfFilePath, buf, 0644); err != nil {
+		return fmt.Errorf("os.WriteFile() failed: %w", err)
+	}
+
+	if report != nil {
+		if !report.OK() {
+			return &ArchivalError{Level: opts.Archival, Report: report}
+		}
+		for _, f := range report.Fixed {
+			log.Info().Str("level", opts.Archival).Str("fix", f).Msg("Repaired archival conformance issue")
+		}
+	}
+
+	return nil
+}
+
+// newBrowser starts a headless Chrome. The returned function shuts it
+// down.
+func newBrowser(chromeMode, chromePath string) (context.Context, context.CancelFunc) {
 	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Headless,
 		chromedp.DisableGPU,
@@ -47,32 +131,53 @@ func RenderHTMLToPDF(htmlFilePath, pdfFilePath string, opts Options) error {
 		chromedp.Flag("disable-extensions", true),
 	)
 
-	if opts.ChromeMode == "manual" && opts.ChromePath != "" {
-		allocOpts = append(allocOpts, chromedp.ExecPath(opts.ChromePath))
+	if chromeMode == "manual" && chromePath != "" {
+		allocOpts = append(allocOpts, chromedp.ExecPath(chromePath))
 	}
 
 	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), allocOpts...)
-	defer allocCancel()
-
 	ctx, ctxCancel := chromedp.NewContext(allocCtx)
-	defer ctxCancel()
+	return ctx, func() {
+		ctxCancel()
+		allocCancel()
+	}
+}
 
+// printHTML prints an HTML file with the browser of ctx.
+func printHTML(ctx context.Context, htmlFilePath string, opts Options) ([]byte, error) {
 	var buf []byte
-	if err := chromedp.Run(ctx, printToPDFTasks(htmlFilePath, &buf, opts)); err != nil {
-		return fmt.Errorf("chromedp.Run() failed: %w", err)
+	tasks, err := printToPDFTasks(htmlFilePath, &buf, opts)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := os.WriteFile(pdfFilePath, buf, 0644); err != nil {
-		return fmt.Errorf("os.WriteFile() failed: %w", err)
+	if err := chromedp.Run(ctx, tasks); err != nil {
+		return nil, fmt.Errorf("chromedp.Run() failed: %w", err)
 	}
+	return buf, nil
+}
 
-	return nil
+// readParts loads existing PDFs for merging. Each gets a bookmark named
+// after its file.
+func readParts(paths []string, front bool) ([]Part, error) {
+	var parts []Part
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PDF to merge: %w", err)
+		}
+		title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		parts = append(parts, Part{Title: title, Front: front, PDF: b})
+	}
+	return parts, nil
 }
 
-func printToPDFTasks(htmlPath string, buffer *[]byte, opts Options) chromedp.Tasks {
+func printToPDFTasks(htmlPath string, buffer *[]byte, opts Options) (chromedp.Tasks, error) {
 	fileURL := "file://" + htmlPath
 
-	paperWidth, paperHeight := getPaperSize(opts.PageSize)
+	paperWidth, paperHeight, err := ParsePageSize(opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
 	if opts.Landscape {
 		paperWidth, paperHeight = paperHeight, paperWidth
 	}
@@ -93,7 +198,10 @@ func printToPDFTasks(htmlPath string, buffer *[]byte, opts Options) chromedp.Tas
 				WithMarginTop(opts.MarginTop).
 				WithMarginBottom(opts.MarginBottom).
 				WithMarginLeft(opts.MarginLeft).
-				WithMarginRight(opts.MarginRight)
+				WithMarginRight(opts.MarginRight).
+				WithGenerateTaggedPDF(opts.Outline).
+				WithGenerateDocumentOutline(opts.Outline).
+				WithPreferCSSPageSize(opts.PreferCSSPageSize)
 
 			pdfBuffer, _, err := pdfConfig.Do(ctx)
 			if err != nil {
@@ -102,18 +210,5 @@ func printToPDFTasks(htmlPath string, buffer *[]byte, opts Options) chromedp.Tas
 			*buffer = pdfBuffer
 			return nil
 		}),
-	}
-}
-
-func getPaperSize(size string) (width, height float64) {
-	switch size {
-	case "Letter":
-		return 8.5, 11.0
-	case "Legal":
-		return 8.5, 14.0
-	case "A4":
-		fallthrough
-	default:
-		return 8.27, 11.69
-	}
+	}, nil
 }
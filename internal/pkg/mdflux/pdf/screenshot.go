@@ -0,0 +1,180 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatJPEG = "jpeg"
+
+	defaultImageWidth   = 1200
+	defaultImageHeight  = 800
+	defaultImageQuality = 90
+)
+
+// ImageOptions configures screenshots of a rendered document.
+type ImageOptions struct {
+	// Format is ImageFormatPNG or ImageFormatJPEG.
+	Format string
+	// Width is the viewport width in CSS pixels.
+	Width int
+	// DeviceScaleFactor multiplies the pixel size of the image, 2 gives
+	// sharp images on high density displays.
+	DeviceScaleFactor float64
+	// TileHeight splits the page into images of at most this many CSS
+	// pixels. Zero captures the full page as one image.
+	TileHeight int
+	// Quality is the JPEG quality from 1 to 100.
+	Quality    int
+	ChromeMode string
+	ChromePath string
+}
+
+// ParseImageFormat normalises an image output format. "jpg" is accepted
+// for ImageFormatJPEG.
+func ParseImageFormat(format string) (string, error) {
+	switch format {
+	case ImageFormatPNG:
+		return ImageFormatPNG, nil
+	case ImageFormatJPEG, "jpg":
+		return ImageFormatJPEG, nil
+	default:
+		return "", fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// Validate checks the image options.
+func (o ImageOptions) Validate() error {
+	if _, err := ParseImageFormat(o.Format); err != nil {
+		return err
+	}
+	if o.Width < 0 {
+		return fmt.Errorf("width must not be negative, got %d", o.Width)
+	}
+	if o.DeviceScaleFactor < 0 {
+		return fmt.Errorf("device scale factor must not be negative, got %g", o.DeviceScaleFactor)
+	}
+	if o.TileHeight < 0 {
+		return fmt.Errorf("tile height must not be negative, got %d", o.TileHeight)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100, got %d", o.Quality)
+	}
+	return nil
+}
+
+// RenderHTMLToImages takes screenshots of an HTML file. It returns one
+// image for the full page, or one image per tile from top to bottom.
+func RenderHTMLToImages(htmlFilePath string, opts ImageOptions) ([][]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	format, _ := ParseImageFormat(opts.Format)
+
+	width := opts.Width
+	if width == 0 {
+		width = defaultImageWidth
+	}
+	height := opts.TileHeight
+	if height == 0 {
+		height = defaultImageHeight
+	}
+	scale := opts.DeviceScaleFactor
+	if scale == 0 {
+		scale = 1
+	}
+	quality := opts.Quality
+	if quality == 0 {
+		quality = defaultImageQuality
+	}
+
+	ctx, cancel := newBrowser(opts.ChromeMode, opts.ChromePath)
+	defer cancel()
+
+	var images [][]byte
+	err := chromedp.Run(ctx,
+		emulation.SetDeviceMetricsOverride(int64(width), int64(height), scale, false),
+		chromedp.Navigate("file://"+htmlFilePath),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			_, _, _, _, _, content, err := page.GetLayoutMetrics().Do(ctx)
+			if err != nil {
+				return err
+			}
+			if content == nil {
+				return errors.New("failed to measure page")
+			}
+			pageHeight := math.Max(1, math.Ceil(content.Height))
+
+			tile := pageHeight
+			if opts.TileHeight > 0 {
+				tile = float64(opts.TileHeight)
+			}
+			for y := 0.0; y < pageHeight; y += tile {
+				capture := page.CaptureScreenshot().
+					WithCaptureBeyondViewport(true).
+					WithClip(&page.Viewport{
+						Width:  float64(width),
+						Y:      y,
+						Height: math.Min(tile, pageHeight-y),
+						Scale:  1,
+					})
+				if format == ImageFormatJPEG {
+					capture = capture.WithFormat(page.CaptureScreenshotFormatJpeg).WithQuality(int64(quality))
+				} else {
+					capture = capture.WithFormat(page.CaptureScreenshotFormatPng)
+				}
+				img, err := capture.Do(ctx)
+				if err != nil {
+					return err
+				}
+				images = append(images, img)
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("chromedp.Run() failed: %w", err)
+	}
+	return images, nil
+}
+
+// CaptureElements takes a PNG screenshot of the first element matching
+// selector in each HTML file, in one browser session. Width and
+// DeviceScaleFactor of opts apply, the other image options are ignored.
+func CaptureElements(htmlFilePaths []string, selector string, opts ImageOptions) ([][]byte, error) {
+	width := opts.Width
+	if width == 0 {
+		width = defaultImageWidth
+	}
+	scale := opts.DeviceScaleFactor
+	if scale == 0 {
+		scale = 1
+	}
+
+	ctx, cancel := newBrowser(opts.ChromeMode, opts.ChromePath)
+	defer cancel()
+
+	if err := chromedp.Run(ctx, emulation.SetDeviceMetricsOverride(int64(width), defaultImageHeight, 1, false)); err != nil {
+		return nil, fmt.Errorf("chromedp.Run() failed: %w", err)
+	}
+
+	images := make([][]byte, len(htmlFilePaths))
+	for i, path := range htmlFilePaths {
+		err := chromedp.Run(ctx,
+			chromedp.Navigate("file://"+path),
+			chromedp.ScreenshotScale(selector, scale, &images[i], chromedp.ByQuery),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("chromedp.Run() failed for %s: %w", path, err)
+		}
+	}
+	return images, nil
+}
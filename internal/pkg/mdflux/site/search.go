@@ -0,0 +1,78 @@
+package site
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+const searchIndexFile = "search-index.json"
+
+// searchIndexVersion is the version of the search index schema.
+const searchIndexVersion = 1
+
+type searchIndex struct {
+	Version int            `json:"version"`
+	Pages   []*searchEntry `json:"pages"`
+}
+
+// searchEntry is a page of the search index. The URL is relative to the
+// site root.
+type searchEntry struct {
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	Text        string `json:"text"`
+}
+
+// writeSearchIndex writes the title and plain text of every page to
+// search-index.json, which the search box of the sidebar loads.
+func (b *builder) writeSearchIndex() error {
+	index := searchIndex{Version: searchIndexVersion, Pages: []*searchEntry{}}
+	for _, p := range b.pages {
+		if p.doc == nil {
+			continue
+		}
+		e := &searchEntry{Title: p.title, URL: p.url, Text: pageText(p.doc)}
+		if p.doc.Meta != nil {
+			e.Description = p.doc.Meta.Description
+		}
+		index.Pages = append(index.Pages, e)
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode search index: %w", err)
+	}
+	if err := os.WriteFile(b.outputPath(searchIndexFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	return nil
+}
+
+// pageText returns the text of the blocks of doc, including code, with
+// whitespace collapsed.
+func pageText(doc *converter.Document) string {
+	var parts []string
+	_ = ast.Walk(doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Type() != ast.TypeBlock {
+			return ast.WalkContinue, nil
+		}
+		switch n.(type) {
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			parts = append(parts, string(n.Lines().Value(doc.Source)))
+			return ast.WalkSkipChildren, nil
+		}
+		if c := n.FirstChild(); c != nil && c.Type() == ast.TypeInline {
+			parts = append(parts, converter.PlainText(n, doc.Source))
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
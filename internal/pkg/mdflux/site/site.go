@@ -0,0 +1,451 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+
+	"mdflux/internal/pkg/mdflux/converter"
+)
+
+const (
+	indexPage    = "index.html"
+	defaultTitle = "Documentation"
+)
+
+// Options configures a site build.
+type Options struct {
+	// Source is the directory of markdown files.
+	Source string
+	// Output is the directory the site is written to. Existing files are
+	// overwritten, other files are left alone.
+	Output string
+	// Title is the site title. It defaults to the title of the index page.
+	Title string
+	// Search writes a search index and adds a search box to the sidebar.
+	Search bool
+}
+
+// page is a markdown file of the site, or the generated index page when
+// doc is nil.
+type page struct {
+	// rel is the source path relative to the source directory.
+	rel string
+	// url is the output path relative to the output directory.
+	url   string
+	title string
+	doc   *converter.Document
+}
+
+// dir is a directory of the site. Its index page, index.md or README.md,
+// becomes index.html and links the directory in the sidebar.
+type dir struct {
+	rel   string
+	name  string
+	index *page
+	pages []*page
+	dirs  []*dir
+}
+
+// navEntry is a link of the sidebar or the generated index page. URLs are
+// relative to the page being written.
+type navEntry struct {
+	Title       string
+	URL         string
+	Description string
+	Current     bool
+	Children    []*navEntry
+}
+
+type navData struct {
+	Title   string
+	Root    string
+	Search  bool
+	Entries []*navEntry
+}
+
+type pagerData struct {
+	Prev, Next *navEntry
+}
+
+type indexData struct {
+	Title   string
+	Entries []*navEntry
+}
+
+type builder struct {
+	conv  *converter.Converter
+	opts  Options
+	title string
+	root  *dir
+	// pages are in reading order, the order of the sidebar.
+	pages  []*page
+	byRel  map[string]*page
+	assets []string
+	// output is the absolute output directory, which is skipped when it
+	// lies within the source directory.
+	output string
+}
+
+// Build converts every markdown file below opts.Source into an HTML page of
+// a static site in opts.Output. Pages share a layout with a sidebar that
+// follows the directory tree, and prev/next links in sidebar order. Links
+// to markdown files are rewritten to the pages, other files are copied,
+// and an index page is generated unless the source has one. It returns the
+// number of pages written.
+func Build(conv *converter.Converter, opts Options) (int, error) {
+	output, err := filepath.Abs(opts.Output)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	b := &builder{
+		conv:   conv,
+		opts:   opts,
+		root:   &dir{},
+		byRel:  map[string]*page{},
+		output: output,
+	}
+
+	if err := b.scan(b.root, opts.Source); err != nil {
+		return 0, err
+	}
+	if b.root.index == nil {
+		if len(b.byRel) == 0 {
+			return 0, fmt.Errorf("no markdown files in %s", opts.Source)
+		}
+		b.root.index = &page{url: indexPage}
+	}
+	b.root.walk(func(p *page) {
+		b.pages = append(b.pages, p)
+	})
+
+	for _, p := range b.pages {
+		if err := b.parse(p); err != nil {
+			return 0, err
+		}
+	}
+
+	b.title = opts.Title
+	if b.title == "" && b.root.index.doc != nil {
+		b.title = b.root.index.title
+	}
+	if b.title == "" {
+		b.title = defaultTitle
+	}
+	if b.root.index.doc == nil {
+		b.root.index.title = b.title
+	}
+
+	for _, p := range b.pages {
+		if p.doc != nil {
+			b.rewriteLinks(p)
+		}
+	}
+
+	for i, p := range b.pages {
+		var prev, next *page
+		if i > 0 {
+			prev = b.pages[i-1]
+		}
+		if i < len(b.pages)-1 {
+			next = b.pages[i+1]
+		}
+		if err := b.writePage(p, prev, next); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, rel := range b.assets {
+		if err := copyFile(filepath.Join(opts.Source, filepath.FromSlash(rel)), b.outputPath(rel)); err != nil {
+			return 0, err
+		}
+	}
+
+	if opts.Search {
+		if err := b.writeSearchIndex(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b.pages), nil
+}
+
+// scan collects the markdown files and other files of a directory and its
+// subdirectories in name order. Hidden files are skipped.
+func (b *builder) scan(d *dir, dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read source directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		p := filepath.Join(dirPath, name)
+		rel := path.Join(d.rel, name)
+
+		if e.IsDir() {
+			if abs, err := filepath.Abs(p); err == nil && abs == b.output {
+				continue
+			}
+			sub := &dir{rel: rel, name: name}
+			if err := b.scan(sub, p); err != nil {
+				return err
+			}
+			if sub.index != nil || len(sub.pages) > 0 || len(sub.dirs) > 0 {
+				d.dirs = append(d.dirs, sub)
+			}
+			continue
+		}
+
+		if !isMarkdown(name) {
+			b.assets = append(b.assets, rel)
+			continue
+		}
+		d.pages = append(d.pages, &page{rel: rel, url: strings.TrimSuffix(rel, path.Ext(rel)) + ".html"})
+	}
+
+	for _, name := range []string{"index", "readme"} {
+		for i, p := range d.pages {
+			if strings.EqualFold(strings.TrimSuffix(path.Base(p.rel), path.Ext(p.rel)), name) {
+				d.index = p
+				d.pages = append(d.pages[:i], d.pages[i+1:]...)
+				p.url = path.Join(d.rel, indexPage)
+				break
+			}
+		}
+		if d.index != nil {
+			break
+		}
+	}
+
+	for _, p := range d.pages {
+		b.byRel[p.rel] = p
+	}
+	if d.index != nil {
+		b.byRel[d.index.rel] = d.index
+	}
+	return nil
+}
+
+// walk calls fn for the pages of d in reading order: the index page, the
+// pages of d and then the subdirectories.
+func (d *dir) walk(fn func(*page)) {
+	if d.index != nil {
+		fn(d.index)
+	}
+	for _, p := range d.pages {
+		fn(p)
+	}
+	for _, sub := range d.dirs {
+		sub.walk(fn)
+	}
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// parse reads and parses the page. The title is the front matter title,
+// the first level 1 heading or the file name.
+func (b *builder) parse(p *page) error {
+	if p.rel == "" {
+		return nil
+	}
+	filePath := filepath.Join(b.opts.Source, filepath.FromSlash(p.rel))
+	source, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read page: %w", err)
+	}
+	pc := parser.NewContext()
+	converter.WithIncludeRoot(pc, b.opts.Source)
+	doc, err := b.conv.Parse(source, filePath, pc)
+	if err != nil {
+		return fmt.Errorf("page %s: %w", p.rel, err)
+	}
+	p.doc = doc
+
+	if doc.Meta != nil && doc.Meta.Title != "" {
+		p.title = doc.Meta.Title
+		return nil
+	}
+	for _, h := range converter.Headings(doc) {
+		if h.Level == 1 {
+			p.title = h.Text
+			return nil
+		}
+	}
+	p.title = strings.TrimSuffix(path.Base(p.rel), path.Ext(p.rel))
+	return nil
+}
+
+// rewriteLinks points relative links to markdown files at the HTML pages.
+// Links to files outside the site only have their extension replaced.
+func (b *builder) rewriteLinks(p *page) {
+	_ = ast.Walk(p.doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		link, ok := n.(*ast.Link)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		u, err := url.Parse(string(link.Destination))
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || path.IsAbs(u.Path) || !isMarkdown(u.Path) {
+			return ast.WalkContinue, nil
+		}
+		if target, ok := b.byRel[path.Join(path.Dir(p.rel), u.Path)]; ok {
+			u.Path = relURL(p.url, target.url)
+		} else {
+			u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path)) + ".html"
+		}
+		link.Destination = []byte(u.String())
+		return ast.WalkContinue, nil
+	})
+}
+
+// relURL returns the URL of the output path to relative to the page at
+// output path from. An empty to gives the site root.
+func relURL(from, to string) string {
+	fromDirs := strings.Split(from, "/")
+	fromDirs = fromDirs[:len(fromDirs)-1]
+	toParts := strings.Split(to, "/")
+	common := 0
+	for common < len(fromDirs) && common < len(toParts)-1 && fromDirs[common] == toParts[common] {
+		common++
+	}
+	return strings.Repeat("../", len(fromDirs)-common) + strings.Join(toParts[common:], "/")
+}
+
+func (b *builder) writePage(p, prev, next *page) error {
+	outPath := b.outputPath(p.url)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create page: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warn().Err(err).Str("file", outPath).Msg("Failed to close page")
+		}
+	}()
+
+	if err := b.renderPage(f, p, prev, next); err != nil {
+		return fmt.Errorf("page %s: %w", p.url, err)
+	}
+	log.Debug().Str("file", outPath).Msg("Wrote page")
+	return nil
+}
+
+func (b *builder) renderPage(w io.Writer, p, prev, next *page) error {
+	tmpl := b.conv.Templates().Template()
+
+	header := converter.DocumentHeader(p.doc)
+	header.Title = p.title
+	if p.title != b.title {
+		header.Title += " - " + b.title
+	}
+	if err := b.conv.WriteHeader(w, header); err != nil {
+		return err
+	}
+
+	nav := navData{
+		Title:   b.title,
+		Root:    relURL(p.url, ""),
+		Search:  b.opts.Search,
+		Entries: b.navEntries(b.root, p),
+	}
+	if err := tmpl.ExecuteTemplate(w, "site-nav", nav); err != nil {
+		return fmt.Errorf("failed to execute site-nav template: %w", err)
+	}
+
+	if p.doc != nil {
+		if err := b.conv.RenderBody(w, p.doc); err != nil {
+			return err
+		}
+	} else {
+		index := indexData{Title: b.title, Entries: b.navEntries(b.root, p)}
+		if err := tmpl.ExecuteTemplate(w, "site-index", index); err != nil {
+			return fmt.Errorf("failed to execute site-index template: %w", err)
+		}
+	}
+
+	pager := pagerData{}
+	if prev != nil {
+		pager.Prev = b.entry(prev, p)
+	}
+	if next != nil {
+		pager.Next = b.entry(next, p)
+	}
+	if err := tmpl.ExecuteTemplate(w, "site-pager", pager); err != nil {
+		return fmt.Errorf("failed to execute site-pager template: %w", err)
+	}
+
+	return b.conv.WriteFooter(w)
+}
+
+// navEntries lists the pages and subdirectories of d as seen from the page
+// cur. The index page of d is linked from the directory entry instead.
+func (b *builder) navEntries(d *dir, cur *page) []*navEntry {
+	var entries []*navEntry
+	for _, p := range d.pages {
+		entries = append(entries, b.entry(p, cur))
+	}
+	for _, sub := range d.dirs {
+		e := &navEntry{Title: sub.name}
+		if sub.index != nil {
+			e = b.entry(sub.index, cur)
+		}
+		e.Children = b.navEntries(sub, cur)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (b *builder) entry(p, cur *page) *navEntry {
+	e := &navEntry{Title: p.title, URL: relURL(cur.url, p.url), Current: p == cur}
+	if p.doc != nil && p.doc.Meta != nil {
+		e.Description = p.doc.Meta.Description
+	}
+	return e
+}
+
+func (b *builder) outputPath(rel string) string {
+	return filepath.Join(b.opts.Output, filepath.FromSlash(rel))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}